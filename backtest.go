@@ -85,6 +85,22 @@ func (backtest *Backtest) Update(value float64) *Backtest {
 	return backtest
 }
 
+// returns an AfterTick hook that records the value at every scheduler tick
+func (backtest *Backtest) Snapshot(
+	value func(now time.Time) (float64, error),
+) TickHook {
+	return func(now time.Time) error {
+		v, err := value(now)
+		if err != nil {
+			return err
+		}
+
+		backtest.Update(v)
+
+		return nil
+	}
+}
+
 // metrics
 const YEAR float64 = 3.1536e+16
 
@@ -110,6 +110,32 @@ func Test_UpdateSubsequent(t *testing.T) {
 	}
 }
 
+func Test_Snapshot(t *testing.T) {
+	// create Backtest and Scheduler
+	backtest := NewBacktest(time.Hour)
+	scheduler := NewScheduler()
+
+	// mock
+	value := 100.
+	scheduler.AfterTick(backtest.Snapshot(func(now time.Time) (float64, error) {
+		value += 10
+		return value, nil
+	}))
+
+	// RunBetween()
+	start, _ := time.Parse(time.RFC3339, "2025-06-29T08:00:00Z")
+	err := scheduler.RunBetween(start, start.Add(3*time.Hour), time.Hour)
+	if err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	// assert
+	if backtest.N != 3 {
+		t.Errorf("N != 3: %d", backtest.N)
+	}
+	assertSlicesEqual(backtest.Values, []float64{110, 120, 130}, t)
+}
+
 // tests -> metrics
 func Test_MaxDrawdown(t *testing.T) {
 	// create Backtest
@@ -1,32 +1,42 @@
 package chrys
 
 import (
+	"fmt"
+	"github.com/haydenhigg/chrys/decimal"
 	"github.com/haydenhigg/chrys/driver"
+	"github.com/haydenhigg/chrys/market"
 	"github.com/haydenhigg/chrys/store"
-	"strings"
+	"sync"
 	"time"
 )
 
 type API interface {
 	store.BalanceAPI
 	store.FrameAPI
-	MarketOrder(side, pair string, quantity float64) error
+	MarketOrder(side string, pair market.Pair, quantity decimal.Decimal) error
 }
 
 type Client struct {
 	api      API
 	Frames   *store.FrameStore
 	Balances *store.BalanceStore
+	Prices   *store.PriceGraph
 	Fee      float64
 	IsLive   bool
+
+	// orders read, clamp to and then debit balances, so they run one at a time
+	orderMu sync.Mutex
 }
 
 // initializers
 func NewClient(api API) *Client {
+	frames := store.NewFrames(api)
+
 	return &Client{
 		api:      api,
-		Frames:   store.NewFrames(api),
+		Frames:   frames,
 		Balances: store.NewBalances(api),
+		Prices:   store.NewPriceGraph(frames),
 	}
 }
 
@@ -39,6 +49,19 @@ func NewKrakenClient(key, secret string) (*Client, error) {
 	return NewClient(kraken).SetFee(0.004), nil
 }
 
+func NewBinanceClient(key, secret string) *Client {
+	return NewClient(driver.NewBinance(key, secret)).SetFee(0.001)
+}
+
+func NewCoinbaseClient(keyName, privateKey string) (*Client, error) {
+	coinbase, err := driver.NewCoinbase(keyName, privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewClient(coinbase).SetFee(0.012), nil
+}
+
 func NewHistoricalClient(dataRoot, nameFmt string) *Client {
 	return NewClient(driver.NewHistorical(dataRoot, nameFmt))
 }
@@ -54,6 +77,18 @@ func (client *Client) SetIsLive(isLive bool) *Client {
 	return client
 }
 
+// only expose frames that have closed by the simulated time that now returns,
+// like a store.Clock's Now, so that a backtest can't look ahead
+func (client *Client) SetClock(now func() time.Time) *Client {
+	client.Frames.SetClock(now)
+
+	if historical, ok := client.api.(*driver.HistoricalDriver); ok {
+		historical.SetClock(now)
+	}
+
+	return client
+}
+
 // methods
 func (client *Client) Value(
 	quoteAsset string,
@@ -92,29 +127,33 @@ func (client *Client) Values(
 	}
 
 	// aggregate values
+	quoteAsset, _ = client.Balances.Aliased(quoteAsset)
 	for _, baseAsset := range baseAssets {
-		balance, ok := balances[baseAsset]
+		asset, _ := client.Balances.Aliased(baseAsset)
+		balance, ok := balances[asset]
 		if !ok {
 			continue
 		}
 
-		// add balance directly if asset is our quote asset
-		if baseAsset == quoteAsset {
-			values[baseAsset] = balance
-			continue
-		}
-
-		price, err := client.Frames.GetPriceAt(baseAsset+"/"+quoteAsset, t)
+		price, err := client.Prices.GetPriceAt(asset, quoteAsset, t)
 		if err != nil {
 			return values, err
 		}
 
-		values[baseAsset] = balance * price
+		values[baseAsset] = balance.Float() * price
 	}
 
 	return values, nil
 }
 
+// use the canonical names of a pair's assets, keeping its symbol
+func (client *Client) canonical(pair market.Pair) market.Pair {
+	pair.Base, _ = client.Balances.Aliased(pair.Base)
+	pair.Quote, _ = client.Balances.Aliased(pair.Quote)
+
+	return pair
+}
+
 type OrderSide string
 
 const (
@@ -124,13 +163,16 @@ const (
 
 func (client *Client) Order(
 	side OrderSide,
-	pair string,
+	pair market.Pair,
 	baseQuantity float64,
 	t time.Time,
 ) error {
-	// determine assets
-	assets := strings.SplitN(pair, "/", 2)
-	base, quote := assets[0], assets[1]
+	if err := pair.Validate(); err != nil {
+		return fmt.Errorf("%w %v: %w", market.ErrInvalidPair, pair, err)
+	}
+
+	client.orderMu.Lock()
+	defer client.orderMu.Unlock()
 
 	// determine order quantities
 	balances, err := client.Balances.Get()
@@ -138,59 +180,92 @@ func (client *Client) Order(
 		return err
 	}
 
-	baseQuantity = max(baseQuantity, 0)
-	if side == SELL && baseQuantity > balances[base] {
-		baseQuantity = balances[base]
+	// determine assets
+	p := client.canonical(pair)
+	base, quote := p.Base, p.Quote
+
+	// order quantities are exact, so that a sell never exceeds the balance
+	quantity, err := decimal.FromFloat(baseQuantity)
+	if err != nil {
+		return err
+	}
+
+	quantity = decimal.Max(quantity, 0)
+	if side == SELL {
+		quantity = decimal.Min(quantity, balances[base])
+	}
+
+	price, err := client.Frames.GetPriceAt(p, t)
+	if err != nil {
+		return err
 	}
 
-	price, err := client.Frames.GetPriceAt(pair, t)
+	quoteQuantity, err := quantity.MulFloat(price)
 	if err != nil {
 		return err
 	}
 
-	quoteQuantity := baseQuantity * price
 	if side == BUY && quoteQuantity > balances[quote] {
 		quoteQuantity = balances[quote]
-		baseQuantity = quoteQuantity / price
+		if quantity, err = quoteQuantity.DivFloat(price); err != nil {
+			return err
+		}
+	}
+
+	// what the order receives after the fee
+	received := quoteQuantity
+	if side == BUY {
+		received = quantity
+	}
+
+	if received, err = received.MulFloat(1 - client.Fee); err != nil {
+		return err
 	}
 
 	// place order
 	if client.IsLive {
-		err = client.api.MarketOrder(string(side), pair, baseQuantity)
+		err = client.api.MarketOrder(string(side), p, quantity)
 		if err != nil {
 			return err
 		}
 	}
 
 	// update balances
-	invFee := 1 - client.Fee
 	switch side {
 	case BUY:
-		client.Balances.Set(map[string]float64{
-			base:  baseQuantity * invFee,
-			quote: -quoteQuantity,
+		client.Balances.Set(map[string]decimal.Decimal{
+			base:  received,
+			quote: quoteQuantity.Neg(),
 		})
 	case SELL:
-		client.Balances.Set(map[string]float64{
-			base:  -baseQuantity,
-			quote: quoteQuantity * invFee,
+		client.Balances.Set(map[string]decimal.Decimal{
+			base:  quantity.Neg(),
+			quote: received,
 		})
 	}
 
 	return nil
 }
 
-func (client *Client) Buy(pair string, quantity float64, t time.Time) error {
+func (client *Client) Buy(
+	pair market.Pair,
+	quantity float64,
+	t time.Time,
+) error {
 	return client.Order(BUY, pair, quantity, t)
 }
 
-func (client *Client) Sell(pair string, quantity float64, t time.Time) error {
+func (client *Client) Sell(
+	pair market.Pair,
+	quantity float64,
+	t time.Time,
+) error {
 	return client.Order(SELL, pair, quantity, t)
 }
 
 func (client *Client) OrderPct(
 	side OrderSide,
-	pair string,
+	pair market.Pair,
 	percent float64,
 	t time.Time,
 ) error {
@@ -199,16 +274,23 @@ func (client *Client) OrderPct(
 		return err
 	}
 
-	base := strings.SplitN(pair, "/", 2)[0]
-
-	return client.Order(side, pair, percent*balances[base], t)
+	base := client.canonical(pair).Base
+	return client.Order(side, pair, percent*balances[base].Float(), t)
 }
 
-func (client *Client) BuyPct(pair string, percent float64, t time.Time) error {
+func (client *Client) BuyPct(
+	pair market.Pair,
+	percent float64,
+	t time.Time,
+) error {
 	return client.OrderPct(BUY, pair, percent, t)
 }
 
-func (client *Client) SellPct(pair string, percent float64, t time.Time) error {
+func (client *Client) SellPct(
+	pair market.Pair,
+	percent float64,
+	t time.Time,
+) error {
 	return client.OrderPct(SELL, pair, percent, t)
 }
 
@@ -274,24 +356,92 @@ func (client *Client) Reweight(
 			continue
 		}
 
-		pair := symbol + "/" + quoteSymbol
-		price, err := client.Frames.GetPriceAt(pair, t)
+		price, err := client.Prices.GetPriceAt(symbol, quoteSymbol, t)
+		if err != nil {
+			return err
+		}
+
+		hops, err := client.Prices.Hops(symbol, quoteSymbol, t)
 		if err != nil {
 			return err
 		}
 
 		quantityDelta := weightDelta * totalValue / price
 
-		// place order
+		// place orders along the route, spending the quote asset to buy
 		if quantityDelta > 0 {
-			err = client.Buy(pair, quantityDelta, t)
+			reversed := make([]store.Hop, len(hops))
+			for i, hop := range hops {
+				reversed[len(hops)-1-i] = hop.Reverse()
+			}
+
+			err = client.trade(reversed, weightDelta*totalValue, t)
 		} else if quantityDelta < 0 {
-			err = client.Sell(pair, -quantityDelta, t)
+			err = client.trade(hops, -quantityDelta, t)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// buy the base of a pair with a quantity of its quote
+func (client *Client) buyWith(
+	pair market.Pair,
+	quoteQuantity float64,
+	t time.Time,
+) error {
+	price, err := client.Frames.GetPriceAt(client.canonical(pair), t)
+	if err != nil {
+		return err
+	} else if price == 0 {
+		return fmt.Errorf("zero price of %s", pair)
+	}
+
+	return client.Order(BUY, pair, quoteQuantity/price, t)
+}
+
+// trade a quantity of an asset along a route of pairs, each time for all of
+// what the previous order received
+func (client *Client) trade(
+	hops []store.Hop,
+	quantity float64,
+	t time.Time,
+) error {
+	for _, hop := range hops {
+		balances, err := client.Balances.Get()
+		if err != nil {
+			return err
 		}
 
+		to, _ := client.Balances.Aliased(hop.To())
+		before := balances[to]
+
+		if hop.Inverse {
+			// the asset is the pair's quote, so it buys the pair's base
+			err = client.buyWith(hop.Pair, quantity, t)
+		} else {
+			err = client.Order(SELL, hop.Pair, quantity, t)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		balances, err = client.Balances.Get()
 		if err != nil {
 			return err
 		}
+
+		received, err := balances[to].Sub(before)
+		if err != nil {
+			return err
+		}
+
+		quantity = received.Float()
 	}
 
 	return nil
@@ -1,27 +1,37 @@
 package chrys
 
 import (
+	"errors"
+	"github.com/haydenhigg/chrys/decimal"
 	"github.com/haydenhigg/chrys/frame"
+	"github.com/haydenhigg/chrys/market"
+	"github.com/haydenhigg/chrys/store"
 	"math"
+	"sync"
 	"testing"
 	"time"
 )
 
 // mock
+var (
+	btcUSD = market.NewPair("BTC", "USD")
+	ethUSD = market.NewPair("ETH", "USD")
+)
+
 type MockAPI struct {
 	callback func()
 }
 
-func (api MockAPI) FetchBalances() (map[string]float64, error) {
-	return map[string]float64{
-		"USD": 133.7,
-		"BTC": 0.001337,
-		"ETH": 0.01337,
+func (api MockAPI) FetchBalances() (map[string]decimal.Decimal, error) {
+	return map[string]decimal.Decimal{
+		"USD": 13_370_000_000,
+		"BTC": 133_700,
+		"ETH": 1_337_000,
 	}, nil
 }
 
 func (api MockAPI) FetchFramesSince(
-	pair string,
+	pair market.Pair,
 	interval time.Duration,
 	since time.Time,
 ) ([]*frame.Frame, error) {
@@ -29,11 +39,13 @@ func (api MockAPI) FetchFramesSince(
 	i := 0.
 
 	price := 0.
-	switch pair {
+	switch pair.String() {
 	case "BTC/USD":
 		price = 88304.55
 	case "ETH/USD":
 		price = 2943.89
+	case "EUR/USD":
+		price = 1.25
 	}
 
 	// all frames that start !Before(since) and end !After(now-interval)
@@ -53,11 +65,37 @@ func (api MockAPI) FetchFramesSince(
 	return frames, nil
 }
 
-func (api MockAPI) MarketOrder(side, pair string, quantity float64) error {
+func (api MockAPI) MarketOrder(
+	side string,
+	pair market.Pair,
+	quantity decimal.Decimal,
+) error {
 	return nil
 }
 
+// a MockAPI that lists the pairs it has prices for
+type ListedMockAPI struct {
+	MockAPI
+}
+
+func (api ListedMockAPI) FetchPairs() ([]market.Pair, error) {
+	return []market.Pair{
+		market.NewPair("BTC", "USD"),
+		market.NewPair("ETH", "USD"),
+		market.NewPair("EUR", "USD"),
+	}, nil
+}
+
 // helpers
+func floats(balances map[string]decimal.Decimal) map[string]float64 {
+	converted := make(map[string]float64, len(balances))
+	for asset, balance := range balances {
+		converted[asset] = balance.Float()
+	}
+
+	return converted
+}
+
 func assertBalancesEqual(a, b map[string]float64, t *testing.T) {
 	for k, va := range a {
 		if vb, ok := b[k]; !ok {
@@ -107,6 +145,46 @@ func Test_SetIsLive(t *testing.T) {
 	}
 }
 
+func Test_SetClock(t *testing.T) {
+	// create Client and a scheduler that advances its clock
+	start := time.Now().Truncate(time.Hour).Add(-2 * time.Hour)
+	clock := store.NewClock(start)
+	client := NewClient(MockAPI{}).SetClock(clock.Now)
+
+	var lookAheadErr error
+	scheduler := NewScheduler().BeforeTick(clock.Advance)
+	scheduler.Add(time.Minute, func(now time.Time) error {
+		frames, err := client.Frames.GetSince(btcUSD, time.Minute, start)
+		if err != nil {
+			return err
+		}
+
+		// every frame has closed by now
+		last := frames[len(frames)-1]
+		if last.Time.Add(time.Minute).After(now) {
+			t.Errorf("frame closes after %v: %v", now, last)
+		}
+
+		_, lookAheadErr = client.Frames.GetPriceAt(btcUSD, now.Add(time.Hour))
+		return nil
+	})
+
+	// RunBetween()
+	err := scheduler.RunBetween(
+		start.Add(time.Minute),
+		start.Add(10*time.Minute),
+		time.Minute,
+	)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if !errors.Is(lookAheadErr, store.ErrLookAhead) {
+		t.Errorf("err != ErrLookAhead: %v", lookAheadErr)
+	}
+}
+
 // tests -> Value
 func Test_Value(t *testing.T) {
 	// create Client
@@ -131,8 +209,8 @@ func Test_ValueAliases(t *testing.T) {
 	// set aliases
 	client.Balances.
 		Alias("BTC", "XXBT").
-		Alias("ZETH", "ETH").
-		Alias("ZUSD", "USD")
+		Alias("ETH", "XETH").
+		Alias("USD", "ZUSD")
 
 	// Value()
 	value, err := client.Value("USD", []string{"USD", "ETH", "BTC"}, time.Now())
@@ -146,6 +224,26 @@ func Test_ValueAliases(t *testing.T) {
 	}
 }
 
+func Test_ValueCrossRate(t *testing.T) {
+	// create Client
+	client := NewClient(MockAPI{})
+	client.Prices.
+		AddPair("BTC", "USD").
+		AddPair("ETH", "USD").
+		AddPair("EUR", "USD")
+
+	// Value()
+	value, err := client.Value("EUR", []string{"USD", "ETH", "BTC"}, time.Now())
+	if err != nil {
+		t.Errorf("err: %v", err)
+	}
+
+	// assert
+	if math.Abs(value-291.1229927/1.25) > 1e-6 {
+		t.Errorf("value != %f: %f", 291.1229927/1.25, value)
+	}
+}
+
 // tests -> Order
 // tests -> Order -> Order
 func Test_OrderBuy(t *testing.T) {
@@ -153,14 +251,14 @@ func Test_OrderBuy(t *testing.T) {
 	client := NewClient(MockAPI{})
 
 	// OrderPct()
-	err := client.Order(BUY, "BTC/USD", 0.0002674, time.Now())
+	err := client.Order(BUY, btcUSD, 0.0002674, time.Now())
 	if err != nil {
 		t.Errorf("err: %v", err)
 	}
 
 	// assert
 	balances, _ := client.Balances.Get()
-	assertBalancesEqual(balances, map[string]float64{
+	assertBalancesEqual(floats(balances), map[string]float64{
 		"USD": 110.0873633,
 		"BTC": 0.0016041,
 		"ETH": 0.01337,
@@ -172,34 +270,110 @@ func Test_OrderSell(t *testing.T) {
 	client := NewClient(MockAPI{})
 
 	// OrderPct()
-	err := client.Order(SELL, "BTC/USD", 0.0006685, time.Now())
+	err := client.Order(SELL, btcUSD, 0.0006685, time.Now())
+	if err != nil {
+		t.Errorf("err: %v", err)
+	}
+
+	// assert
+	balances, _ := client.Balances.Get()
+	assertBalancesEqual(floats(balances), map[string]float64{
+		"USD": 192.7315917,
+		"BTC": 0.0006685,
+		"ETH": 0.01337,
+	}, t)
+}
+
+func Test_OrderSellAll(t *testing.T) {
+	// create Client
+	client := NewClient(MockAPI{})
+
+	// Order() slightly more than the balance
+	err := client.Order(SELL, btcUSD, 0.001337+1e-12, time.Now())
 	if err != nil {
 		t.Errorf("err: %v", err)
 	}
 
 	// assert
 	balances, _ := client.Balances.Get()
-	assertBalancesEqual(balances, map[string]float64{
+	if !balances["BTC"].IsZero() {
+		t.Errorf(`balances["BTC"] != 0: %v`, balances["BTC"])
+	}
+}
+
+func Test_OrderAliasedPair(t *testing.T) {
+	// create Client
+	client := NewClient(MockAPI{})
+	client.Balances.Alias("BTC", "XBT").Alias("USD", "ZUSD")
+
+	// Order()
+	err := client.Order(SELL, market.NewPair("XBT", "ZUSD"), 0.0006685, time.Now())
+	if err != nil {
+		t.Errorf("err: %v", err)
+	}
+
+	// assert
+	balances, _ := client.Balances.Get()
+	assertBalancesEqual(floats(balances), map[string]float64{
 		"USD": 192.7315917,
 		"BTC": 0.0006685,
 		"ETH": 0.01337,
 	}, t)
 }
 
+func Test_OrderInvalidPair(t *testing.T) {
+	// create Client
+	client := NewClient(MockAPI{})
+
+	// Order() and OrderPct()
+	symbolOnly := market.Pair{Symbol: "XXBTZUSD"}
+	err := client.Order(BUY, symbolOnly, 0.0002674, time.Now())
+	pctErr := client.OrderPct(SELL, market.NewPair("BTC", ""), 0.5, time.Now())
+
+	// assert
+	if !errors.Is(err, market.ErrInvalidPair) {
+		t.Errorf("err != ErrInvalidPair: %v", err)
+	}
+
+	if !errors.Is(pctErr, market.ErrInvalidPair) {
+		t.Errorf("pctErr != ErrInvalidPair: %v", pctErr)
+	}
+}
+
+func Test_OrderOverflow(t *testing.T) {
+	// create Client
+	client := NewClient(MockAPI{})
+
+	// Order()
+	err := client.Order(SELL, btcUSD, 1e12, time.Now())
+
+	// assert
+	if !errors.Is(err, decimal.ErrOverflow) {
+		t.Errorf("err != ErrOverflow: %v", err)
+	}
+
+	balances, _ := client.Balances.Get()
+	assertBalancesEqual(floats(balances), map[string]float64{
+		"USD": 133.7,
+		"BTC": 0.001337,
+		"ETH": 0.01337,
+	}, t)
+}
+
 func Test_OrderBuyFee(t *testing.T) {
 	// create Client
 	client := NewClient(MockAPI{})
 	client.SetFee(0.05)
 
 	// OrderPct()
-	err := client.Order(BUY, "BTC/USD", 0.0002674, time.Now())
+	err := client.Order(BUY, btcUSD, 0.0002674, time.Now())
 	if err != nil {
 		t.Errorf("err: %v", err)
 	}
 
 	// assert
 	balances, _ := client.Balances.Get()
-	assertBalancesEqual(balances, map[string]float64{
+	assertBalancesEqual(floats(balances), map[string]float64{
 		"USD": 110.0873633,
 		"BTC": 0.0015910,
 		"ETH": 0.01337,
@@ -212,14 +386,14 @@ func Test_OrderSellFee(t *testing.T) {
 	client.SetFee(0.01)
 
 	// OrderPct()
-	err := client.Order(SELL, "BTC/USD", 0.0006685, time.Now())
+	err := client.Order(SELL, btcUSD, 0.0006685, time.Now())
 	if err != nil {
 		t.Errorf("err: %v", err)
 	}
 
 	// assert
 	balances, _ := client.Balances.Get()
-	assertBalancesEqual(balances, map[string]float64{
+	assertBalancesEqual(floats(balances), map[string]float64{
 		"USD": 192.1412758,
 		"BTC": 0.0006685,
 		"ETH": 0.01337,
@@ -231,14 +405,14 @@ func Test_OrderOverBuy(t *testing.T) {
 	client := NewClient(MockAPI{})
 
 	// OrderPct()
-	err := client.Order(BUY, "BTC/USD", 0.0016, time.Now())
+	err := client.Order(BUY, btcUSD, 0.0016, time.Now())
 	if err != nil {
 		t.Errorf("err: %v", err)
 	}
 
 	// assert
 	balances, _ := client.Balances.Get()
-	assertBalancesEqual(balances, map[string]float64{
+	assertBalancesEqual(floats(balances), map[string]float64{
 		"USD": 0,
 		"BTC": 0.0028511,
 		"ETH": 0.01337,
@@ -250,34 +424,120 @@ func Test_OrderOverSell(t *testing.T) {
 	client := NewClient(MockAPI{})
 
 	// OrderPct()
-	err := client.Order(SELL, "BTC/USD", 0.002, time.Now())
+	err := client.Order(SELL, btcUSD, 0.002, time.Now())
 	if err != nil {
 		t.Errorf("err: %v", err)
 	}
 
 	// assert
 	balances, _ := client.Balances.Get()
-	assertBalancesEqual(balances, map[string]float64{
+	assertBalancesEqual(floats(balances), map[string]float64{
 		"USD": 251.7631834,
 		"BTC": 0,
 		"ETH": 0.01337,
 	}, t)
 }
 
+func Test_OrderConcurrent(t *testing.T) {
+	// create Client
+	client := NewClient(MockAPI{})
+
+	// Order() and Values() concurrently
+	now := time.Now()
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(3)
+
+		go func() {
+			defer wg.Done()
+			if err := client.Order(BUY, btcUSD, 0.00001, now); err != nil {
+				t.Errorf("err: %v", err)
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			if err := client.Order(SELL, ethUSD, 0.0001, now); err != nil {
+				t.Errorf("err: %v", err)
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			_, err := client.Values("USD", []string{"USD", "BTC", "ETH"}, now)
+			if err != nil {
+				t.Errorf("err: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// assert
+	balances, _ := client.Balances.Get()
+	assertBalancesEqual(floats(balances), map[string]float64{
+		"USD": 133.7 - 10*0.00001*88304.55 + 10*0.0001*2943.89,
+		"BTC": 0.001337 + 10*0.00001,
+		"ETH": 0.01337 - 10*0.0001,
+	}, t)
+}
+
+// a MockAPI whose frames take a while to retrieve, so that concurrent orders
+// all wait for the price after reading their balances
+type SlowFrameAPI struct {
+	MockAPI
+}
+
+func (api SlowFrameAPI) FetchFramesSince(
+	pair market.Pair,
+	interval time.Duration,
+	since time.Time,
+) ([]*frame.Frame, error) {
+	time.Sleep(50 * time.Millisecond)
+	return api.MockAPI.FetchFramesSince(pair, interval, since)
+}
+
+func Test_OrderConcurrentOverSell(t *testing.T) {
+	// create Client
+	client := NewClient(SlowFrameAPI{})
+
+	// Sell() more BTC than the balance between concurrent orders
+	now := time.Now()
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			if err := client.Sell(btcUSD, 0.001, now); err != nil {
+				t.Errorf("err: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// assert (only the balance is sold, however the orders interleave)
+	balances, _ := client.Balances.Get()
+	assertBalancesEqual(floats(balances), map[string]float64{
+		"USD": 133.7 + 0.001337*88304.55,
+		"BTC": 0,
+		"ETH": 0.01337,
+	}, t)
+}
+
 // tests -> Order -> OrderPct
 func Test_OrderPctBuy(t *testing.T) {
 	// create Client
 	client := NewClient(MockAPI{})
 
 	// OrderPct()
-	err := client.OrderPct(BUY, "BTC/USD", 0.2, time.Now())
+	err := client.OrderPct(BUY, btcUSD, 0.2, time.Now())
 	if err != nil {
 		t.Errorf("err: %v", err)
 	}
 
 	// assert
 	balances, _ := client.Balances.Get()
-	assertBalancesEqual(balances, map[string]float64{
+	assertBalancesEqual(floats(balances), map[string]float64{
 		"USD": 110.0873633,
 		"BTC": 0.0016041,
 		"ETH": 0.01337,
@@ -289,14 +549,14 @@ func Test_OrderPctSell(t *testing.T) {
 	client := NewClient(MockAPI{})
 
 	// OrderPct()
-	err := client.OrderPct(SELL, "BTC/USD", 0.5, time.Now())
+	err := client.OrderPct(SELL, btcUSD, 0.5, time.Now())
 	if err != nil {
 		t.Errorf("err: %v", err)
 	}
 
 	// assert
 	balances, _ := client.Balances.Get()
-	assertBalancesEqual(balances, map[string]float64{
+	assertBalancesEqual(floats(balances), map[string]float64{
 		"USD": 192.7315917,
 		"BTC": 0.0006685,
 		"ETH": 0.01337,
@@ -309,14 +569,14 @@ func Test_OrderPctBuyFee(t *testing.T) {
 	client.SetFee(0.05)
 
 	// OrderPct()
-	err := client.OrderPct(BUY, "BTC/USD", 0.2, time.Now())
+	err := client.OrderPct(BUY, btcUSD, 0.2, time.Now())
 	if err != nil {
 		t.Errorf("err: %v", err)
 	}
 
 	// assert
 	balances, _ := client.Balances.Get()
-	assertBalancesEqual(balances, map[string]float64{
+	assertBalancesEqual(floats(balances), map[string]float64{
 		"USD": 110.0873633,
 		"BTC": 0.0015910,
 		"ETH": 0.01337,
@@ -329,14 +589,14 @@ func Test_OrderPctSellFee(t *testing.T) {
 	client.SetFee(0.01)
 
 	// OrderPct()
-	err := client.OrderPct(SELL, "BTC/USD", 0.5, time.Now())
+	err := client.OrderPct(SELL, btcUSD, 0.5, time.Now())
 	if err != nil {
 		t.Errorf("err: %v", err)
 	}
 
 	// assert
 	balances, _ := client.Balances.Get()
-	assertBalancesEqual(balances, map[string]float64{
+	assertBalancesEqual(floats(balances), map[string]float64{
 		"USD": 192.1412758,
 		"BTC": 0.0006685,
 		"ETH": 0.01337,
@@ -348,14 +608,14 @@ func Test_OrderPctOverBuy(t *testing.T) {
 	client := NewClient(MockAPI{})
 
 	// OrderPct()
-	err := client.OrderPct(BUY, "BTC/USD", 1.5, time.Now())
+	err := client.OrderPct(BUY, btcUSD, 1.5, time.Now())
 	if err != nil {
 		t.Errorf("err: %v", err)
 	}
 
 	// assert
 	balances, _ := client.Balances.Get()
-	assertBalancesEqual(balances, map[string]float64{
+	assertBalancesEqual(floats(balances), map[string]float64{
 		"USD": 0,
 		"BTC": 0.0028511,
 		"ETH": 0.01337,
@@ -367,14 +627,14 @@ func Test_OrderPctOverSell(t *testing.T) {
 	client := NewClient(MockAPI{})
 
 	// OrderPct()
-	err := client.OrderPct(SELL, "BTC/USD", 1.5, time.Now())
+	err := client.OrderPct(SELL, btcUSD, 1.5, time.Now())
 	if err != nil {
 		t.Errorf("err: %v", err)
 	}
 
 	// assert
 	balances, _ := client.Balances.Get()
-	assertBalancesEqual(balances, map[string]float64{
+	assertBalancesEqual(floats(balances), map[string]float64{
 		"USD": 251.7631834,
 		"BTC": 0,
 		"ETH": 0.01337,
@@ -434,9 +694,38 @@ func Test_Reweight(t *testing.T) {
 
 	// assert
 	balances, _ := client.Balances.Get()
-	assertBalancesEqual(balances, map[string]float64{
-		"BTC": 0.002637445,
-		"USD": 58.2245985,
+	assertBalancesEqual(floats(balances), map[string]float64{
+		"BTC": 0.00263745,
+		"USD": 58.22415726,
+		"ETH": 0,
+	}, t)
+}
+
+func Test_ReweightAlongRoute(t *testing.T) {
+	// create Client
+	client := NewClient(ListedMockAPI{})
+
+	// Reweight() into an asset that is only traded as the quote of EUR/USD
+	err := client.Reweight(
+		"EUR",
+		map[string]float64{
+			"BTC": 0,
+			"ETH": 0,
+			"USD": 0,
+			"EUR": 1,
+		},
+		time.Now(),
+	)
+	if err != nil {
+		t.Errorf("err: %v", err)
+	}
+
+	// assert
+	balances, _ := client.Balances.Get()
+	assertBalancesEqual(floats(balances), map[string]float64{
+		"BTC": 0,
 		"ETH": 0,
+		"USD": 0,
+		"EUR": 291.1229927 / 1.25,
 	}, t)
 }
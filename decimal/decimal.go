@@ -0,0 +1,238 @@
+package decimal
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// a Decimal is an exact fixed-point number of hundred-millionths. Most assets
+// are traded in units no smaller than that, but some are not, like ETH, which
+// Kraken reports to ten decimal places; digits past the eighth are truncated.
+// Its range is about ±92 billion, which conversions and arithmetic that can
+// leave it report as ErrOverflow
+type Decimal int64
+
+const (
+	PLACES = 8
+	SCALE  = 100_000_000
+)
+
+var (
+	ErrSyntax         = errors.New("invalid decimal")
+	ErrOverflow       = errors.New("decimal out of range")
+	ErrDivisionByZero = errors.New("decimal division by zero")
+)
+
+// initializers
+func New(whole int64) Decimal {
+	return Decimal(whole * SCALE)
+}
+
+// convert a float to the nearest Decimal
+func FromFloat(f float64) (Decimal, error) {
+	return fromUnits(math.Round(f * SCALE))
+}
+
+func fromUnits(units float64) (Decimal, error) {
+	switch {
+	case math.IsNaN(units):
+		return 0, fmt.Errorf("%w NaN", ErrSyntax)
+	case units >= math.MaxInt64 || units < math.MinInt64:
+		return 0, fmt.Errorf("%w: %g", ErrOverflow, units/SCALE)
+	default:
+		return Decimal(units), nil
+	}
+}
+
+// parse a decimal string like "-12.345"; digits beyond the eighth decimal
+// place are truncated, so that a parsed balance is never overstated
+func Parse(s string) (Decimal, error) {
+	digits := strings.TrimSpace(s)
+
+	neg := strings.HasPrefix(digits, "-")
+	if neg || strings.HasPrefix(digits, "+") {
+		digits = digits[1:]
+	}
+
+	whole, fraction, _ := strings.Cut(digits, ".")
+	if whole == "" && fraction == "" {
+		return 0, fmt.Errorf("%w %q", ErrSyntax, s)
+	}
+
+	fraction = (fraction + strings.Repeat("0", PLACES))[:PLACES]
+	if strings.ContainsFunc(whole+fraction, isNotDigit) {
+		return 0, fmt.Errorf("%w %q", ErrSyntax, s)
+	}
+
+	units, err := strconv.ParseUint(whole+fraction, 10, 64)
+	if err != nil || units > math.MaxInt64 {
+		return 0, fmt.Errorf("%w: %q", ErrOverflow, s)
+	}
+
+	if neg {
+		return -Decimal(units), nil
+	}
+
+	return Decimal(units), nil
+}
+
+func isNotDigit(r rune) bool {
+	return r < '0' || r > '9'
+}
+
+// conversions
+func (d Decimal) Float() float64 {
+	return float64(d) / SCALE
+}
+
+// format without trailing zeros, like "0.001337"
+func (d Decimal) String() string {
+	sign := ""
+	units := uint64(d)
+	if d < 0 {
+		sign, units = "-", -units
+	}
+
+	whole, fraction := units/SCALE, units%SCALE
+	if fraction == 0 {
+		return sign + strconv.FormatUint(whole, 10)
+	}
+
+	fractionDigits := fmt.Sprintf("%0*d", PLACES, fraction)
+	return fmt.Sprintf(
+		"%s%d.%s",
+		sign,
+		whole,
+		strings.TrimRight(fractionDigits, "0"),
+	)
+}
+
+// arithmetic; a sum or difference that leaves the range saturates at its
+// bound, and is reported as ErrOverflow
+func (d Decimal) Add(e Decimal) (Decimal, error) {
+	sum := d + e
+	switch {
+	case e > 0 && sum < d:
+		return math.MaxInt64, ErrOverflow
+	case e < 0 && sum > d:
+		return math.MinInt64, ErrOverflow
+	default:
+		return sum, nil
+	}
+}
+
+func (d Decimal) Sub(e Decimal) (Decimal, error) {
+	if e == math.MinInt64 {
+		if d >= 0 {
+			return math.MaxInt64, ErrOverflow
+		}
+
+		return d - e, nil
+	}
+
+	return d.Add(-e)
+}
+
+func (d Decimal) Neg() Decimal {
+	return -d
+}
+
+func (d Decimal) Sign() int {
+	switch {
+	case d < 0:
+		return -1
+	case d > 0:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (d Decimal) IsZero() bool {
+	return d == 0
+}
+
+// round toward zero to a multiple of step, like an exchange's lot size; a
+// step that isn't positive leaves d as it is
+func (d Decimal) Truncate(step Decimal) Decimal {
+	if step <= 0 {
+		return d
+	}
+
+	return d - d%step
+}
+
+func Min(d, e Decimal) Decimal {
+	return min(d, e)
+}
+
+func Max(d, e Decimal) Decimal {
+	return max(d, e)
+}
+
+// compute a*b/c truncated toward zero
+func mulDiv(a, b, c int64) (int64, error) {
+	if c == 0 {
+		return 0, ErrDivisionByZero
+	}
+
+	neg := (a < 0) != (b < 0) != (c < 0)
+
+	// the quotient of the 128-bit product has to fit in 63 bits, or in 64
+	// for the most negative value
+	hi, lo := bits.Mul64(abs(a), abs(b))
+	if hi >= abs(c) {
+		return 0, ErrOverflow
+	}
+
+	q, _ := bits.Div64(hi, lo, abs(c))
+	switch {
+	case neg && q > 1<<63:
+		return 0, ErrOverflow
+	case neg:
+		return -int64(q), nil
+	case q > math.MaxInt64:
+		return 0, ErrOverflow
+	default:
+		return int64(q), nil
+	}
+}
+
+func abs(x int64) uint64 {
+	if x < 0 {
+		return -uint64(x)
+	}
+
+	return uint64(x)
+}
+
+// multiply exactly, truncating toward zero past the eighth decimal place
+func (d Decimal) Mul(e Decimal) (Decimal, error) {
+	product, err := mulDiv(int64(d), int64(e), SCALE)
+	return Decimal(product), err
+}
+
+// divide, truncating toward zero past the eighth decimal place
+func (d Decimal) Div(e Decimal) (Decimal, error) {
+	quotient, err := mulDiv(int64(d), SCALE, int64(e))
+	return Decimal(quotient), err
+}
+
+// multiply by a float, like a price or a fee rate, truncating toward zero so
+// that the result is never overstated
+func (d Decimal) MulFloat(f float64) (Decimal, error) {
+	return fromUnits(math.Trunc(float64(d) * f))
+}
+
+// divide by a float, like a price, truncating toward zero
+func (d Decimal) DivFloat(f float64) (Decimal, error) {
+	if f == 0 {
+		return 0, ErrDivisionByZero
+	}
+
+	return fromUnits(math.Trunc(float64(d) / f))
+}
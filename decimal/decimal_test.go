@@ -0,0 +1,211 @@
+package decimal
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func Test_Parse(t *testing.T) {
+	for s, expected := range map[string]Decimal{
+		"0":              0,
+		"1":              SCALE,
+		"-1.5":           -150_000_000,
+		"0.001337":       133_700,
+		".5":             50_000_000,
+		"12.":            12 * SCALE,
+		"0.0000000019":   0, // truncated
+		"133.7000000000": 13_370_000_000,
+		"+2.25":          225_000_000,
+	} {
+		// Parse()
+		d, err := Parse(s)
+
+		// assert
+		if err != nil {
+			t.Errorf("%q: err != nil: %v", s, err)
+		} else if d != expected {
+			t.Errorf("Parse(%q) != %d: %d", s, expected, d)
+		}
+	}
+}
+
+func Test_ParseInvalid(t *testing.T) {
+	for _, s := range []string{"", ".", "1e5", "abc", "1.2.3", "--1"} {
+		// Parse()
+		_, err := Parse(s)
+
+		// assert
+		if !errors.Is(err, ErrSyntax) {
+			t.Errorf("%q: err != ErrSyntax: %v", s, err)
+		}
+	}
+}
+
+func Test_ParseOverflow(t *testing.T) {
+	// Parse() a balance like 100 billion SHIB
+	_, err := Parse("100000000000")
+
+	// assert
+	if !errors.Is(err, ErrOverflow) {
+		t.Errorf("err != ErrOverflow: %v", err)
+	}
+}
+
+func Test_String(t *testing.T) {
+	for d, expected := range map[Decimal]string{
+		0:              "0",
+		SCALE:          "1",
+		-150_000_000:   "-1.5",
+		133_700:        "0.001337",
+		1:              "0.00000001",
+		13_370_000_000: "133.7",
+	} {
+		// assert
+		if s := d.String(); s != expected {
+			t.Errorf("String(%d) != %q: %q", int64(d), expected, s)
+		}
+	}
+}
+
+func Test_AddExact(t *testing.T) {
+	// Add() a tenth ten times
+	tenth, err := FromFloat(0.1)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	sum := Decimal(0)
+	for range 10 {
+		if sum, err = sum.Add(tenth); err != nil {
+			t.Fatalf("err != nil: %v", err)
+		}
+	}
+
+	// assert
+	if sum != New(1) {
+		t.Errorf("sum != 1: %v", sum)
+	}
+}
+
+func Test_AddOverflow(t *testing.T) {
+	// Add() and Sub() past the range
+	sum, err := Decimal(math.MaxInt64 - 1).Add(2)
+	difference, subErr := Decimal(math.MinInt64 + 1).Sub(2)
+
+	// assert
+	if !errors.Is(err, ErrOverflow) || sum != math.MaxInt64 {
+		t.Errorf("sum != max, ErrOverflow: %v, %v", sum, err)
+	}
+
+	if !errors.Is(subErr, ErrOverflow) || difference != math.MinInt64 {
+		t.Errorf("difference != min, ErrOverflow: %v, %v", difference, subErr)
+	}
+}
+
+func Test_Truncate(t *testing.T) {
+	step, _ := Parse("0.00001")
+
+	for s, expected := range map[string]string{
+		"0.12345678":  "0.12345",
+		"-0.12345678": "-0.12345",
+		"3":           "3",
+		"0.000009":    "0",
+	} {
+		// Truncate()
+		d, _ := Parse(s)
+
+		// assert
+		if truncated := d.Truncate(step).String(); truncated != expected {
+			t.Errorf("Truncate(%s) != %s: %s", s, expected, truncated)
+		}
+	}
+}
+
+func Test_MulDiv(t *testing.T) {
+	// set up decimals
+	a, _ := Parse("1.5")
+	b, _ := Parse("0.00000003")
+
+	// assert
+	if product, _ := a.Mul(b); product != 4 {
+		t.Errorf("1.5 * 0.00000003 != 0.00000004: %v", product)
+	}
+
+	if quotient, _ := New(1).Div(New(3)); quotient != 33_333_333 {
+		t.Errorf("1 / 3 != 0.33333333: %v", quotient)
+	}
+
+	if quotient, _ := New(-1).Div(New(3)); quotient != -33_333_333 {
+		t.Errorf("-1 / 3 != -0.33333333: %v", quotient)
+	}
+}
+
+func Test_MulFloatTruncates(t *testing.T) {
+	// set up decimal
+	d, _ := Parse("0.00133700")
+
+	// assert
+	if product, _ := d.MulFloat(0.996); product != 133_165 {
+		t.Errorf("0.001337 * 0.996 != 0.00133165: %v", product)
+	}
+
+	if quotient, _ := New(1).DivFloat(3); quotient != 33_333_333 {
+		t.Errorf("1 / 3 != 0.33333333: %v", quotient)
+	}
+}
+
+func Test_MulOverflow(t *testing.T) {
+	// Mul() past the range
+	_, err := New(90_000_000_000).Mul(New(2))
+
+	// assert
+	if !errors.Is(err, ErrOverflow) {
+		t.Errorf("err != ErrOverflow: %v", err)
+	}
+}
+
+func Test_MulMin(t *testing.T) {
+	// Mul() to the most negative value
+	product, err := Decimal(math.MinInt64).Mul(New(1))
+	if err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	// assert
+	if product != math.MinInt64 {
+		t.Errorf("product != MinInt64: %v", product)
+	}
+}
+
+func Test_DivZero(t *testing.T) {
+	// Div() and DivFloat() by zero
+	_, err := New(1).Div(0)
+	_, floatErr := New(1).DivFloat(0)
+
+	// assert
+	if !errors.Is(err, ErrDivisionByZero) {
+		t.Errorf("err != ErrDivisionByZero: %v", err)
+	}
+
+	if !errors.Is(floatErr, ErrDivisionByZero) {
+		t.Errorf("floatErr != ErrDivisionByZero: %v", floatErr)
+	}
+}
+
+func Test_FromFloatOverflow(t *testing.T) {
+	for _, f := range []float64{1e11, -1e11, math.Inf(1)} {
+		// FromFloat()
+		_, err := FromFloat(f)
+
+		// assert
+		if !errors.Is(err, ErrOverflow) {
+			t.Errorf("FromFloat(%g) err != ErrOverflow: %v", f, err)
+		}
+	}
+
+	// FromFloat() with NaN
+	if _, err := FromFloat(math.NaN()); !errors.Is(err, ErrSyntax) {
+		t.Errorf("FromFloat(NaN) err != ErrSyntax: %v", err)
+	}
+}
@@ -0,0 +1,562 @@
+package driver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/haydenhigg/chrys/decimal"
+	"github.com/haydenhigg/chrys/frame"
+	"github.com/haydenhigg/chrys/market"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	BINANCE_URL         = "https://api.binance.com"
+	BINANCE_KLINE_LIMIT = 1000
+
+	// the default most pages of klines to retrieve at once
+	BINANCE_MAX_KLINE_PAGES = 10
+
+	// the request weight that an IP address can use per minute
+	BINANCE_WEIGHT_LIMIT = 6000
+)
+
+// the request weight of endpoints that don't weigh 1
+var binanceWeights = map[string]float64{
+	"/api/v3/klines":       2,
+	"/api/v3/exchangeInfo": 20,
+	"/api/v3/account":      20,
+}
+
+// Binance's names for the intervals it has klines for
+var binanceIntervals = map[time.Duration]string{
+	time.Second:      "1s",
+	time.Minute:      "1m",
+	3 * time.Minute:  "3m",
+	5 * time.Minute:  "5m",
+	15 * time.Minute: "15m",
+	30 * time.Minute: "30m",
+	time.Hour:        "1h",
+	2 * time.Hour:    "2h",
+	4 * time.Hour:    "4h",
+	6 * time.Hour:    "6h",
+	8 * time.Hour:    "8h",
+	12 * time.Hour:   "12h",
+	24 * time.Hour:   "1d",
+	72 * time.Hour:   "3d",
+	168 * time.Hour:  "1w",
+}
+
+type BinanceDriver struct {
+	Key    []byte
+	Secret []byte
+
+	// where requests are sent, so that they can go to a proxy or stand-in
+	// server; defaults to BINANCE_URL
+	BaseURL    string
+	HTTPClient *http.Client
+
+	// how long after its timestamp a signed request is still valid
+	RecvWindow time.Duration
+
+	// the most pages of klines to retrieve for one request for frames;
+	// defaults to BINANCE_MAX_KLINE_PAGES, and 0 is unlimited
+	MaxKlinePages int
+
+	// how many times to retry a failed request, waiting RetryDelay before the
+	// first retry and twice as long before each one after
+	MaxRetries int
+	RetryDelay time.Duration
+
+	mu        sync.Mutex
+	offset    time.Duration // the server's clock minus ours
+	synced    bool
+	stepSizes map[string]decimal.Decimal
+	weights   *callCounter
+	now       func() time.Time
+	sleep     func(time.Duration)
+}
+
+func NewBinance(key, secret string) *BinanceDriver {
+	return &BinanceDriver{
+		Key:           []byte(key),
+		Secret:        []byte(secret),
+		BaseURL:       BINANCE_URL,
+		HTTPClient:    &http.Client{Timeout: 5 * time.Second},
+		RecvWindow:    5 * time.Second,
+		MaxKlinePages: BINANCE_MAX_KLINE_PAGES,
+		MaxRetries:    3,
+		RetryDelay:    500 * time.Millisecond,
+		stepSizes:     map[string]decimal.Decimal{},
+		weights:       newWeightCounter(BINANCE_WEIGHT_LIMIT),
+		now:           time.Now,
+		sleep:         time.Sleep,
+	}
+}
+
+// get Binance's symbol for a pair, like BTCUSDT for BTC/USDT, unless the pair
+// has its own
+func (d *BinanceDriver) Symbol(pair market.Pair) string {
+	if pair.Symbol != "" {
+		return pair.Symbol
+	}
+
+	return pair.Base + pair.Quote
+}
+
+// basic requests
+func (d *BinanceDriver) send(request *http.Request, result any) error {
+	response, err := d.HTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	rawResponse, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	// Binance reports the weight used so far this minute, which may include
+	// other clients' requests
+	header := response.Header.Get("X-MBX-USED-WEIGHT-1M")
+	if used, err := strconv.ParseFloat(header, 64); err == nil {
+		d.weights.raise(used)
+	}
+
+	// errors come with a status that isn't 2xx
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		binanceErr := &BinanceError{}
+		err := json.Unmarshal(rawResponse, binanceErr)
+		if err == nil && binanceErr.Code != 0 {
+			return binanceErr
+		}
+
+		return &HTTPStatusError{response.StatusCode, response.Status}
+	}
+
+	// unmarshal raw response
+	if result != nil {
+		if err := json.Unmarshal(rawResponse, result); err != nil {
+			return fmt.Errorf("%w: %v", ErrMalformedResponse, err)
+		}
+	}
+
+	return nil
+}
+
+// send requests made by build until one succeeds
+func (d *BinanceDriver) do(
+	build func() (*http.Request, error),
+	idempotent bool,
+	result any,
+) error {
+	return retry(
+		retryPolicy{d.MaxRetries, d.RetryDelay, d.sleep},
+		build,
+		func(request *http.Request) error { return d.send(request, result) },
+		idempotent,
+		func(err error) error {
+			// Binance's weight counter is fuller than ours
+			if errors.Is(err, ErrRateLimited) {
+				d.weights.fill()
+			}
+
+			// our clock has drifted from the server's
+			if errors.Is(err, ErrInvalidTimestamp) {
+				return d.syncTime()
+			}
+
+			return nil
+		},
+	)
+}
+
+func (d *BinanceDriver) public(
+	path string,
+	query url.Values,
+	result any,
+) error {
+	u := strings.TrimSuffix(d.BaseURL, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	return d.do(func() (*http.Request, error) {
+		d.weights.wait(weightOf(path))
+		return http.NewRequest("GET", u, nil)
+	}, true, result)
+}
+
+func (d *BinanceDriver) signed(
+	method,
+	path string,
+	query url.Values,
+	result any,
+) error {
+	if err := d.ensureSynced(); err != nil {
+		return err
+	}
+
+	return d.do(func() (*http.Request, error) {
+		d.weights.wait(weightOf(path))
+
+		// every attempt needs a new timestamp
+		signed := maps.Clone(query)
+		if signed == nil {
+			signed = url.Values{}
+		}
+
+		timestamp := d.serverNow().UnixMilli()
+		signed.Set("timestamp", strconv.FormatInt(timestamp, 10))
+		signed.Set("recvWindow", strconv.FormatInt(d.RecvWindow.Milliseconds(), 10))
+
+		rawQuery := signed.Encode()
+		rawQuery += "&signature=" + d.buildSignature(rawQuery)
+
+		// create the *http.Request
+		u := strings.TrimSuffix(d.BaseURL, "/") + path + "?" + rawQuery
+		request, err := http.NewRequest(method, u, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		request.Header.Set("X-MBX-APIKEY", string(d.Key))
+
+		return request, nil
+	}, method == "GET", result)
+}
+
+// request helpers
+func weightOf(path string) float64 {
+	if weight, ok := binanceWeights[path]; ok {
+		return weight
+	}
+
+	return 1
+}
+
+func (d *BinanceDriver) buildSignature(rawQuery string) string {
+	h := hmac.New(sha256.New, d.Secret)
+	h.Write([]byte(rawQuery))
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// measure the offset of the server's clock from ours, assuming that its time
+// was read halfway through the request
+func (d *BinanceDriver) syncTime() error {
+	before := d.now()
+
+	var result struct {
+		ServerTime int64 `json:"serverTime"`
+	}
+	if err := d.public("/api/v3/time", nil, &result); err != nil {
+		return err
+	}
+
+	after := d.now()
+	midpoint := before.Add(after.Sub(before) / 2)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.offset = time.UnixMilli(result.ServerTime).Sub(midpoint)
+	d.synced = true
+
+	return nil
+}
+
+// sync with the server's clock if that hasn't been done yet
+func (d *BinanceDriver) ensureSynced() error {
+	d.mu.Lock()
+	synced := d.synced
+	d.mu.Unlock()
+
+	if synced {
+		return nil
+	}
+
+	return d.syncTime()
+}
+
+func (d *BinanceDriver) serverNow() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.now().Add(d.offset)
+}
+
+// retrieve closed frames since a time and the frame that is still forming, a
+// page of klines at a time
+func (d *BinanceDriver) fetchKlines(
+	pair market.Pair,
+	interval time.Duration,
+	since time.Time,
+) ([]*frame.Frame, *frame.Frame, error) {
+	name, ok := binanceIntervals[interval]
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported interval %v", interval)
+	}
+
+	// whether a kline is still forming depends on the server's clock
+	if err := d.ensureSynced(); err != nil {
+		return nil, nil, err
+	}
+
+	frames := []*frame.Frame{}
+	var forming *frame.Frame
+
+	for start, pages := since, 0; forming == nil; pages++ {
+		// stop rather than return frames that end before now
+		if d.MaxKlinePages > 0 && pages >= d.MaxKlinePages {
+			remaining := d.serverNow().Truncate(interval).Sub(start) / interval
+			return nil, nil, &frame.InsufficientFramesError{
+				Pair:      pair,
+				Interval:  interval,
+				Requested: len(frames) + int(remaining),
+				Available: len(frames),
+			}
+		}
+
+		// make request
+		var rawFrames [][]any
+		err := d.public("/api/v3/klines", url.Values{
+			"symbol":    {d.Symbol(pair)},
+			"interval":  {name},
+			"startTime": {strconv.FormatInt(start.UnixMilli(), 10)},
+			"limit":     {strconv.Itoa(BINANCE_KLINE_LIMIT)},
+		}, &rawFrames)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// process returned frames
+		now := d.serverNow()
+
+		for _, rawFrame := range rawFrames {
+			values, err := parseRow(rawFrame, 0, 1, 2, 3, 4, 5, 6)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			f := &frame.Frame{
+				Time:   time.UnixMilli(int64(values[0])),
+				Open:   values[1],
+				High:   values[2],
+				Low:    values[3],
+				Close:  values[4],
+				Volume: values[5],
+			}
+
+			// a frame is forming until its close time, which is inclusive
+			closeTime := time.UnixMilli(int64(values[6]))
+			if !now.After(closeTime) {
+				forming = f
+				break
+			}
+
+			frames = append(frames, f)
+		}
+
+		// there are no more frames
+		if len(rawFrames) < BINANCE_KLINE_LIMIT || len(frames) == 0 {
+			break
+		}
+
+		start = frames[len(frames)-1].Time.Add(interval)
+	}
+
+	if len(frames) == 0 && forming == nil {
+		return nil, nil, fmt.Errorf("%w for %v", frame.ErrNoData, pair)
+	}
+
+	return frames, forming, nil
+}
+
+// retrieve the size that quantities of a pair must be a multiple of
+func (d *BinanceDriver) fetchStepSize(symbol string) (decimal.Decimal, error) {
+	d.mu.Lock()
+	step, ok := d.stepSizes[symbol]
+	d.mu.Unlock()
+
+	if ok {
+		return step, nil
+	}
+
+	// make request
+	var result struct {
+		Symbols []struct {
+			Symbol  string `json:"symbol"`
+			Filters []struct {
+				FilterType string `json:"filterType"`
+				StepSize   string `json:"stepSize"`
+			} `json:"filters"`
+		} `json:"symbols"`
+	}
+	err := d.public("/api/v3/exchangeInfo", url.Values{
+		"symbol": {symbol},
+	}, &result)
+	if err != nil {
+		return 0, err
+	}
+
+	// market orders use MARKET_LOT_SIZE unless its step is 0
+	steps := map[string]decimal.Decimal{}
+	for _, info := range result.Symbols {
+		if info.Symbol != symbol {
+			continue
+		}
+
+		for _, filter := range info.Filters {
+			if filter.StepSize == "" {
+				continue
+			}
+
+			size, err := decimal.Parse(filter.StepSize)
+			if err != nil {
+				return 0, fmt.Errorf("%w: %v", ErrMalformedResponse, err)
+			}
+
+			steps[filter.FilterType] = size
+		}
+	}
+
+	step = steps["MARKET_LOT_SIZE"]
+	if step.IsZero() {
+		step = steps["LOT_SIZE"]
+	}
+
+	d.mu.Lock()
+	d.stepSizes[symbol] = step
+	d.mu.Unlock()
+
+	return step, nil
+}
+
+// driver functions
+func (d *BinanceDriver) FetchFramesSince(
+	pair market.Pair,
+	interval time.Duration,
+	since time.Time,
+) ([]*frame.Frame, error) {
+	frames, _, err := d.fetchKlines(pair, interval, since)
+	return frames, err
+}
+
+func (d *BinanceDriver) FetchFormingFramesSince(
+	pair market.Pair,
+	interval time.Duration,
+	since time.Time,
+) ([]*frame.Frame, *frame.Frame, error) {
+	return d.fetchKlines(pair, interval, since)
+}
+
+// list the pairs that can be traded, with Binance's symbols for them
+func (d *BinanceDriver) FetchPairs() ([]market.Pair, error) {
+	// make request
+	var result struct {
+		Symbols []struct {
+			Symbol     string `json:"symbol"`
+			Status     string `json:"status"`
+			BaseAsset  string `json:"baseAsset"`
+			QuoteAsset string `json:"quoteAsset"`
+		} `json:"symbols"`
+	}
+	err := d.public("/api/v3/exchangeInfo", url.Values{
+		"symbolStatus": {"TRADING"},
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	pairs := make([]market.Pair, 0, len(result.Symbols))
+	for _, info := range result.Symbols {
+		if info.Status != "TRADING" {
+			continue
+		}
+
+		pair := market.NewPair(info.BaseAsset, info.QuoteAsset)
+		pairs = append(pairs, pair.WithSymbol(info.Symbol))
+	}
+
+	return pairs, nil
+}
+
+// retrieve the balances that are free to trade
+func (d *BinanceDriver) FetchBalances() (map[string]decimal.Decimal, error) {
+	// make request
+	var result struct {
+		Balances []struct {
+			Asset string `json:"asset"`
+			Free  string `json:"free"`
+		} `json:"balances"`
+	}
+	err := d.signed("GET", "/api/v3/account", url.Values{
+		"omitZeroBalances": {"true"},
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	// process returned quantities
+	balances := map[string]decimal.Decimal{}
+
+	for _, balance := range result.Balances {
+		// leave out balances past Decimal's range rather than failing the
+		// others
+		free, err := decimal.Parse(balance.Free)
+		if errors.Is(err, decimal.ErrOverflow) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformedResponse, err)
+		}
+
+		// don't include balances of 0
+		if free.IsZero() {
+			continue
+		}
+
+		balances[balance.Asset] = free
+	}
+
+	return balances, nil
+}
+
+func (d *BinanceDriver) MarketOrder(
+	side string,
+	pair market.Pair,
+	quantity decimal.Decimal,
+) error {
+	symbol := d.Symbol(pair)
+
+	// quantities must be a multiple of the pair's step size
+	step, err := d.fetchStepSize(symbol)
+	if err != nil {
+		return err
+	}
+
+	truncated := quantity.Truncate(step)
+	if truncated.Sign() <= 0 {
+		return fmt.Errorf("%w: %v of %s", ErrOrderMinimum, quantity, symbol)
+	}
+
+	// make request
+	return d.signed("POST", "/api/v3/order", url.Values{
+		"symbol":           {symbol},
+		"side":             {strings.ToUpper(side)},
+		"type":             {"MARKET"},
+		"quantity":         {truncated.String()},
+		"newOrderRespType": {"ACK"},
+	}, nil)
+}
@@ -0,0 +1,47 @@
+package driver
+
+import (
+	"fmt"
+	"strings"
+)
+
+// an error returned by Binance, like {"code":-1121,"msg":"Invalid symbol."}
+type BinanceError struct {
+	Code    int    `json:"code"`
+	Message string `json:"msg"`
+}
+
+func (err *BinanceError) Error() string {
+	return fmt.Sprintf("binance: %d %s", err.Code, err.Message)
+}
+
+func (err *BinanceError) exchange() string {
+	return "binance"
+}
+
+// match the error to one of the sentinel errors, if any
+func (err *BinanceError) Unwrap() error {
+	message := strings.ToLower(err.Message)
+
+	switch code := err.Code; {
+	case code == -1003 || code == -1015:
+		return ErrRateLimited
+	case code == -1021:
+		return ErrInvalidTimestamp
+	case code == -1022 || code == -2014 || code == -2015:
+		return ErrInvalidKey
+	case code == -1001 || code == -1007 || code == -1008:
+		return ErrUnavailable
+	case code == -1121:
+		return ErrUnknownPair
+	case code == -2010 && strings.Contains(message, "insufficient balance"):
+		return ErrInsufficientFunds
+	case code == -1013 && (strings.Contains(message, "lot_size") ||
+		strings.Contains(message, "notional")):
+		return ErrOrderMinimum
+	case code <= -1100 && code > -1200, code == -1013:
+		return ErrInvalidArguments
+	}
+
+	return nil
+}
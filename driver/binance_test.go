@@ -0,0 +1,464 @@
+package driver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/haydenhigg/chrys/decimal"
+	"github.com/haydenhigg/chrys/frame"
+	"github.com/haydenhigg/chrys/market"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mock
+type BinanceStandIn struct {
+	*httptest.Server
+	mu       sync.Mutex
+	routes   map[string]func(url.Values) (int, string)
+	offset   time.Duration // the server's clock minus the driver's
+	Requests []standInRequest
+
+	// reported as the weight used this minute, if it isn't empty
+	usedWeight string
+}
+
+// the stand-in's clock
+func (standIn *BinanceStandIn) now() time.Time {
+	standIn.mu.Lock()
+	defer standIn.mu.Unlock()
+
+	return time.Now().Add(standIn.offset)
+}
+
+func (standIn *BinanceStandIn) setOffset(offset time.Duration) {
+	standIn.mu.Lock()
+	defer standIn.mu.Unlock()
+
+	standIn.offset = offset
+}
+
+// a BinanceStandIn answers requests by path, checking signatures and
+// timestamps the way Binance does
+func newBinanceStandIn(
+	t *testing.T,
+	routes map[string]func(url.Values) (int, string),
+) (*BinanceDriver, *BinanceStandIn) {
+	standIn := &BinanceStandIn{routes: routes}
+
+	standIn.Server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+
+			standIn.mu.Lock()
+			standIn.Requests = append(
+				standIn.Requests,
+				standInRequest{r.URL.Path, query, nil},
+			)
+			if standIn.usedWeight != "" {
+				w.Header().Set("X-MBX-USED-WEIGHT-1M", standIn.usedWeight)
+			}
+			standIn.mu.Unlock()
+
+			// verify signed requests independently of the driver
+			if signature := query.Get("signature"); signature != "" {
+				unsigned, _, _ := strings.Cut(r.URL.RawQuery, "&signature=")
+
+				h := hmac.New(sha256.New, []byte("secret"))
+				h.Write([]byte(unsigned))
+
+				if r.Header.Get("X-MBX-APIKEY") != "key" ||
+					hex.EncodeToString(h.Sum(nil)) != signature {
+					w.WriteHeader(http.StatusUnauthorized)
+					fmt.Fprint(w, `{"code":-1022,"msg":"Signature invalid."}`)
+					return
+				}
+
+				timestamp, _ := strconv.ParseInt(query.Get("timestamp"), 10, 64)
+				window, _ := strconv.ParseInt(query.Get("recvWindow"), 10, 64)
+				skew := standIn.now().UnixMilli() - timestamp
+				if skew > window || skew < -1000 {
+					w.WriteHeader(http.StatusBadRequest)
+					fmt.Fprint(w, `{"code":-1021,`+
+						`"msg":"Timestamp for this request is outside of the recvWindow."}`)
+					return
+				}
+			}
+
+			if r.URL.Path == "/api/v3/time" {
+				fmt.Fprintf(w, `{"serverTime":%d}`, standIn.now().UnixMilli())
+				return
+			}
+
+			route, ok := standIn.routes[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+
+			status, body := route(query)
+			w.WriteHeader(status)
+			fmt.Fprint(w, body)
+		},
+	))
+	t.Cleanup(standIn.Close)
+
+	d := NewBinance("key", "secret")
+	d.BaseURL = standIn.URL
+	d.HTTPClient = standIn.Client()
+	d.sleep = func(time.Duration) {}
+	d.weights.sleep = func(time.Duration) {}
+
+	return d, standIn
+}
+
+// klines with a close of their index, from startTime until now
+func klines(
+	now time.Time,
+	interval time.Duration,
+) func(url.Values) (int, string) {
+	return func(query url.Values) (int, string) {
+		ms, _ := strconv.ParseInt(query.Get("startTime"), 10, 64)
+		limit, _ := strconv.Atoi(query.Get("limit"))
+
+		rows := []string{}
+		t := time.UnixMilli(ms)
+		for ; !t.After(now) && len(rows) < limit; t = t.Add(interval) {
+			i := t.Sub(now.Truncate(interval)) / interval
+			rows = append(rows, fmt.Sprintf(
+				`[%d,"%d","%d","%d","%d","1.5",%d,"0",1,"0","0","0"]`,
+				t.UnixMilli(), i, i, i, i, t.Add(interval).UnixMilli()-1,
+			))
+		}
+
+		return 200, "[" + strings.Join(rows, ",") + "]"
+	}
+}
+
+// tests
+func Test_BinanceFetchFramesSincePaginates(t *testing.T) {
+	// set up driver
+	now := time.Now()
+	d, standIn := newBinanceStandIn(t, map[string]func(url.Values) (int, string){
+		"/api/v3/klines": klines(now, time.Minute),
+	})
+
+	// FetchFormingFramesSince()
+	since := now.Truncate(time.Minute).Add(-1500 * time.Minute)
+	frames, forming, err := d.FetchFormingFramesSince(
+		market.NewPair("BTC", "USDT"),
+		time.Minute,
+		since,
+	)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if len(frames) != 1500 {
+		t.Fatalf("len(frames) != 1500: %d", len(frames))
+	}
+
+	if !frames[0].Time.Equal(since) || frames[1499].Close != -1 {
+		t.Errorf("frames != since..now-1m: %v, %v", frames[0], frames[1499])
+	}
+
+	if forming == nil || !forming.Time.Equal(now.Truncate(time.Minute)) {
+		t.Errorf("forming != now: %v", forming)
+	}
+
+	// the clock is synced before the two pages of klines
+	if len(standIn.Requests) != 3 {
+		t.Fatalf("len(requests) != 3: %d", len(standIn.Requests))
+	}
+
+	if path := standIn.Requests[0].Path; path != "/api/v3/time" {
+		t.Errorf("first path != /api/v3/time: %s", path)
+	}
+
+	second := standIn.Requests[2].Query
+	if second.Get("symbol") != "BTCUSDT" || second.Get("interval") != "1m" {
+		t.Errorf("query != BTCUSDT at 1m: %v", second)
+	}
+
+	start := fmt.Sprint(since.Add(1000 * time.Minute).UnixMilli())
+	if second.Get("startTime") != start {
+		t.Errorf("startTime != %s: %s", start, second.Get("startTime"))
+	}
+}
+
+func Test_BinanceFetchFramesSinceCapped(t *testing.T) {
+	// set up driver
+	now := time.Now()
+	d, standIn := newBinanceStandIn(t, map[string]func(url.Values) (int, string){
+		"/api/v3/klines": klines(now, time.Minute),
+	})
+	d.MaxKlinePages = 1
+
+	// FetchFramesSince()
+	since := now.Truncate(time.Minute).Add(-1500 * time.Minute)
+	_, err := d.FetchFramesSince(market.NewPair("BTC", "USDT"), time.Minute, since)
+
+	// assert
+	var insufficientErr *frame.InsufficientFramesError
+	if !errors.As(err, &insufficientErr) {
+		t.Fatalf("err != InsufficientFramesError: %v", err)
+	}
+
+	if insufficientErr.Requested != 1500 ||
+		insufficientErr.Available != 1000 {
+		t.Errorf("requested 1500 with 1000 available != %v", insufficientErr)
+	}
+
+	if len(standIn.Requests) != 2 {
+		t.Errorf("len(requests) != 2: %d", len(standIn.Requests))
+	}
+}
+
+func Test_BinanceFormingByServerTime(t *testing.T) {
+	// set up driver whose clock is 5m ahead of the server's
+	now := time.Now()
+	serverNow := now.Add(-5 * time.Minute)
+	d, standIn := newBinanceStandIn(t, map[string]func(url.Values) (int, string){
+		"/api/v3/klines": klines(serverNow, time.Minute),
+	})
+	standIn.setOffset(-5 * time.Minute)
+
+	// FetchFormingFramesSince()
+	since := serverNow.Truncate(time.Minute).Add(-3 * time.Minute)
+	frames, forming, err := d.FetchFormingFramesSince(
+		market.NewPair("BTC", "USDT"),
+		time.Minute,
+		since,
+	)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if len(frames) != 3 {
+		t.Errorf("len(frames) != 3: %d", len(frames))
+	}
+
+	if forming == nil ||
+		!forming.Time.Equal(serverNow.Truncate(time.Minute)) {
+		t.Errorf("forming != %v: %v", serverNow.Truncate(time.Minute), forming)
+	}
+}
+
+func Test_BinanceUsedWeight(t *testing.T) {
+	// set up driver whose weight is nearly used up by other clients
+	d, standIn := newBinanceStandIn(t, map[string]func(url.Values) (int, string){
+		"/api/v3/exchangeInfo": func(url.Values) (int, string) {
+			return 200, `{"symbols":[]}`
+		},
+	})
+
+	now := time.Now()
+	waits := []time.Duration{}
+	d.weights.now = func() time.Time { return now }
+	d.weights.sleep = func(d time.Duration) { waits = append(waits, d) }
+	standIn.usedWeight = "5990"
+
+	// FetchPairs() twice
+	d.FetchPairs()
+	d.FetchPairs()
+
+	// assert
+	if len(waits) != 1 || waits[0] != 100*time.Millisecond {
+		t.Errorf("waits != [100ms]: %v", waits)
+	}
+}
+
+func Test_BinanceFetchBalances(t *testing.T) {
+	// set up driver whose clock is an hour behind the server's
+	d, standIn := newBinanceStandIn(t, map[string]func(url.Values) (int, string){
+		"/api/v3/account": func(url.Values) (int, string) {
+			return 200, `{"balances":[` +
+				`{"asset":"BTC","free":"0.00123456","locked":"1.00000000"},` +
+				`{"asset":"USDT","free":"100.10000000","locked":"0.00000000"},` +
+				`{"asset":"ETH","free":"0.00000000","locked":"2.00000000"}]}`
+		},
+	})
+	standIn.setOffset(time.Hour)
+
+	// FetchBalances()
+	balances, err := d.FetchBalances()
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if len(balances) != 2 {
+		t.Errorf("len(balances) != 2: %v", balances)
+	}
+
+	if balances["BTC"].String() != "0.00123456" {
+		t.Errorf("BTC != 0.00123456: %v", balances["BTC"])
+	}
+
+	if balances["USDT"] != decimal.Decimal(10_010_000_000) {
+		t.Errorf("USDT != 100.1: %v", balances["USDT"])
+	}
+
+	account := standIn.Requests[1].Query
+	if account.Get("omitZeroBalances") != "true" ||
+		account.Get("recvWindow") != "5000" {
+		t.Errorf("query != omitZeroBalances in 5000: %v", account)
+	}
+}
+
+func Test_BinanceFetchPairs(t *testing.T) {
+	// set up driver
+	d, _ := newBinanceStandIn(t, map[string]func(url.Values) (int, string){
+		"/api/v3/exchangeInfo": func(url.Values) (int, string) {
+			return 200, `{"symbols":[` +
+				`{"symbol":"BTCUSDT","status":"TRADING",` +
+				`"baseAsset":"BTC","quoteAsset":"USDT"},` +
+				`{"symbol":"LUNAUSDT","status":"BREAK",` +
+				`"baseAsset":"LUNA","quoteAsset":"USDT"}]}`
+		},
+	})
+
+	// FetchPairs()
+	pairs, err := d.FetchPairs()
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	expected := market.NewPair("BTC", "USDT").WithSymbol("BTCUSDT")
+	if len(pairs) != 1 || pairs[0] != expected {
+		t.Errorf("pairs != [%v]: %v", expected, pairs)
+	}
+}
+
+func Test_BinanceMarketOrder(t *testing.T) {
+	// set up driver
+	d, standIn := newBinanceStandIn(t, map[string]func(url.Values) (int, string){
+		"/api/v3/exchangeInfo": func(url.Values) (int, string) {
+			return 200, `{"symbols":[{"symbol":"ETHBTC","filters":[` +
+				`{"filterType":"PRICE_FILTER","tickSize":"0.00001000"},` +
+				`{"filterType":"LOT_SIZE","stepSize":"0.00010000"},` +
+				`{"filterType":"MARKET_LOT_SIZE","stepSize":"0.00000000"}]}]}`
+		},
+		"/api/v3/order": func(query url.Values) (int, string) {
+			if query.Get("side") == "SELL" {
+				return 400, `{"code":-2010,` +
+					`"msg":"Account has insufficient balance for requested action."}`
+			}
+
+			return 200, `{"symbol":"ETHBTC","orderId":28}`
+		},
+	})
+
+	// MarketOrder() three times
+	pair := market.NewPair("ETH", "BTC")
+	quantity, _ := decimal.Parse("0.12345678")
+
+	err := d.MarketOrder("buy", pair, quantity)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	insufficientErr := d.MarketOrder("sell", pair, quantity)
+	minimumErr := d.MarketOrder("buy", pair, decimal.Decimal(9_000))
+
+	// assert
+	var order url.Values
+	for _, request := range standIn.Requests {
+		if request.Path == "/api/v3/order" {
+			order = request.Query
+			break
+		}
+	}
+
+	if order.Get("symbol") != "ETHBTC" ||
+		order.Get("side") != "BUY" ||
+		order.Get("type") != "MARKET" ||
+		order.Get("quantity") != "0.1234" {
+		t.Errorf("order != 0.1234 ETHBTC market buy: %v", order)
+	}
+
+	if !errors.Is(insufficientErr, ErrInsufficientFunds) {
+		t.Errorf("err != ErrInsufficientFunds: %v", insufficientErr)
+	}
+
+	if !errors.Is(minimumErr, ErrOrderMinimum) {
+		t.Errorf("err != ErrOrderMinimum: %v", minimumErr)
+	}
+
+	// the step size is retrieved once, and the rejected sell isn't retried
+	paths := map[string]int{}
+	for _, request := range standIn.Requests {
+		paths[request.Path]++
+	}
+
+	if paths["/api/v3/exchangeInfo"] != 1 || paths["/api/v3/order"] != 2 {
+		t.Errorf("requests != 1 exchangeInfo and 2 orders: %v", paths)
+	}
+}
+
+func Test_BinanceResyncsTime(t *testing.T) {
+	// set up driver
+	d, standIn := newBinanceStandIn(t, map[string]func(url.Values) (int, string){
+		"/api/v3/account": func(url.Values) (int, string) {
+			return 200, `{"balances":[]}`
+		},
+	})
+
+	// FetchBalances() before and after the server's clock jumps
+	d.FetchBalances()
+	standIn.setOffset(10 * time.Second)
+
+	_, err := d.FetchBalances()
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	paths := []string{}
+	for _, request := range standIn.Requests {
+		paths = append(paths, request.Path)
+	}
+
+	expected := []string{
+		"/api/v3/time",
+		"/api/v3/account",
+		"/api/v3/account",
+		"/api/v3/time",
+		"/api/v3/account",
+	}
+	if strings.Join(paths, " ") != strings.Join(expected, " ") {
+		t.Errorf("paths != %v: %v", expected, paths)
+	}
+}
+
+func Test_BinanceUnsupportedInterval(t *testing.T) {
+	// set up driver
+	d, standIn := newBinanceStandIn(t, nil)
+
+	// FetchFramesSince()
+	_, err := d.FetchFramesSince(
+		market.NewPair("BTC", "USDT"),
+		7*time.Minute,
+		time.Now(),
+	)
+
+	// assert
+	if err == nil {
+		t.Error("err == nil")
+	}
+
+	if len(standIn.Requests) != 0 {
+		t.Errorf("len(requests) != 0: %d", len(standIn.Requests))
+	}
+}
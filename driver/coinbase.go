@@ -0,0 +1,635 @@
+package driver
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/haydenhigg/chrys/decimal"
+	"github.com/haydenhigg/chrys/frame"
+	"github.com/haydenhigg/chrys/market"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	COINBASE_URL           = "https://api.coinbase.com"
+	COINBASE_CANDLE_LIMIT  = 300
+	COINBASE_ACCOUNT_LIMIT = 250
+
+	// the default most pages of candles to retrieve at once
+	COINBASE_MAX_CANDLE_PAGES = 10
+
+	// how many authenticated requests can be made per second
+	COINBASE_RATE_LIMIT = 30
+)
+
+// candles are retrieved no further back than when Coinbase's exchange opened
+var coinbaseEpoch = time.Date(2015, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Coinbase's names for the intervals it has candles for
+var coinbaseGranularities = map[time.Duration]string{
+	time.Minute:      "ONE_MINUTE",
+	5 * time.Minute:  "FIVE_MINUTE",
+	15 * time.Minute: "FIFTEEN_MINUTE",
+	30 * time.Minute: "THIRTY_MINUTE",
+	time.Hour:        "ONE_HOUR",
+	2 * time.Hour:    "TWO_HOUR",
+	4 * time.Hour:    "FOUR_HOUR",
+	6 * time.Hour:    "SIX_HOUR",
+	24 * time.Hour:   "ONE_DAY",
+}
+
+// the sizes that quantities of a product must be a multiple and at least of
+type coinbaseProduct struct {
+	increment decimal.Decimal
+	minimum   decimal.Decimal
+}
+
+type CoinbaseDriver struct {
+	KeyName string // like organizations/{org_id}/apiKeys/{key_id}
+	key     *ecdsa.PrivateKey
+
+	// where requests are sent, so that they can go to a proxy or stand-in
+	// server; defaults to COINBASE_URL
+	BaseURL    string
+	HTTPClient *http.Client
+
+	// the most pages of candles to retrieve for one request for frames;
+	// defaults to COINBASE_MAX_CANDLE_PAGES, and 0 is unlimited
+	MaxCandlePages int
+
+	// how many times to retry a failed request, waiting RetryDelay before the
+	// first retry and twice as long before each one after
+	MaxRetries int
+	RetryDelay time.Duration
+
+	mu       sync.Mutex
+	products map[string]coinbaseProduct
+	counter  *callCounter
+	now      func() time.Time
+	sleep    func(time.Duration)
+}
+
+// parse a PEM-encoded EC private key, whose newlines may be escaped as they
+// are in the JSON file that Coinbase provides
+func parseCoinbaseKey(privateKey string) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(strings.ReplaceAll(privateKey, `\n`, "\n")))
+	if block == nil {
+		return nil, errors.New("private key is not PEM-encoded")
+	}
+
+	var key any
+	var err error
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported private key type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok || ecKey.Curve != elliptic.P256() {
+		return nil, errors.New("private key is not a P-256 EC key")
+	}
+
+	return ecKey, nil
+}
+
+func NewCoinbase(keyName, privateKey string) (*CoinbaseDriver, error) {
+	key, err := parseCoinbaseKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &CoinbaseDriver{
+		KeyName:        keyName,
+		key:            key,
+		BaseURL:        COINBASE_URL,
+		HTTPClient:     &http.Client{Timeout: 5 * time.Second},
+		MaxCandlePages: COINBASE_MAX_CANDLE_PAGES,
+		MaxRetries:     3,
+		RetryDelay:     500 * time.Millisecond,
+		products:       map[string]coinbaseProduct{},
+		counter:        newRateCounter(COINBASE_RATE_LIMIT),
+		now:            time.Now,
+		sleep:          time.Sleep,
+	}
+
+	return d, nil
+}
+
+// get Coinbase's product ID for a pair, like BTC-USD for BTC/USD, unless the
+// pair has its own
+func (d *CoinbaseDriver) Symbol(pair market.Pair) string {
+	if pair.Symbol != "" {
+		return pair.Symbol
+	}
+
+	return pair.Base + "-" + pair.Quote
+}
+
+// request helpers
+func base64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// build an ES256 JWT that authorizes one request to uri, like
+// "GET api.coinbase.com/api/v3/brokerage/accounts"
+func (d *CoinbaseDriver) buildJWT(uri string) (string, error) {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+
+	header, err := json.Marshal(map[string]any{
+		"alg":   "ES256",
+		"typ":   "JWT",
+		"kid":   d.KeyName,
+		"nonce": hex.EncodeToString(nonce),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	now := d.now()
+	claims, err := json.Marshal(map[string]any{
+		"sub": d.KeyName,
+		"iss": "cdp",
+		"nbf": now.Unix(),
+		"exp": now.Add(2 * time.Minute).Unix(),
+		"uri": uri,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64URL(header) + "." + base64URL(claims)
+	hash := sha256.Sum256([]byte(signingInput))
+
+	r, s, err := ecdsa.Sign(rand.Reader, d.key, hash[:])
+	if err != nil {
+		return "", err
+	}
+
+	// JWS signatures are r and s concatenated rather than DER-encoded
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	return signingInput + "." + base64URL(signature), nil
+}
+
+// basic requests
+func (d *CoinbaseDriver) send(request *http.Request, result any) error {
+	response, err := d.HTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	rawResponse, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	// errors come with a status that isn't 2xx
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		coinbaseErr := &CoinbaseError{}
+		err := json.Unmarshal(rawResponse, coinbaseErr)
+		if err == nil && coinbaseErr.Code != "" {
+			return coinbaseErr
+		} else if response.StatusCode == http.StatusUnauthorized {
+			return &CoinbaseError{"UNAUTHENTICATED", response.Status}
+		} else if response.StatusCode == http.StatusTooManyRequests {
+			return &CoinbaseError{"RATE_LIMIT_EXCEEDED", response.Status}
+		}
+
+		return &HTTPStatusError{response.StatusCode, response.Status}
+	}
+
+	// unmarshal raw response
+	if result != nil {
+		if err := json.Unmarshal(rawResponse, result); err != nil {
+			return fmt.Errorf("%w: %v", ErrMalformedResponse, err)
+		}
+	}
+
+	return nil
+}
+
+// send requests made by build until one succeeds
+func (d *CoinbaseDriver) do(
+	build func() (*http.Request, error),
+	idempotent bool,
+	result any,
+) error {
+	return retry(
+		retryPolicy{d.MaxRetries, d.RetryDelay, d.sleep},
+		build,
+		func(request *http.Request) error { return d.send(request, result) },
+		idempotent,
+		func(err error) error {
+			// wait out a full second of requests, on top of the backoff
+			if errors.Is(err, ErrRateLimited) {
+				d.counter.fill()
+			}
+
+			return nil
+		},
+	)
+}
+
+func (d *CoinbaseDriver) request(
+	method,
+	path string,
+	query url.Values,
+	body any,
+	result any,
+) error {
+	base, err := url.Parse(d.BaseURL)
+	if err != nil {
+		return err
+	}
+
+	var rawBody []byte
+	if body != nil {
+		if rawBody, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	u := strings.TrimSuffix(d.BaseURL, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	// orders carry a client order ID that Coinbase deduplicates, so every
+	// request can be retried
+	return d.do(func() (*http.Request, error) {
+		d.counter.wait(1)
+
+		// every attempt needs a new JWT
+		jwt, err := d.buildJWT(method + " " + base.Host + path)
+		if err != nil {
+			return nil, err
+		}
+
+		// create the *http.Request
+		request, err := http.NewRequest(method, u, bytes.NewReader(rawBody))
+		if err != nil {
+			return nil, err
+		}
+
+		request.Header.Set("Authorization", "Bearer "+jwt)
+		request.Header.Set("Content-Type", "application/json")
+
+		return request, nil
+	}, true, result)
+}
+
+// retrieve candles in windows of at most COINBASE_CANDLE_LIMIT, along with
+// the one that is still forming
+func (d *CoinbaseDriver) fetchCandles(
+	pair market.Pair,
+	interval time.Duration,
+	since time.Time,
+) ([]*frame.Frame, *frame.Frame, error) {
+	granularity, ok := coinbaseGranularities[interval]
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported interval %v", interval)
+	}
+
+	path := "/api/v3/brokerage/products/" + d.Symbol(pair) + "/candles"
+	now := d.now()
+
+	// a window for every interval since a zero time would take forever
+	if since.Before(coinbaseEpoch) {
+		since = coinbaseEpoch
+	}
+
+	frames := []*frame.Frame{}
+	var forming *frame.Frame
+
+	window := COINBASE_CANDLE_LIMIT * interval
+	for start, pages := since, 0; start.Before(now); pages++ {
+		// stop rather than return frames that end before now
+		if d.MaxCandlePages > 0 && pages >= d.MaxCandlePages {
+			remaining := now.Truncate(interval).Sub(start) / interval
+			return nil, nil, &frame.InsufficientFramesError{
+				Pair:      pair,
+				Interval:  interval,
+				Requested: len(frames) + int(remaining),
+				Available: len(frames),
+			}
+		}
+
+		end := start.Add(window)
+
+		// make request
+		var result struct {
+			Candles []struct {
+				Start  string `json:"start"`
+				Open   string `json:"open"`
+				High   string `json:"high"`
+				Low    string `json:"low"`
+				Close  string `json:"close"`
+				Volume string `json:"volume"`
+			} `json:"candles"`
+		}
+		err := d.request("GET", path, url.Values{
+			"start":       {strconv.FormatInt(start.Unix(), 10)},
+			"end":         {strconv.FormatInt(end.Unix()-1, 10)},
+			"granularity": {granularity},
+		}, nil, &result)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// process returned frames, which are newest first
+		page := []*frame.Frame{}
+
+		for _, candle := range result.Candles {
+			values, err := parseRow([]any{
+				candle.Start,
+				candle.Open,
+				candle.High,
+				candle.Low,
+				candle.Close,
+				candle.Volume,
+			}, 0, 1, 2, 3, 4, 5)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			f := &frame.Frame{
+				Time:   time.Unix(int64(values[0]), 0),
+				Open:   values[1],
+				High:   values[2],
+				Low:    values[3],
+				Close:  values[4],
+				Volume: values[5],
+			}
+
+			if f.Time.Before(start) || !f.Time.Before(end) {
+				continue
+			} else if f.Time.Add(interval).After(now) {
+				forming = f
+				continue
+			}
+
+			page = append(page, f)
+		}
+
+		slices.SortFunc(page, func(a, b *frame.Frame) int {
+			return a.Time.Compare(b.Time)
+		})
+		frames = append(frames, page...)
+		start = end
+	}
+
+	if len(frames) == 0 && forming == nil {
+		return nil, nil, fmt.Errorf("%w for %v", frame.ErrNoData, pair)
+	}
+
+	return frames, forming, nil
+}
+
+// retrieve the sizes that quantities of a product are limited to
+func (d *CoinbaseDriver) fetchProduct(
+	productID string,
+) (coinbaseProduct, error) {
+	d.mu.Lock()
+	product, ok := d.products[productID]
+	d.mu.Unlock()
+
+	if ok {
+		return product, nil
+	}
+
+	// make request
+	var result struct {
+		BaseIncrement string `json:"base_increment"`
+		BaseMinSize   string `json:"base_min_size"`
+	}
+	err := d.request(
+		"GET",
+		"/api/v3/brokerage/products/"+productID,
+		nil,
+		nil,
+		&result,
+	)
+	if err != nil {
+		return product, err
+	}
+
+	if product.increment, err = decimal.Parse(result.BaseIncrement); err != nil {
+		return product, fmt.Errorf("%w: %v", ErrMalformedResponse, err)
+	}
+
+	if product.minimum, err = decimal.Parse(result.BaseMinSize); err != nil {
+		return product, fmt.Errorf("%w: %v", ErrMalformedResponse, err)
+	}
+
+	d.mu.Lock()
+	d.products[productID] = product
+	d.mu.Unlock()
+
+	return product, nil
+}
+
+// driver functions
+func (d *CoinbaseDriver) FetchFramesSince(
+	pair market.Pair,
+	interval time.Duration,
+	since time.Time,
+) ([]*frame.Frame, error) {
+	frames, _, err := d.fetchCandles(pair, interval, since)
+	return frames, err
+}
+
+func (d *CoinbaseDriver) FetchFormingFramesSince(
+	pair market.Pair,
+	interval time.Duration,
+	since time.Time,
+) ([]*frame.Frame, *frame.Frame, error) {
+	return d.fetchCandles(pair, interval, since)
+}
+
+// list the spot products that can be traded, with their product IDs
+func (d *CoinbaseDriver) FetchPairs() ([]market.Pair, error) {
+	// make request
+	var result struct {
+		Products []struct {
+			ProductID       string `json:"product_id"`
+			BaseCurrencyID  string `json:"base_currency_id"`
+			QuoteCurrencyID string `json:"quote_currency_id"`
+			TradingDisabled bool   `json:"trading_disabled"`
+			IsDisabled      bool   `json:"is_disabled"`
+		} `json:"products"`
+	}
+	err := d.request("GET", "/api/v3/brokerage/products", url.Values{
+		"product_type": {"SPOT"},
+	}, nil, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	pairs := make([]market.Pair, 0, len(result.Products))
+	for _, product := range result.Products {
+		if product.TradingDisabled || product.IsDisabled {
+			continue
+		}
+
+		pair := market.NewPair(product.BaseCurrencyID, product.QuoteCurrencyID)
+		pairs = append(pairs, pair.WithSymbol(product.ProductID))
+	}
+
+	return pairs, nil
+}
+
+// retrieve the balances that are available to trade, a page of accounts at a
+// time
+func (d *CoinbaseDriver) FetchBalances() (map[string]decimal.Decimal, error) {
+	balances := map[string]decimal.Decimal{}
+	outOfRange := map[string]bool{}
+
+	for cursor := ""; ; {
+		// make request
+		query := url.Values{"limit": {strconv.Itoa(COINBASE_ACCOUNT_LIMIT)}}
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+
+		var result struct {
+			Accounts []struct {
+				Currency         string `json:"currency"`
+				AvailableBalance struct {
+					Value string `json:"value"`
+				} `json:"available_balance"`
+			} `json:"accounts"`
+			HasNext bool   `json:"has_next"`
+			Cursor  string `json:"cursor"`
+		}
+		err := d.request("GET", "/api/v3/brokerage/accounts", query, nil, &result)
+		if err != nil {
+			return nil, err
+		}
+
+		// process returned quantities
+		for _, account := range result.Accounts {
+			currency := account.Currency
+			balance, err := decimal.Parse(account.AvailableBalance.Value)
+			if err == nil {
+				balance, err = balances[currency].Add(balance)
+			}
+
+			// leave out balances past Decimal's range rather than failing
+			// the others
+			if errors.Is(err, decimal.ErrOverflow) {
+				outOfRange[currency] = true
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrMalformedResponse, err)
+			}
+
+			// don't include balances of 0
+			if balance.IsZero() {
+				continue
+			}
+
+			balances[currency] = balance
+		}
+
+		// there are no more accounts
+		if !result.HasNext || result.Cursor == "" || result.Cursor == cursor {
+			for currency := range outOfRange {
+				delete(balances, currency)
+			}
+
+			return balances, nil
+		}
+
+		cursor = result.Cursor
+	}
+}
+
+// get a random version 4 UUID
+func newClientOrderID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+
+	return fmt.Sprintf(
+		"%x-%x-%x-%x-%x",
+		id[:4], id[4:6], id[6:8], id[8:10], id[10:],
+	)
+}
+
+func (d *CoinbaseDriver) MarketOrder(
+	side string,
+	pair market.Pair,
+	quantity decimal.Decimal,
+) error {
+	productID := d.Symbol(pair)
+
+	// quantities must be a multiple of the product's increment
+	product, err := d.fetchProduct(productID)
+	if err != nil {
+		return err
+	}
+
+	truncated := quantity.Truncate(product.increment)
+	if truncated.Sign() <= 0 || truncated < product.minimum {
+		return fmt.Errorf("%w: %v of %s", ErrOrderMinimum, quantity, productID)
+	}
+
+	// make request
+	var result struct {
+		Success       bool           `json:"success"`
+		ErrorResponse *CoinbaseError `json:"error_response"`
+		FailureReason string         `json:"failure_reason"`
+	}
+	err = d.request("POST", "/api/v3/brokerage/orders", nil, map[string]any{
+		"client_order_id": newClientOrderID(),
+		"product_id":      productID,
+		"side":            strings.ToUpper(side),
+		"order_configuration": map[string]any{
+			"market_market_ioc": map[string]string{
+				"base_size": truncated.String(),
+			},
+		},
+	}, &result)
+	if err != nil {
+		return err
+	}
+
+	// failed orders still come with a 2xx status
+	if !result.Success {
+		if result.ErrorResponse != nil && result.ErrorResponse.Code != "" {
+			return result.ErrorResponse
+		}
+
+		return &CoinbaseError{Code: result.FailureReason}
+	}
+
+	return nil
+}
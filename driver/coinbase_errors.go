@@ -0,0 +1,48 @@
+package driver
+
+import "strings"
+
+// an error returned by Coinbase, either for a request or for an order that
+// failed, like INSUFFICIENT_FUND
+type CoinbaseError struct {
+	Code    string `json:"error"`
+	Message string `json:"message"`
+}
+
+func (err *CoinbaseError) Error() string {
+	if err.Message == "" {
+		return "coinbase: " + err.Code
+	}
+
+	return "coinbase: " + err.Code + ": " + err.Message
+}
+
+func (err *CoinbaseError) exchange() string {
+	return "coinbase"
+}
+
+// match the error to one of the sentinel errors, if any
+func (err *CoinbaseError) Unwrap() error {
+	code := strings.ToUpper(err.Code)
+
+	switch {
+	case strings.Contains(code, "RATE_LIMIT"):
+		return ErrRateLimited
+	case code == "UNAUTHENTICATED" || code == "INVALID_API_KEY":
+		return ErrInvalidKey
+	case code == "PERMISSION_DENIED":
+		return ErrPermissionDenied
+	case strings.Contains(code, "INSUFFICIENT_FUND"):
+		return ErrInsufficientFunds
+	case strings.Contains(code, "TOO_SMALL"):
+		return ErrOrderMinimum
+	case code == "INVALID_PRODUCT_ID" || code == "NOT_FOUND":
+		return ErrUnknownPair
+	case code == "INVALID_ARGUMENT" || strings.Contains(code, "PRECISION"):
+		return ErrInvalidArguments
+	case code == "INTERNAL" || code == "UNAVAILABLE":
+		return ErrUnavailable
+	}
+
+	return nil
+}
@@ -0,0 +1,530 @@
+package driver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/haydenhigg/chrys/decimal"
+	"github.com/haydenhigg/chrys/frame"
+	"github.com/haydenhigg/chrys/market"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mock
+type coinbaseRoute func(query url.Values, body map[string]any) (int, string)
+
+type CoinbaseStandIn struct {
+	*httptest.Server
+	mu       sync.Mutex
+	routes   map[string]coinbaseRoute
+	Requests []standInRequest
+}
+
+// verify an ES256 JWT the way Coinbase does, returning its claims
+func verifyJWT(
+	token string,
+	key *ecdsa.PublicKey,
+) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token doesn't have 3 parts")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(signature) != 64 {
+		return nil, errors.New("signature isn't 64 bytes")
+	}
+
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(key, hash[:], r, s) {
+		return nil, errors.New("signature doesn't verify")
+	}
+
+	header := map[string]any{}
+	claims := map[string]any{}
+	for i, v := range []map[string]any{header, claims} {
+		raw, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			return nil, err
+		} else if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, err
+		}
+	}
+
+	if header["alg"] != "ES256" || header["kid"] != "key" ||
+		header["nonce"] == "" {
+		return nil, fmt.Errorf("header is wrong: %v", header)
+	}
+
+	return claims, nil
+}
+
+// a CoinbaseStandIn answers requests by path, checking JWTs with the public
+// half of the driver's key
+func newCoinbaseStandIn(
+	t *testing.T,
+	routes map[string]coinbaseRoute,
+) (*CoinbaseDriver, *CoinbaseStandIn) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	der, _ := x509.MarshalECPrivateKey(key)
+	privateKey := pem.EncodeToMemory(&pem.Block{
+		Type:  "EC PRIVATE KEY",
+		Bytes: der,
+	})
+
+	standIn := &CoinbaseStandIn{routes: routes}
+
+	standIn.Server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+
+			standIn.mu.Lock()
+			standIn.Requests = append(
+				standIn.Requests,
+				standInRequest{r.URL.Path, query, nil},
+			)
+			standIn.mu.Unlock()
+
+			// verify the JWT independently of the driver
+			token, _ := strings.CutPrefix(
+				r.Header.Get("Authorization"),
+				"Bearer ",
+			)
+			claims, err := verifyJWT(token, &key.PublicKey)
+
+			uri := r.Method + " " + r.Host + r.URL.Path
+			if err != nil || claims["sub"] != "key" || claims["uri"] != uri ||
+				claims["exp"].(float64) < float64(time.Now().Unix()) {
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprint(w, "Unauthorized")
+				return
+			}
+
+			route, ok := standIn.routes[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `{"error":"NOT_FOUND","message":"not found"}`)
+				return
+			}
+
+			var body map[string]any
+			if raw, _ := io.ReadAll(r.Body); len(raw) > 0 {
+				json.Unmarshal(raw, &body)
+			}
+
+			status, response := route(query, body)
+			w.WriteHeader(status)
+			fmt.Fprint(w, response)
+		},
+	))
+	t.Cleanup(standIn.Close)
+
+	// use the escaped newlines of the JSON file Coinbase provides
+	d, err := NewCoinbase(
+		"key",
+		strings.ReplaceAll(string(privateKey), "\n", `\n`),
+	)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	d.BaseURL = standIn.URL
+	d.HTTPClient = standIn.Client()
+	d.sleep = func(time.Duration) {}
+	d.counter.sleep = func(time.Duration) {}
+
+	return d, standIn
+}
+
+// candles with a close of their index, newest first, from start to end but
+// no later than now
+func candles(now time.Time, interval time.Duration) coinbaseRoute {
+	return func(query url.Values, _ map[string]any) (int, string) {
+		start, _ := strconv.ParseInt(query.Get("start"), 10, 64)
+		end, _ := strconv.ParseInt(query.Get("end"), 10, 64)
+
+		// Coinbase rejects ranges with too many candles
+		seconds := int64(interval / time.Second)
+		if (end-start)/seconds+1 > COINBASE_CANDLE_LIMIT {
+			return 400, `{"error":"INVALID_ARGUMENT","message":"too many"}`
+		}
+
+		rows := []string{}
+		for t := time.Unix(start, 0).Truncate(interval); t.Unix() <= end &&
+			!t.After(now); t = t.Add(interval) {
+			i := t.Sub(now.Truncate(interval)) / interval
+			rows = append([]string{fmt.Sprintf(
+				`{"start":"%d","low":"%d","high":"%d","open":"%d",`+
+					`"close":"%d","volume":"1.5"}`,
+				t.Unix(), i, i, i, i,
+			)}, rows...)
+		}
+
+		return 200, `{"candles":[` + strings.Join(rows, ",") + "]}"
+	}
+}
+
+// tests
+func Test_CoinbaseFetchFramesSinceWindows(t *testing.T) {
+	// set up driver
+	now := time.Now()
+	d, standIn := newCoinbaseStandIn(t, map[string]coinbaseRoute{
+		"/api/v3/brokerage/products/BTC-USD/candles": candles(now, time.Minute),
+	})
+
+	// FetchFormingFramesSince()
+	since := now.Truncate(time.Minute).Add(-700 * time.Minute)
+	frames, forming, err := d.FetchFormingFramesSince(
+		market.NewPair("BTC", "USD"),
+		time.Minute,
+		since,
+	)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if len(frames) != 700 {
+		t.Fatalf("len(frames) != 700: %d", len(frames))
+	}
+
+	for i, f := range frames {
+		if !f.Time.Equal(since.Add(time.Duration(i) * time.Minute)) {
+			t.Fatalf("frames[%d] is out of order: %v", i, f)
+		}
+	}
+
+	if frames[699].Close != -1 || frames[0].Volume != 1.5 {
+		t.Errorf("frames[699] != -1: %v", frames[699])
+	}
+
+	if forming == nil || !forming.Time.Equal(now.Truncate(time.Minute)) {
+		t.Errorf("forming != now: %v", forming)
+	}
+
+	if len(standIn.Requests) != 3 {
+		t.Fatalf("len(requests) != 3: %d", len(standIn.Requests))
+	}
+
+	second := standIn.Requests[1].Query
+	start := fmt.Sprint(since.Add(300 * time.Minute).Unix())
+	if second.Get("start") != start || second.Get("granularity") != "ONE_MINUTE" {
+		t.Errorf("query != ONE_MINUTE from %s: %v", start, second)
+	}
+}
+
+func Test_CoinbaseFetchFramesSinceClamped(t *testing.T) {
+	// set up driver
+	d, standIn := newCoinbaseStandIn(t, map[string]coinbaseRoute{
+		"/api/v3/brokerage/products/BTC-USD/candles": candles(
+			time.Now(),
+			24*time.Hour,
+		),
+	})
+	d.MaxCandlePages = 0
+
+	// FetchFramesSince() from a zero time
+	frames, err := d.FetchFramesSince(
+		market.NewPair("BTC", "USD"),
+		24*time.Hour,
+		time.Time{},
+	)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if !frames[0].Time.Equal(coinbaseEpoch) {
+		t.Errorf("frames[0] != %v: %v", coinbaseEpoch, frames[0])
+	}
+
+	start := standIn.Requests[0].Query.Get("start")
+	if start != fmt.Sprint(coinbaseEpoch.Unix()) {
+		t.Errorf("start != %d: %s", coinbaseEpoch.Unix(), start)
+	}
+}
+
+func Test_CoinbaseFetchFramesSinceCapped(t *testing.T) {
+	// set up driver
+	now := time.Now()
+	d, standIn := newCoinbaseStandIn(t, map[string]coinbaseRoute{
+		"/api/v3/brokerage/products/BTC-USD/candles": candles(now, time.Minute),
+	})
+	d.MaxCandlePages = 1
+
+	// FetchFramesSince()
+	since := now.Truncate(time.Minute).Add(-500 * time.Minute)
+	pair := market.NewPair("BTC", "USD")
+	_, err := d.FetchFramesSince(pair, time.Minute, since)
+
+	// assert
+	var insufficientErr *frame.InsufficientFramesError
+	if !errors.As(err, &insufficientErr) {
+		t.Fatalf("err != InsufficientFramesError: %v", err)
+	}
+
+	if insufficientErr.Requested != 500 || insufficientErr.Available != 300 {
+		t.Errorf("requested 500 with 300 available != %v", insufficientErr)
+	}
+
+	if len(standIn.Requests) != 1 {
+		t.Errorf("len(requests) != 1: %d", len(standIn.Requests))
+	}
+}
+
+func Test_CoinbaseRateLimited(t *testing.T) {
+	// set up driver that is rate limited once
+	limited := false
+	d, standIn := newCoinbaseStandIn(t, map[string]coinbaseRoute{
+		"/api/v3/brokerage/products/BTC-USD": func(
+			url.Values,
+			map[string]any,
+		) (int, string) {
+			if !limited {
+				limited = true
+				return 429, "Too Many Requests"
+			}
+
+			return 200, `{"base_increment":"0.00000001",` +
+				`"base_min_size":"0.0001"}`
+		},
+	})
+
+	now := time.Now()
+	waits := []time.Duration{}
+	d.counter.now = func() time.Time { return now }
+	d.counter.sleep = func(d time.Duration) { waits = append(waits, d) }
+
+	// fetchProduct()
+	_, err := d.fetchProduct("BTC-USD")
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if len(standIn.Requests) != 2 {
+		t.Errorf("len(requests) != 2: %d", len(standIn.Requests))
+	}
+
+	if len(waits) != 1 || waits[0] != time.Second/COINBASE_RATE_LIMIT {
+		t.Errorf("waits != [%v]: %v", time.Second/COINBASE_RATE_LIMIT, waits)
+	}
+}
+
+func Test_CoinbaseFetchBalancesPaginates(t *testing.T) {
+	// set up driver
+	d, standIn := newCoinbaseStandIn(t, map[string]coinbaseRoute{
+		"/api/v3/brokerage/accounts": func(
+			query url.Values,
+			_ map[string]any,
+		) (int, string) {
+			if query.Get("cursor") == "" {
+				return 200, `{"accounts":[` +
+					`{"currency":"BTC","available_balance":{"value":"0.00123456"}},` +
+					`{"currency":"ETH","available_balance":{"value":"0"}}],` +
+					`"has_next":true,"cursor":"page2"}`
+			}
+
+			return 200, `{"accounts":[` +
+				`{"currency":"USD","available_balance":{"value":"100.1"}}],` +
+				`"has_next":false,"cursor":""}`
+		},
+	})
+
+	// FetchBalances()
+	balances, err := d.FetchBalances()
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if len(balances) != 2 {
+		t.Errorf("len(balances) != 2: %v", balances)
+	}
+
+	if balances["BTC"].String() != "0.00123456" {
+		t.Errorf("BTC != 0.00123456: %v", balances["BTC"])
+	}
+
+	if balances["USD"] != decimal.Decimal(10_010_000_000) {
+		t.Errorf("USD != 100.1: %v", balances["USD"])
+	}
+
+	if len(standIn.Requests) != 2 ||
+		standIn.Requests[1].Query.Get("cursor") != "page2" {
+		t.Errorf("requests != 2 pages: %v", standIn.Requests)
+	}
+}
+
+func Test_CoinbaseFetchPairs(t *testing.T) {
+	// set up driver
+	d, standIn := newCoinbaseStandIn(t, map[string]coinbaseRoute{
+		"/api/v3/brokerage/products": func(
+			url.Values,
+			map[string]any,
+		) (int, string) {
+			return 200, `{"products":[` +
+				`{"product_id":"BTC-USD","base_currency_id":"BTC",` +
+				`"quote_currency_id":"USD","trading_disabled":false},` +
+				`{"product_id":"OLD-USD","base_currency_id":"OLD",` +
+				`"quote_currency_id":"USD","trading_disabled":true}]}`
+		},
+	})
+
+	// FetchPairs()
+	pairs, err := d.FetchPairs()
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	expected := market.NewPair("BTC", "USD").WithSymbol("BTC-USD")
+	if len(pairs) != 1 || pairs[0] != expected {
+		t.Errorf("pairs != [%v]: %v", expected, pairs)
+	}
+
+	if query := standIn.Requests[0].Query; query.Get("product_type") != "SPOT" {
+		t.Errorf("product_type != SPOT: %v", query)
+	}
+}
+
+func Test_CoinbaseMarketOrder(t *testing.T) {
+	// set up driver whose first order attempt fails
+	orders := []map[string]any{}
+	d, _ := newCoinbaseStandIn(t, map[string]coinbaseRoute{
+		"/api/v3/brokerage/products/ETH-BTC": func(
+			url.Values,
+			map[string]any,
+		) (int, string) {
+			return 200, `{"product_id":"ETH-BTC",` +
+				`"base_increment":"0.0001","base_min_size":"0.001"}`
+		},
+		"/api/v3/brokerage/orders": func(
+			_ url.Values,
+			body map[string]any,
+		) (int, string) {
+			orders = append(orders, body)
+
+			if len(orders) == 1 {
+				return 503, `{"error":"UNAVAILABLE","message":"try again"}`
+			} else if body["side"] == "SELL" {
+				return 200, `{"success":false,"error_response":{` +
+					`"error":"INSUFFICIENT_FUND","message":"Insufficient balance"}}`
+			}
+
+			return 200, `{"success":true,"success_response":{"order_id":"1"}}`
+		},
+	})
+
+	// MarketOrder() three times
+	pair := market.NewPair("ETH", "BTC")
+	quantity, _ := decimal.Parse("0.12345678")
+
+	err := d.MarketOrder("buy", pair, quantity)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	insufficientErr := d.MarketOrder("sell", pair, quantity)
+	minimumErr := d.MarketOrder("buy", pair, decimal.Decimal(90_000))
+
+	// assert
+	if len(orders) != 3 {
+		t.Fatalf("len(orders) != 3: %d", len(orders))
+	}
+
+	order := orders[1]
+	configuration, _ := order["order_configuration"].(map[string]any)
+	ioc, _ := configuration["market_market_ioc"].(map[string]any)
+	if order["product_id"] != "ETH-BTC" ||
+		order["side"] != "BUY" ||
+		ioc["base_size"] != "0.1234" {
+		t.Errorf("order != 0.1234 ETH-BTC market buy: %v", order)
+	}
+
+	// the retry reuses the client order ID so that it can't fill twice
+	if orders[0]["client_order_id"] == "" ||
+		orders[0]["client_order_id"] != orders[1]["client_order_id"] ||
+		orders[1]["client_order_id"] == orders[2]["client_order_id"] {
+		t.Errorf("client order IDs are wrong: %v", orders)
+	}
+
+	if !errors.Is(insufficientErr, ErrInsufficientFunds) {
+		t.Errorf("err != ErrInsufficientFunds: %v", insufficientErr)
+	}
+
+	if !errors.Is(minimumErr, ErrOrderMinimum) {
+		t.Errorf("err != ErrOrderMinimum: %v", minimumErr)
+	}
+}
+
+func Test_CoinbaseErrors(t *testing.T) {
+	// set up driver
+	d, standIn := newCoinbaseStandIn(t, nil)
+
+	// FetchFramesSince() with an unknown pair and an unsupported interval
+	_, unknownErr := d.FetchFramesSince(
+		market.NewPair("ABC", "XYZ"),
+		time.Hour,
+		time.Now(),
+	)
+	_, intervalErr := d.FetchFramesSince(
+		market.NewPair("BTC", "USD"),
+		7*time.Minute,
+		time.Now(),
+	)
+
+	// FetchBalances() with another key
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	d.key = other
+	_, keyErr := d.FetchBalances()
+
+	// assert
+	if !errors.Is(unknownErr, ErrUnknownPair) {
+		t.Errorf("err != ErrUnknownPair: %v", unknownErr)
+	}
+
+	if intervalErr == nil {
+		t.Error("err == nil")
+	}
+
+	if !errors.Is(keyErr, ErrInvalidKey) {
+		t.Errorf("err != ErrInvalidKey: %v", keyErr)
+	}
+
+	if len(standIn.Requests) != 2 {
+		t.Errorf("len(requests) != 2: %d", len(standIn.Requests))
+	}
+}
+
+func Test_NewCoinbaseInvalidKey(t *testing.T) {
+	// NewCoinbase()
+	_, err := NewCoinbase("key", "not a key")
+
+	// assert
+	if err == nil {
+		t.Error("err == nil")
+	}
+}
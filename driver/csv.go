@@ -0,0 +1,297 @@
+package driver
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/haydenhigg/chrys/frame"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// formats for times that are numbers rather than layouts like time.RFC3339
+const (
+	TIME_SECONDS      = "seconds"
+	TIME_MILLISECONDS = "milliseconds"
+)
+
+// whether the first record of a CSV file is a header
+type HeaderMode int
+
+const (
+	HEADER_AUTO HeaderMode = iota // a header if none of its fields are values
+	HEADER_NONE
+	HEADER_PRESENT
+)
+
+// a bad record in a CSV file
+type RecordError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (err *RecordError) Error() string {
+	return fmt.Sprintf("%s:%d: %v", err.File, err.Line, err.Err)
+}
+
+func (err *RecordError) Unwrap() error {
+	return err.Err
+}
+
+// how the frames in CSV files are laid out
+type CSVSchema struct {
+	// the columns of the time, open, high, low, close and volume, each either
+	// a name from the header or a zero-based index like "0"
+	Columns [6]string
+	Header  HeaderMode
+
+	// TIME_SECONDS, TIME_MILLISECONDS or a layout like time.RFC3339
+	TimeFormat string
+	Delimiter  rune
+
+	// whether bad records are errors rather than skipped
+	Strict bool
+}
+
+func NewCSVSchema() *CSVSchema {
+	return &CSVSchema{
+		Columns:    [6]string{"0", "1", "2", "3", "4", "5"},
+		Header:     HEADER_AUTO,
+		TimeFormat: TIME_SECONDS,
+		Delimiter:  ',',
+	}
+}
+
+// setters
+func (schema *CSVSchema) SetColumns(
+	time,
+	open,
+	high,
+	low,
+	close,
+	volume string,
+) *CSVSchema {
+	schema.Columns = [6]string{time, open, high, low, close, volume}
+	return schema
+}
+
+func (schema *CSVSchema) SetHeader(header HeaderMode) *CSVSchema {
+	schema.Header = header
+	return schema
+}
+
+func (schema *CSVSchema) SetTimeFormat(format string) *CSVSchema {
+	schema.TimeFormat = format
+	return schema
+}
+
+func (schema *CSVSchema) SetDelimiter(delimiter rune) *CSVSchema {
+	schema.Delimiter = delimiter
+	return schema
+}
+
+func (schema *CSVSchema) SetStrict(strict bool) *CSVSchema {
+	schema.Strict = strict
+	return schema
+}
+
+// parsing helpers
+func (schema *CSVSchema) parseTime(s string) (time.Time, error) {
+	switch schema.TimeFormat {
+	case TIME_SECONDS:
+		seconds, err := strconv.ParseInt(s, 10, 64)
+		return time.Unix(seconds, 0), err
+	case TIME_MILLISECONDS:
+		ms, err := strconv.ParseInt(s, 10, 64)
+		return time.UnixMilli(ms), err
+	}
+
+	return time.Parse(schema.TimeFormat, s)
+}
+
+// find the index of each column, and whether the first record is a header
+// rather than a frame
+func (schema *CSVSchema) resolve(first []string) ([]int, bool, error) {
+	header := map[string]int{}
+	for i, name := range first {
+		header[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	indices := make([]int, len(schema.Columns))
+	named := false
+
+	for i, column := range schema.Columns {
+		if index, err := strconv.Atoi(column); err == nil && index >= 0 {
+			indices[i] = index
+			continue
+		}
+
+		index, ok := header[strings.ToLower(column)]
+		if !ok || schema.Header == HEADER_NONE {
+			return nil, false, fmt.Errorf("no column %q in header", column)
+		}
+
+		indices[i] = index
+		named = true
+	}
+
+	switch {
+	case named || schema.Header == HEADER_PRESENT:
+		return indices, true, nil
+	case schema.Header == HEADER_NONE:
+		return indices, false, nil
+	}
+
+	return indices, schema.isHeader(first, indices), nil
+}
+
+// whether a record has no time or number where the schema expects one, so
+// that a malformed first record isn't mistaken for a header
+func (schema *CSVSchema) isHeader(record []string, indices []int) bool {
+	for i, index := range indices {
+		if index >= len(record) {
+			continue
+		}
+
+		var err error
+		if i == 0 {
+			_, err = schema.parseTime(record[index])
+		} else {
+			_, err = strconv.ParseFloat(record[index], 64)
+		}
+
+		if err == nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (schema *CSVSchema) parse(
+	record []string,
+	indices []int,
+) (*frame.Frame, error) {
+	for _, index := range indices {
+		if index >= len(record) {
+			return nil, fmt.Errorf(
+				"record has %d fields, not %d",
+				len(record),
+				index+1,
+			)
+		}
+	}
+
+	t, err := schema.parseTime(record[indices[0]])
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]float64, len(indices)-1)
+	for i, index := range indices[1:] {
+		values[i], err = strconv.ParseFloat(record[index], 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &frame.Frame{
+		Time:   t,
+		Open:   values[0],
+		High:   values[1],
+		Low:    values[2],
+		Close:  values[3],
+		Volume: values[4],
+	}, nil
+}
+
+// read every frame from CSV data, naming the source in errors
+func (schema *CSVSchema) Read(
+	r io.Reader,
+	name string,
+) ([]*frame.Frame, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = schema.Delimiter
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	frames := []*frame.Frame{}
+	var indices []int
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		// the CSV itself is malformed, or it couldn't be read at all
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			if schema.Strict {
+				return nil, &RecordError{name, parseErr.StartLine, err}
+			}
+
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+
+		// the first record determines the columns
+		if indices == nil {
+			var isHeader bool
+			indices, isHeader, err = schema.resolve(record)
+			if err != nil {
+				return nil, &RecordError{name, line, err}
+			} else if isHeader {
+				continue
+			}
+		}
+
+		f, err := schema.parse(record, indices)
+		if err != nil {
+			if schema.Strict {
+				return nil, &RecordError{name, line, err}
+			}
+
+			continue
+		}
+
+		frames = append(frames, f)
+	}
+
+	return frames, nil
+}
+
+// read every frame from a CSV file, which may be gzip-compressed
+func (schema *CSVSchema) ReadFile(path string) ([]*frame.Frame, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	buffered := bufio.NewReader(file)
+	var r io.Reader = buffered
+
+	// gzip files start with 0x1f 0x8b, whatever their name
+	if magic, _ := buffered.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		decompressed, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		defer decompressed.Close()
+
+		r = decompressed
+	}
+
+	return schema.Read(r, path)
+}
@@ -0,0 +1,66 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"github.com/haydenhigg/chrys/market"
+)
+
+var (
+	ErrRateLimited       = errors.New("rate limited")
+	ErrTemporaryLockout  = errors.New("temporary lockout")
+	ErrInvalidNonce      = errors.New("invalid nonce")
+	ErrInvalidTimestamp  = errors.New("invalid timestamp")
+	ErrInvalidKey        = errors.New("invalid key")
+	ErrPermissionDenied  = errors.New("permission denied")
+	ErrInvalidArguments  = errors.New("invalid arguments")
+	ErrUnknownPair       = market.ErrUnknownPair
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrOrderMinimum      = errors.New("order minimum not met")
+	ErrUnavailable       = errors.New("service unavailable")
+	ErrHTTPStatus        = errors.New("unexpected HTTP status")
+	ErrMalformedResponse = errors.New("malformed response")
+)
+
+// whether a request can be retried after err; requests that aren't
+// idempotent are only retried if the exchange rejected them without processing
+// them
+func isRetryable(err error, idempotent bool) bool {
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == 429 ||
+			idempotent && statusErr.StatusCode >= 500
+	}
+
+	// a temporary lockout lasts minutes, so it isn't waited out
+	if errors.Is(err, ErrRateLimited) ||
+		errors.Is(err, ErrInvalidNonce) ||
+		errors.Is(err, ErrInvalidTimestamp) {
+		return true
+	} else if !idempotent {
+		return false
+	}
+
+	// anything else from an exchange is final, while connection errors aren't
+	var exchangeErr interface{ exchange() string }
+	if errors.As(err, &exchangeErr) {
+		return errors.Is(err, ErrUnavailable)
+	}
+
+	return !errors.Is(err, ErrMalformedResponse)
+}
+
+// returned for a response whose status isn't 2xx and whose body isn't
+// an exchange's error
+type HTTPStatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (err *HTTPStatusError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrHTTPStatus, err.Status)
+}
+
+func (err *HTTPStatusError) Unwrap() error {
+	return ErrHTTPStatus
+}
@@ -1,101 +1,250 @@
 package driver
 
 import (
-	"encoding/csv"
+	"errors"
 	"fmt"
+	"github.com/haydenhigg/chrys/decimal"
 	"github.com/haydenhigg/chrys/frame"
-	"io"
+	"github.com/haydenhigg/chrys/market"
+	"io/fs"
+	"maps"
 	"os"
 	"path/filepath"
 	"slices"
-	"strconv"
-	"strings"
+	"sort"
+	"sync"
 	"time"
 )
 
+// the sorted frames of a data file, as of when it was last modified
+type historicalFile struct {
+	schema  CSVSchema // a copy, so that changes to the schema are noticed
+	modTime time.Time
+	size    int64
+	frames  []*frame.Frame
+}
+
+// the interval in a data file's name, which formats with %d as its minutes,
+// or as its seconds with an "s" suffix when it isn't a whole number of
+// minutes, so that sub-minute files don't collide; with %s it is like "10s",
+// "1m" or "4h"
+type fileInterval time.Duration
+
+func (interval fileInterval) Format(f fmt.State, verb rune) {
+	d := time.Duration(interval)
+
+	switch {
+	case verb == 'd' && d%time.Minute == 0:
+		fmt.Fprintf(f, "%d", d/time.Minute)
+	case verb == 'd' || d%time.Minute != 0:
+		fmt.Fprintf(f, "%ds", d/time.Second)
+	case d%time.Hour == 0:
+		fmt.Fprintf(f, "%dh", d/time.Hour)
+	default:
+		fmt.Fprintf(f, "%dm", d/time.Minute)
+	}
+}
+
 type HistoricalDriver struct {
 	DataRoot string
 	NameFmt  string // the fmt string for the CSV files with the frames
+	Schema   *CSVSchema
+
+	mu    sync.Mutex
+	files map[string]*historicalFile
+	now   func() time.Time // the simulated time, if any
 }
 
 func NewHistorical(dataRoot, nameFmt string) *HistoricalDriver {
 	return &HistoricalDriver{
 		DataRoot: dataRoot,
 		NameFmt:  nameFmt,
+		Schema:   NewCSVSchema(),
+		files:    map[string]*historicalFile{},
+	}
+}
+
+// setters
+func (d *HistoricalDriver) SetSchema(schema *CSVSchema) *HistoricalDriver {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.Schema = schema
+
+	return d
+}
+
+// only return frames that have closed by the time that now returns, so that
+// a backtest can't look ahead; nil disables this
+func (d *HistoricalDriver) SetClock(now func() time.Time) *HistoricalDriver {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.now = now
+
+	return d
+}
+
+// get the time before which every frame of interval has closed, if there is a
+// simulated time
+func (d *HistoricalDriver) horizon(interval time.Duration) (time.Time, bool) {
+	d.mu.Lock()
+	now := d.now
+	d.mu.Unlock()
+
+	if now == nil {
+		return time.Time{}, false
+	}
+
+	return now().Truncate(interval), true
+}
+
+// read the frames of a data file sorted by time, only parsing it again if it
+// or the schema has changed since it was last read
+func (d *HistoricalDriver) load(path string) ([]*frame.Frame, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if file, ok := d.files[path]; ok &&
+		file.schema == *d.Schema &&
+		file.modTime.Equal(info.ModTime()) &&
+		file.size == info.Size() {
+		return file.frames, nil
+	}
+
+	frames, err := d.Schema.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(frames, func(a, b *frame.Frame) int {
+		return a.Time.Compare(b.Time)
+	})
+
+	if d.files == nil {
+		d.files = map[string]*historicalFile{}
+	}
+
+	d.files[path] = &historicalFile{
+		schema:  *d.Schema,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		frames:  frames,
 	}
+
+	return frames, nil
 }
 
 func (d *HistoricalDriver) FetchFramesSince(
-	pair string,
+	pair market.Pair,
 	interval time.Duration,
 	since time.Time,
 ) ([]*frame.Frame, error) {
-	// split pair into assets
-	assets := strings.SplitN(pair, "/", 2)
-	base, quote := assets[0], assets[1]
+	return d.FetchFramesUntil(pair, interval, since, time.Time{})
+}
 
+// retrieve the frames from since up to and including until, unless until is
+// zero
+func (d *HistoricalDriver) FetchFramesUntil(
+	pair market.Pair,
+	interval time.Duration,
+	since time.Time,
+	until time.Time,
+) ([]*frame.Frame, error) {
 	// format data file path
-	dataFile := fmt.Sprintf(d.NameFmt, base, quote, int(interval.Minutes()))
+	dataFile := fmt.Sprintf(
+		d.NameFmt,
+		pair.Base,
+		pair.Quote,
+		fileInterval(interval),
+	)
 	dataFilePath := filepath.Join(d.DataRoot, dataFile)
 
-	// read data file
-	file, err := os.Open(dataFilePath)
-	if err != nil {
-		return nil, err
+	// frames that haven't closed by the simulated time are never returned
+	horizon, simulated := d.horizon(interval)
+	for _, t := range []time.Time{since, until} {
+		if simulated && !t.Before(horizon) {
+			return nil, fmt.Errorf(
+				"%w in %s: %v is after %v",
+				frame.ErrLookAhead,
+				dataFile,
+				t,
+				horizon,
+			)
+		}
 	}
-	defer file.Close()
 
-	reader := csv.NewReader(file)
-	frames := []*frame.Frame{}
+	// read data file, which only exists for the pairs that there's data for
+	allFrames, err := d.load(dataFilePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w %v: %w", ErrUnknownPair, pair, err)
+	} else if err != nil {
+		return nil, err
+	}
 
-	for {
-		record, err := reader.Read()
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			return frames, err
-		}
+	// find the range of frames
+	start := sort.Search(len(allFrames), func(i int) bool {
+		return !allFrames[i].Time.Before(since)
+	})
 
-		epochTime, _ := strconv.ParseInt(record[0], 10, 64)
-		t := time.Unix(epochTime, 0)
-
-		if !t.Before(since) {
-			open, _ := strconv.ParseFloat(record[1], 64)
-			high, _ := strconv.ParseFloat(record[2], 64)
-			low, _ := strconv.ParseFloat(record[3], 64)
-			close, _ := strconv.ParseFloat(record[4], 64)
-			volume, _ := strconv.ParseFloat(record[5], 64)
-
-			frames = append(frames, &frame.Frame{
-				Time:   t,
-				Open:   open,
-				High:   high,
-				Low:    low,
-				Close:  close,
-				Volume: volume,
-			})
-		}
+	end := len(allFrames)
+	if !until.IsZero() {
+		end = sort.Search(len(allFrames), func(i int) bool {
+			return allFrames[i].Time.After(until)
+		})
+	} else if simulated {
+		end = sort.Search(len(allFrames), func(i int) bool {
+			return !allFrames[i].Time.Before(horizon)
+		})
 	}
 
-	if len(frames) == 0 {
-		return frames, fmt.Errorf("no frames")
+	if start >= end {
+		return []*frame.Frame{}, fmt.Errorf("%w in %s", frame.ErrNoData, dataFile)
 	}
 
+	frames := slices.Clone(allFrames[start:end])
+
 	if !since.IsZero() && since.Add(interval).Before(frames[0].Time) {
 		return frames, fmt.Errorf("no frames before %v", frames[0].Time)
 	}
 
-	slices.SortFunc(frames, func(a, b *frame.Frame) int {
-		return a.Time.Compare(b.Time)
-	})
-
 	return frames, nil
 }
 
-func (d *HistoricalDriver) FetchBalances() (map[string]float64, error) {
-	return map[string]float64{}, nil
+// historical data from exchanges often uses their own asset names
+var historicalAssets = market.AssetMap{
+	"XBT":  "BTC",
+	"XXBT": "BTC",
+	"XDG":  "DOGE",
+	"XXDG": "DOGE",
+	"XETH": "ETH",
+	"XLTC": "LTC",
+	"XXRP": "XRP",
+	"XXLM": "XLM",
+	"ZUSD": "USD",
+	"ZEUR": "EUR",
+	"ZGBP": "GBP",
+	"ZCAD": "CAD",
+	"ZJPY": "JPY",
+}
+
+func (d *HistoricalDriver) FetchAssets() (market.AssetMap, error) {
+	return maps.Clone(historicalAssets), nil
+}
+
+func (d *HistoricalDriver) FetchBalances() (map[string]decimal.Decimal, error) {
+	return map[string]decimal.Decimal{}, nil
 }
 
-func (d *HistoricalDriver) MarketOrder(side, pair string, quantity float64) error {
+func (d *HistoricalDriver) MarketOrder(
+	side string,
+	pair market.Pair,
+	quantity decimal.Decimal,
+) error {
 	return nil
 }
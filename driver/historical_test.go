@@ -0,0 +1,375 @@
+package driver
+
+import (
+	"compress/gzip"
+	"errors"
+	"github.com/haydenhigg/chrys/frame"
+	"github.com/haydenhigg/chrys/market"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// mock
+func writeData(t *testing.T, name, data string, compress bool) string {
+	root := t.TempDir()
+
+	file, err := os.Create(filepath.Join(root, name))
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+	defer file.Close()
+
+	if compress {
+		writer := gzip.NewWriter(file)
+		writer.Write([]byte(data))
+		writer.Close()
+	} else {
+		file.WriteString(data)
+	}
+
+	return root
+}
+
+const TEST_NAME_FMT = "%s%s_%d.csv"
+
+// tests
+func Test_HistoricalFetchFramesSince(t *testing.T) {
+	// set up driver with an unsorted file in the default schema
+	root := writeData(t, "BTCUSD_1.csv", "120,3,3,3,3,1\n"+
+		"0,1,1,1,1,1\n"+
+		"60,2,2,2,2,1\n", false)
+	d := NewHistorical(root, TEST_NAME_FMT)
+
+	// FetchFramesSince()
+	frames, err := d.FetchFramesSince(
+		market.NewPair("BTC", "USD"),
+		time.Minute,
+		time.Unix(60, 0),
+	)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if len(frames) != 2 || frames[0].Close != 2 || frames[1].Close != 3 {
+		t.Errorf("frames != 2, 3: %v", frames)
+	}
+}
+
+func Test_HistoricalSchemaHeader(t *testing.T) {
+	// set up driver with named columns, a delimiter and milliseconds
+	root := writeData(t, "BTCUSD_1.csv", "Volume;Close;Low;High;Open;Timestamp\n"+
+		"5;4;3;2;1;60000\n"+
+		"10;9;8;7;6;120000\n", false)
+	d := NewHistorical(root, TEST_NAME_FMT).SetSchema(NewCSVSchema().
+		SetColumns("timestamp", "open", "high", "low", "close", "volume").
+		SetTimeFormat(TIME_MILLISECONDS).
+		SetDelimiter(';'))
+
+	// FetchFramesSince()
+	frames, err := d.FetchFramesSince(
+		market.NewPair("BTC", "USD"),
+		time.Minute,
+		time.Time{},
+	)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if len(frames) != 2 {
+		t.Fatalf("len(frames) != 2: %v", frames)
+	}
+
+	f := frames[0]
+	if !f.Time.Equal(time.Unix(60, 0)) ||
+		f.Open != 1 ||
+		f.High != 2 ||
+		f.Low != 3 ||
+		f.Close != 4 ||
+		f.Volume != 5 {
+		t.Errorf("frames[0] != 60s 1 2 3 4 5: %v", f)
+	}
+}
+
+func Test_HistoricalSchemaAutoHeaderGzip(t *testing.T) {
+	// set up driver with an unnamed header, RFC3339 times and gzip
+	root := writeData(t, "BTCUSD_60.csv.gz", "time,o,h,l,c,v\n"+
+		"2024-01-01T00:00:00Z,1,1,1,1,1\n"+
+		"2024-01-01T01:00:00Z,2,2,2,2,1\n", true)
+	d := NewHistorical(root, "%s%s_%d.csv.gz").
+		SetSchema(NewCSVSchema().SetTimeFormat(time.RFC3339))
+
+	// FetchFramesSince()
+	frames, err := d.FetchFramesSince(
+		market.NewPair("BTC", "USD"),
+		time.Hour,
+		time.Time{},
+	)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if len(frames) != 2 || !frames[0].Time.Equal(start) {
+		t.Errorf("frames != 2 from %v: %v", start, frames)
+	}
+}
+
+func Test_HistoricalSchemaStrict(t *testing.T) {
+	// set up drivers with a short record and a bad number
+	root := writeData(t, "BTCUSD_1.csv", "0,1,1,1,1,1\n"+
+		"60,2,2\n"+
+		"120,x,3,3,3,1\n"+
+		"180,4,4,4,4,1\n", false)
+	lenient := NewHistorical(root, TEST_NAME_FMT)
+	strict := NewHistorical(root, TEST_NAME_FMT).
+		SetSchema(NewCSVSchema().SetStrict(true))
+
+	// FetchFramesSince() on both
+	pair := market.NewPair("BTC", "USD")
+	frames, lenientErr := lenient.FetchFramesSince(pair, time.Minute, time.Time{})
+	_, strictErr := strict.FetchFramesSince(pair, time.Minute, time.Time{})
+
+	// assert
+	if lenientErr != nil {
+		t.Fatalf("err != nil: %v", lenientErr)
+	}
+
+	if len(frames) != 2 || frames[1].Close != 4 {
+		t.Errorf("frames != 1, 4: %v", frames)
+	}
+
+	var recordErr *RecordError
+	if !errors.As(strictErr, &recordErr) {
+		t.Fatalf("err != RecordError: %v", strictErr)
+	}
+
+	if filepath.Base(recordErr.File) != "BTCUSD_1.csv" || recordErr.Line != 2 {
+		t.Errorf("err != BTCUSD_1.csv:2: %v", recordErr)
+	}
+}
+
+func Test_HistoricalSchemaStrictFirstRecord(t *testing.T) {
+	// set up driver whose first record has a bad number
+	root := writeData(t, "BTCUSD_1.csv", "0,x,1,1,1,1\n"+
+		"60,2,2,2,2,1\n", false)
+	d := NewHistorical(root, TEST_NAME_FMT).
+		SetSchema(NewCSVSchema().SetStrict(true))
+
+	// FetchFramesSince()
+	_, err := d.FetchFramesSince(
+		market.NewPair("BTC", "USD"),
+		time.Minute,
+		time.Time{},
+	)
+
+	// assert
+	var recordErr *RecordError
+	if !errors.As(err, &recordErr) || recordErr.Line != 1 {
+		t.Errorf("err != RecordError on line 1: %v", err)
+	}
+}
+
+func Test_HistoricalSchemaMissingColumn(t *testing.T) {
+	// set up driver whose header lacks a column
+	root := writeData(t, "BTCUSD_1.csv", "time,open,high,low,close\n", false)
+	d := NewHistorical(root, TEST_NAME_FMT).SetSchema(NewCSVSchema().
+		SetColumns("time", "open", "high", "low", "close", "volume"))
+
+	// FetchFramesSince()
+	_, err := d.FetchFramesSince(
+		market.NewPair("BTC", "USD"),
+		time.Minute,
+		time.Time{},
+	)
+
+	// assert
+	var recordErr *RecordError
+	if !errors.As(err, &recordErr) || recordErr.Line != 1 {
+		t.Errorf("err != RecordError on line 1: %v", err)
+	}
+}
+
+func Test_HistoricalFetchFramesUntil(t *testing.T) {
+	// set up driver
+	root := writeData(t, "BTCUSD_1.csv", "0,1,1,1,1,1\n"+
+		"60,2,2,2,2,1\n"+
+		"120,3,3,3,3,1\n"+
+		"180,4,4,4,4,1\n", false)
+	d := NewHistorical(root, TEST_NAME_FMT)
+
+	// FetchFramesUntil() within and beyond the file
+	pair := market.NewPair("BTC", "USD")
+	frames, err := d.FetchFramesUntil(
+		pair,
+		time.Minute,
+		time.Unix(60, 0),
+		time.Unix(120, 0),
+	)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	_, noDataErr := d.FetchFramesUntil(
+		pair,
+		time.Minute,
+		time.Unix(240, 0),
+		time.Unix(300, 0),
+	)
+
+	// assert
+	if len(frames) != 2 || frames[0].Close != 2 || frames[1].Close != 3 {
+		t.Errorf("frames != 2, 3: %v", frames)
+	}
+
+	if !errors.Is(noDataErr, frame.ErrNoData) {
+		t.Errorf("err != ErrNoData: %v", noDataErr)
+	}
+}
+
+func Test_HistoricalCachesFiles(t *testing.T) {
+	// set up driver
+	root := writeData(t, "BTCUSD_1.csv", "0,1,1,1,1,1\n", false)
+	path := filepath.Join(root, "BTCUSD_1.csv")
+	d := NewHistorical(root, TEST_NAME_FMT)
+
+	pair := market.NewPair("BTC", "USD")
+	fetchClose := func() float64 {
+		frames, err := d.FetchFramesSince(pair, time.Minute, time.Time{})
+		if err != nil {
+			t.Fatalf("err != nil: %v", err)
+		}
+
+		return frames[0].Close
+	}
+
+	// FetchFramesSince() after rewriting the file without and then with a
+	// new modification time
+	fetchClose()
+
+	info, _ := os.Stat(path)
+	os.WriteFile(path, []byte("0,2,2,2,2,1\n"), 0644)
+	os.Chtimes(path, info.ModTime(), info.ModTime())
+	cached := fetchClose()
+
+	modTime := info.ModTime().Add(time.Hour)
+	os.Chtimes(path, modTime, modTime)
+	reloaded := fetchClose()
+
+	// assert
+	if cached != 1 {
+		t.Errorf("cached != 1: %f", cached)
+	}
+
+	if reloaded != 2 {
+		t.Errorf("reloaded != 2: %f", reloaded)
+	}
+}
+
+func Test_HistoricalSchemaChangedInPlace(t *testing.T) {
+	// set up driver
+	root := writeData(t, "BTCUSD_1.csv", "0,1,1,1,2,1\n", false)
+	d := NewHistorical(root, TEST_NAME_FMT)
+
+	pair := market.NewPair("BTC", "USD")
+	fetchClose := func() float64 {
+		frames, err := d.FetchFramesSince(pair, time.Minute, time.Time{})
+		if err != nil {
+			t.Fatalf("err != nil: %v", err)
+		}
+
+		return frames[0].Close
+	}
+
+	// FetchFramesSince() before and after changing the schema's columns
+	before := fetchClose()
+	d.Schema.SetColumns("0", "1", "2", "3", "1", "5")
+	after := fetchClose()
+
+	// assert
+	if before != 2 || after != 1 {
+		t.Errorf("closes != 2, 1: %f, %f", before, after)
+	}
+}
+
+func Test_HistoricalSetClock(t *testing.T) {
+	// set up driver whose simulated time is partway through the file
+	root := writeData(t, "BTCUSD_1.csv", "0,1,1,1,1,1\n"+
+		"60,2,2,2,2,1\n"+
+		"120,3,3,3,3,1\n"+
+		"180,4,4,4,4,1\n", false)
+	d := NewHistorical(root, TEST_NAME_FMT).SetClock(func() time.Time {
+		return time.Unix(150, 0)
+	})
+
+	// FetchFramesSince() before and after the simulated time
+	pair := market.NewPair("BTC", "USD")
+	frames, err := d.FetchFramesSince(pair, time.Minute, time.Time{})
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	_, sinceErr := d.FetchFramesSince(pair, time.Minute, time.Unix(120, 0))
+	_, untilErr := d.FetchFramesUntil(
+		pair,
+		time.Minute,
+		time.Time{},
+		time.Unix(180, 0),
+	)
+
+	// assert
+	if len(frames) != 2 || frames[1].Close != 2 {
+		t.Errorf("frames != 1, 2: %v", frames)
+	}
+
+	if !errors.Is(sinceErr, frame.ErrLookAhead) {
+		t.Errorf("err != ErrLookAhead: %v", sinceErr)
+	}
+
+	if !errors.Is(untilErr, frame.ErrLookAhead) {
+		t.Errorf("err != ErrLookAhead: %v", untilErr)
+	}
+}
+
+func Test_HistoricalSubMinuteFiles(t *testing.T) {
+	// set up driver with 10s, 10m and 4h files
+	root := writeData(t, "BTCUSD_10s.csv", "0,1,1,1,1,1\n", false)
+	os.WriteFile(
+		filepath.Join(root, "BTCUSD_10.csv"),
+		[]byte("0,2,2,2,2,1\n"),
+		0644,
+	)
+	os.WriteFile(
+		filepath.Join(root, "BTCUSD_4h.csv"),
+		[]byte("0,3,3,3,3,1\n"),
+		0644,
+	)
+	d := NewHistorical(root, TEST_NAME_FMT)
+	named := NewHistorical(root, "%s%s_%s.csv")
+
+	// FetchFramesSince() for each interval
+	pair := market.NewPair("BTC", "USD")
+	seconds, err := d.FetchFramesSince(pair, 10*time.Second, time.Time{})
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	minutes, err := d.FetchFramesSince(pair, 10*time.Minute, time.Time{})
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	hours, err := named.FetchFramesSince(pair, 4*time.Hour, time.Time{})
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if seconds[0].Close != 1 || minutes[0].Close != 2 || hours[0].Close != 3 {
+		t.Errorf("closes != 1, 2, 3: %v, %v, %v", seconds, minutes, hours)
+	}
+}
@@ -7,23 +7,69 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"github.com/haydenhigg/chrys/decimal"
 	"github.com/haydenhigg/chrys/frame"
+	"github.com/haydenhigg/chrys/market"
 	"io"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 )
 
 const (
-	KRAKEN_HOST         = "api.kraken.com"
+	KRAKEN_URL          = "https://api.kraken.com"
 	KRAKEN_CONTENT_TYPE = "application/x-www-form-urlencoded; charset=utf-8"
+
+	// each page of trades is a public call, and those are paced to about one
+	// per second
+	KRAKEN_MAX_TRADE_PAGES = 10
 )
 
+// Kraken's names for assets whose common names it doesn't use
+var krakenAssets = map[string]string{
+	"BTC":  "XBT",
+	"DOGE": "XDG",
+}
+
+// get the common name of an asset that Kraken names differently, like XBT
+func krakenCanonical(name string) string {
+	for canonical, krakenName := range krakenAssets {
+		if name == krakenName {
+			return canonical
+		}
+	}
+
+	return name
+}
+
 type KrakenDriver struct {
 	Key    []byte
 	Secret []byte
+
+	// where requests are sent, so that they can go to a proxy or stand-in
+	// server; defaults to KRAKEN_URL
+	BaseURL    string
+	HTTPClient *http.Client
+	Nonce      func() int64  // must increase with every private request
+	OTP        func() string // the 2FA password of the key, if it has one
+
+	// the most pages of trades to retrieve when backfilling frames that are
+	// older than OHLC returns, past which frames are left out; defaults to
+	// KRAKEN_MAX_TRADE_PAGES, and 0 is unlimited
+	MaxTradePages int
+
+	// how many times to retry a failed request, waiting RetryDelay before the
+	// first retry and twice as long before each one after
+	MaxRetries int
+	RetryDelay time.Duration
+
+	counter       *callCounter // private calls
+	publicCounter *callCounter
+	sleep         func(time.Duration)
 }
 
 func NewKraken(key, secret string) (*KrakenDriver, error) {
@@ -33,26 +79,47 @@ func NewKraken(key, secret string) (*KrakenDriver, error) {
 	}
 
 	d := &KrakenDriver{
-		Key:    []byte(key),
-		Secret: decodedSecret,
+		Key:           []byte(key),
+		Secret:        decodedSecret,
+		BaseURL:       KRAKEN_URL,
+		HTTPClient:    &http.Client{Timeout: 5 * time.Second},
+		Nonce:         NewNonceSource().Next,
+		MaxTradePages: KRAKEN_MAX_TRADE_PAGES,
+		MaxRetries:    3,
+		RetryDelay:    500 * time.Millisecond,
+		counter:       newCallCounter(KRAKEN_STARTER),
+		publicCounter: newPublicCallCounter(),
+		sleep:         time.Sleep,
 	}
 
 	return d, nil
 }
 
+// setters
+func (d *KrakenDriver) SetTier(tier KrakenTier) *KrakenDriver {
+	d.counter = newCallCounter(tier)
+	return d
+}
+
+func (d *KrakenDriver) SetNonceSource(source *NonceSource) *KrakenDriver {
+	d.Nonce = source.Next
+	return d
+}
+
+func (d *KrakenDriver) SetOTP(otp func() string) *KrakenDriver {
+	d.OTP = otp
+	return d
+}
+
 // request helpers
 func (d *KrakenDriver) buildURL(path string, query url.Values) string {
-	u := url.URL{
-		Scheme: "https",
-		Host:   KRAKEN_HOST,
-		Path:   path,
-	}
+	u := strings.TrimSuffix(d.BaseURL, "/") + path
 
-	if query != nil {
-		u.RawQuery = query.Encode()
+	if len(query) > 0 {
+		u += "?" + query.Encode()
 	}
 
-	return u.String()
+	return u
 }
 
 func (d *KrakenDriver) buildSignature(path string, body url.Values) string {
@@ -67,29 +134,120 @@ func (d *KrakenDriver) buildSignature(path string, body url.Values) string {
 	return base64.StdEncoding.EncodeToString(h.Sum(nil))
 }
 
+// get Kraken's symbol for a pair, like XBTUSD for BTC/USD, unless the pair
+// has its own
+func (d *KrakenDriver) Symbol(pair market.Pair) string {
+	if pair.Symbol != "" {
+		return pair.Symbol
+	}
+
+	base, quote := pair.Base, pair.Quote
+	if asset, ok := krakenAssets[base]; ok {
+		base = asset
+	}
+
+	if asset, ok := krakenAssets[quote]; ok {
+		quote = asset
+	}
+
+	return base + quote
+}
+
 // basic requests
 type Payload struct {
 	Query url.Values
 	Body  url.Values
 }
 
-func doRequest(request *http.Request) ([]byte, error) {
-	client := &http.Client{Timeout: 5 * time.Second}
+// private endpoints that are safe to retry after any failure
+var krakenIdempotentPaths = map[string]bool{
+	"/Balance":            true,
+	"/BalanceEx":          true,
+	"/TradeBalance":       true,
+	"/OpenOrders":         true,
+	"/ClosedOrders":       true,
+	"/QueryOrders":        true,
+	"/TradesHistory":      true,
+	"/QueryTrades":        true,
+	"/Ledgers":            true,
+	"/QueryLedgers":       true,
+	"/GetWebSocketsToken": true,
+}
 
-	response, err := client.Do(request)
+// send a request and unmarshal its result into result, if it isn't nil
+func (d *KrakenDriver) send(request *http.Request, result any) error {
+	response, err := d.HTTPClient.Do(request)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer response.Body.Close()
 
-	return io.ReadAll(response.Body)
+	rawResponse, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	ok := response.StatusCode >= 200 && response.StatusCode < 300
+	statusErr := &HTTPStatusError{response.StatusCode, response.Status}
+
+	// unmarshal raw response
+	var envelope struct {
+		Errors []string        `json:"error"`
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(rawResponse, &envelope); err != nil {
+		if !ok {
+			return statusErr
+		}
+
+		return fmt.Errorf("%w: %v", ErrMalformedResponse, err)
+	}
+
+	if err := parseKrakenErrors(envelope.Errors); err != nil {
+		return err
+	} else if !ok {
+		return statusErr
+	}
+
+	if result != nil {
+		if err := json.Unmarshal(envelope.Result, result); err != nil {
+			return fmt.Errorf("%w: %v", ErrMalformedResponse, err)
+		}
+	}
+
+	return nil
+}
+
+// send requests made by build until one succeeds; counter is the call counter
+// that build waits on
+func (d *KrakenDriver) do(
+	counter *callCounter,
+	build func() (*http.Request, error),
+	idempotent bool,
+	result any,
+) error {
+	return retry(
+		retryPolicy{d.MaxRetries, d.RetryDelay, d.sleep},
+		build,
+		func(request *http.Request) error { return d.send(request, result) },
+		idempotent,
+		func(err error) error {
+			// Kraken's call counter is fuller than ours
+			if errors.Is(err, ErrRateLimited) {
+				counter.fill()
+			}
+
+			return nil
+		},
+	)
 }
 
 func (d *KrakenDriver) public(
 	method,
 	path string,
 	payload *Payload,
-) ([]byte, error) {
+	result any,
+) error {
 	if payload == nil {
 		payload = new(Payload)
 	}
@@ -98,27 +256,32 @@ func (d *KrakenDriver) public(
 	fullPath := "/0/public" + path
 	u := d.buildURL(fullPath, payload.Query)
 
-	var bodyReader io.Reader
-	if payload.Body != nil {
-		bodyReader = strings.NewReader(payload.Body.Encode())
-	}
+	return d.do(d.publicCounter, func() (*http.Request, error) {
+		d.publicCounter.wait(1)
 
-	// create the *http.Request
-	request, err := http.NewRequest(method, u, bodyReader)
-	if err != nil {
-		return nil, err
-	}
+		var bodyReader io.Reader
+		if payload.Body != nil {
+			bodyReader = strings.NewReader(payload.Body.Encode())
+		}
+
+		// create the *http.Request
+		request, err := http.NewRequest(method, u, bodyReader)
+		if err != nil {
+			return nil, err
+		}
 
-	request.Header.Add("Content-Type", KRAKEN_CONTENT_TYPE)
+		request.Header.Add("Content-Type", KRAKEN_CONTENT_TYPE)
 
-	return doRequest(request)
+		return request, nil
+	}, true, result)
 }
 
 func (d *KrakenDriver) private(
 	method,
 	path string,
 	payload *Payload,
-) ([]byte, error) {
+	result any,
+) error {
 	if payload == nil {
 		payload = new(Payload)
 	}
@@ -131,113 +294,356 @@ func (d *KrakenDriver) private(
 		payload.Body = url.Values{}
 	}
 
-	payload.Body.Set("nonce", strconv.FormatInt(time.Now().UnixMilli(), 10))
-	bodyReader := strings.NewReader(payload.Body.Encode())
-
-	// create the *http.Request
-	request, err := http.NewRequest(method, u, bodyReader)
-	if err != nil {
-		return nil, err
+	cost, ok := krakenCallCosts[path]
+	if !ok {
+		cost = 1
 	}
 
-	request.Header.Add("Content-Type", KRAKEN_CONTENT_TYPE)
+	return d.do(d.counter, func() (*http.Request, error) {
+		d.counter.wait(cost)
 
-	// these are added directly to request.Header to sidestep canonicalization
-	request.Header["API-Key"] = []string{string(d.Key)}
-	request.Header["API-Sign"] = []string{d.buildSignature(
-		fullPath,
-		payload.Body,
-	)}
+		// every attempt needs a new nonce
+		payload.Body.Set("nonce", strconv.FormatInt(d.Nonce(), 10))
+		if d.OTP != nil {
+			payload.Body.Set("otp", d.OTP())
+		}
+		bodyReader := strings.NewReader(payload.Body.Encode())
+
+		// create the *http.Request
+		request, err := http.NewRequest(method, u, bodyReader)
+		if err != nil {
+			return nil, err
+		}
+
+		request.Header.Add("Content-Type", KRAKEN_CONTENT_TYPE)
 
-	return doRequest(request)
+		// these are added directly to request.Header to sidestep
+		// canonicalization
+		request.Header["API-Key"] = []string{string(d.Key)}
+		request.Header["API-Sign"] = []string{d.buildSignature(
+			fullPath,
+			payload.Body,
+		)}
+
+		return request, nil
+	}, krakenIdempotentPaths[path], result)
 }
 
-// driver functions
-func (d *KrakenDriver) FetchFramesSince(
-	pair string,
+// retrieve frames since a time, the last of which is still forming
+func (d *KrakenDriver) fetchOHLC(
+	pair market.Pair,
 	interval time.Duration,
 	since time.Time,
 ) ([]*frame.Frame, error) {
+	// Kraken's finest OHLC interval is 1m
+	if interval < time.Minute {
+		return nil, errors.New("interval must be at least 1m")
+	}
+
+	// trades are paged by nanosecond timestamps, which only span 1678 to 2262;
+	// a zero since only wants the latest frames, so nothing is backfilled
+	if !since.IsZero() && !time.Unix(0, since.UnixNano()).Equal(since) {
+		return nil, fmt.Errorf("%w: since %v", ErrInvalidArguments, since)
+	}
+
 	// make request
-	rawResponse, err := d.public("GET", "/OHLC", &Payload{
+	var result map[string]json.RawMessage
+	err := d.public("GET", "/OHLC", &Payload{
 		Query: url.Values{
-			"pair":     {pair},
+			"pair":     {d.Symbol(pair)},
 			"interval": {strconv.Itoa(int(interval.Minutes()))},
 			"since":    {strconv.FormatInt(since.Unix()-1, 10)},
 		},
-	})
+	}, &result)
 	if err != nil {
 		return nil, err
 	}
 
-	// unmarshal raw response
-	var response struct {
-		Errors []string           `json:"error"`
-		Result map[string][][]any `json:"result"`
-	}
-	json.Unmarshal(rawResponse, &response)
-
-	if len(response.Errors) > 0 {
-		return nil, errors.New(response.Errors[0])
+	// the result is keyed by Kraken's own name for the pair, which may differ
+	// from the symbol that was requested, alongside "last"
+	var rawFrames [][]any
+	for key, rawResult := range result {
+		if key != "last" {
+			if err := json.Unmarshal(rawResult, &rawFrames); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrMalformedResponse, err)
+			}
+			break
+		}
 	}
 
-	rawFrames, ok := response.Result[pair]
-	if !ok {
-		return nil, errors.New("no frames retrieved for pair")
+	if len(rawFrames) == 0 {
+		return nil, fmt.Errorf("%w for %v", frame.ErrNoData, pair)
 	}
 
 	// process returned frames
 	frames := []*frame.Frame{}
 
-	for _, rawFrame := range rawFrames[:len(rawFrames)-1] {
-		open, _ := strconv.ParseFloat(rawFrame[1].(string), 64)
-		high, _ := strconv.ParseFloat(rawFrame[2].(string), 64)
-		low, _ := strconv.ParseFloat(rawFrame[3].(string), 64)
-		close, _ := strconv.ParseFloat(rawFrame[4].(string), 64)
-		volume, _ := strconv.ParseFloat(rawFrame[6].(string), 64)
+	for _, rawFrame := range rawFrames {
+		values, err := parseRow(rawFrame, 0, 1, 2, 3, 4, 6)
+		if err != nil {
+			return nil, err
+		}
 
 		frames = append(frames, &frame.Frame{
-			Time:   time.Unix(int64(rawFrame[0].(float64)), 0),
-			Open:   open,
-			High:   high,
-			Low:    low,
-			Close:  close,
-			Volume: volume,
+			Time:   time.Unix(int64(values[0]), 0),
+			Open:   values[1],
+			High:   values[2],
+			Low:    values[3],
+			Close:  values[4],
+			Volume: values[5],
 		})
 	}
 
+	// OHLC only returns the latest 720 frames, so build any older ones from
+	// trades
+	start := since.Truncate(interval)
+	if start.Before(since) {
+		start = start.Add(interval)
+	}
+
+	if !since.IsZero() && frames[0].Time.After(start) {
+		// when MaxTradePages runs out, the frames that were built are kept
+		// despite the gap after them, and the store decides whether there are
+		// enough
+		backfill, err := d.backfill(pair, interval, start, frames[0].Time)
+		if err != nil && !errors.Is(err, errTradePageLimit) {
+			return nil, err
+		}
+
+		frames = append(backfill, frames...)
+	}
+
 	return frames, nil
 }
 
-func (d *KrakenDriver) FetchBalances() (map[string]float64, error) {
+// retrieve a page of trades since a cursor, along with the cursor to continue
+// from
+func (d *KrakenDriver) fetchTrades(
+	pair market.Pair,
+	cursor string,
+) ([]frame.Trade, string, error) {
 	// make request
-	rawResponse, err := d.private("POST", "/Balance", nil)
+	var result map[string]json.RawMessage
+	err := d.public("GET", "/Trades", &Payload{
+		Query: url.Values{
+			"pair":  {d.Symbol(pair)},
+			"since": {cursor},
+			"count": {"1000"},
+		},
+	}, &result)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// the result is keyed by Kraken's own name for the pair, alongside the
+	// cursor in "last"
+	var (
+		rawTrades [][]any
+		last      string
+	)
+	for key, rawResult := range result {
+		if key == "last" {
+			err = json.Unmarshal(rawResult, &last)
+		} else {
+			err = json.Unmarshal(rawResult, &rawTrades)
+		}
+
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %v", ErrMalformedResponse, err)
+		}
+	}
+
+	// process returned trades
+	trades := make([]frame.Trade, 0, len(rawTrades))
+
+	for _, rawTrade := range rawTrades {
+		values, err := parseRow(rawTrade, 0, 1, 2)
+		if err != nil {
+			return nil, "", err
+		}
+
+		trades = append(trades, frame.Trade{
+			Time:   time.Unix(0, int64(values[2]*float64(time.Second))),
+			Price:  values[0],
+			Volume: values[1],
+		})
+	}
+
+	return trades, last, nil
+}
+
+// returned by backfill when MaxTradePages runs out before every trade was
+// retrieved
+var errTradePageLimit = errors.New("too many pages of trades")
+
+// build the frames in [start, end) from trades; if MaxTradePages runs out
+// first, the frames built from the trades before then are returned along with
+// errTradePageLimit
+func (d *KrakenDriver) backfill(
+	pair market.Pair,
+	interval time.Duration,
+	start, end time.Time,
+) ([]*frame.Frame, error) {
+	trades := []frame.Trade{}
+	cursor := strconv.FormatInt(start.UnixNano(), 10)
+
+	for pages := 0; d.MaxTradePages <= 0 || pages < d.MaxTradePages; pages++ {
+		page, last, err := d.fetchTrades(pair, cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, trade := range page {
+			if !trade.Time.Before(end) {
+				return frame.FromTrades(trades, interval), nil
+			} else if !trade.Time.Before(start) {
+				trades = append(trades, trade)
+			}
+		}
+
+		// there are no more trades
+		if len(page) == 0 || last == "" || last == cursor {
+			return frame.FromTrades(trades, interval), nil
+		}
+
+		cursor = last
+	}
+
+	// the last frame may be missing the trades after the last page
+	frames := frame.FromTrades(trades, interval)
+	return frames[:max(len(frames)-1, 0)], errTradePageLimit
+}
+
+// driver functions
+func (d *KrakenDriver) FetchFramesSince(
+	pair market.Pair,
+	interval time.Duration,
+	since time.Time,
+) ([]*frame.Frame, error) {
+	frames, err := d.fetchOHLC(pair, interval, since)
 	if err != nil {
 		return nil, err
 	}
 
-	// unmarshal raw response
-	var response struct {
-		Errors []string          `json:"error"`
-		Result map[string]string `json:"result"`
+	// the last frame is incomplete
+	return frames[:len(frames)-1], nil
+}
+
+func (d *KrakenDriver) FetchFormingFramesSince(
+	pair market.Pair,
+	interval time.Duration,
+	since time.Time,
+) ([]*frame.Frame, *frame.Frame, error) {
+	frames, err := d.fetchOHLC(pair, interval, since)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return frames[:len(frames)-1], frames[len(frames)-1], nil
+}
+
+// map Kraken's asset names and their altnames, like XXBT and XBT, to
+// canonical names
+func (d *KrakenDriver) FetchAssets() (market.AssetMap, error) {
+	// make request
+	var result map[string]struct {
+		Altname string `json:"altname"`
+	}
+	if err := d.public("GET", "/Assets", nil, &result); err != nil {
+		return nil, err
 	}
-	json.Unmarshal(rawResponse, &response)
 
-	if len(response.Errors) > 0 {
-		return nil, errors.New(response.Errors[0])
+	// the altnames of fiat assets keep their legacy prefix, like ZUSD, but the
+	// wsnames of the pairs that trade them don't
+	var pairs map[string]struct {
+		Base   string `json:"base"`
+		Quote  string `json:"quote"`
+		Wsname string `json:"wsname"`
+	}
+	if err := d.public("GET", "/AssetPairs", nil, &pairs); err != nil {
+		return nil, err
+	}
+
+	wsnames := map[string]string{}
+	for _, info := range pairs {
+		if base, quote, ok := strings.Cut(info.Wsname, "/"); ok {
+			wsnames[info.Base] = base
+			wsnames[info.Quote] = quote
+		}
+	}
+
+	assets := market.AssetMap{}
+	for name, info := range result {
+		canonical := info.Altname
+		if wsname, ok := wsnames[name]; ok {
+			canonical = wsname
+		} else if canonical == "" {
+			canonical = name
+		}
+
+		canonical = krakenCanonical(canonical)
+		assets[name] = canonical
+		assets[info.Altname] = canonical
+	}
+
+	delete(assets, "")
+
+	return assets, nil
+}
+
+// list the pairs that can be traded under canonical asset names, with
+// Kraken's symbols for them
+func (d *KrakenDriver) FetchPairs() ([]market.Pair, error) {
+	// make request
+	var result map[string]struct {
+		Altname string `json:"altname"`
+		Wsname  string `json:"wsname"`
+		Status  string `json:"status"`
+	}
+	if err := d.public("GET", "/AssetPairs", nil, &result); err != nil {
+		return nil, err
+	}
+
+	pairs := make([]market.Pair, 0, len(result))
+	for _, info := range result {
+		base, quote, ok := strings.Cut(info.Wsname, "/")
+		if !ok || info.Status != "" && info.Status != "online" {
+			continue
+		}
+
+		pair := market.NewPair(krakenCanonical(base), krakenCanonical(quote))
+		pairs = append(pairs, pair.WithSymbol(info.Altname))
+	}
+
+	slices.SortFunc(pairs, func(a, b market.Pair) int {
+		return strings.Compare(a.String(), b.String())
+	})
+
+	return pairs, nil
+}
+
+func (d *KrakenDriver) FetchBalances() (map[string]decimal.Decimal, error) {
+	// make request
+	var result map[string]string
+	if err := d.private("POST", "/Balance", nil, &result); err != nil {
+		return nil, err
 	}
 
 	// process returned quantities
-	store := map[string]float64{}
+	store := map[string]decimal.Decimal{}
 
-	for d, v := range response.Result {
-		balance, err := strconv.ParseFloat(v, 64)
-		if err != nil {
+	for d, v := range result {
+		// leave out balances past Decimal's range rather than failing the
+		// others
+		balance, err := decimal.Parse(v)
+		if errors.Is(err, decimal.ErrOverflow) {
+			continue
+		} else if err != nil {
 			return nil, err
 		}
 
 		// don't include balances of 0
-		if balance == 0 {
+		if balance.IsZero() {
 			continue
 		}
 
@@ -247,30 +653,34 @@ func (d *KrakenDriver) FetchBalances() (map[string]float64, error) {
 	return store, nil
 }
 
-func (d *KrakenDriver) MarketOrder(side, pair string, quantity float64) error {
+// retrieve a token for subscribing to private WebSocket channels
+func (d *KrakenDriver) FetchWebSocketsToken() (string, error) {
 	// make request
-	rawResponse, err := d.private("POST", "/AddOrder", &Payload{
-		Body: url.Values{
-			"ordertype": {"market"},
-			"type":      {side},
-			"volume":    {strconv.FormatFloat(quantity, 'f', 8, 64)},
-			"pair":      {pair},
-		},
-	})
-	if err != nil {
-		return err
+	var result struct {
+		Token string `json:"token"`
 	}
-
-	// unmarshal raw response
-	var response struct {
-		Errors []string       `json:"error"`
-		Result map[string]any `json:"result"`
+	err := d.private("POST", "/GetWebSocketsToken", nil, &result)
+	if err != nil {
+		return "", err
+	} else if result.Token == "" {
+		return "", fmt.Errorf("%w: no token", ErrMalformedResponse)
 	}
-	json.Unmarshal(rawResponse, &response)
 
-	if len(response.Errors) > 0 {
-		return errors.New(response.Errors[0])
-	}
+	return result.Token, nil
+}
 
-	return nil
+func (d *KrakenDriver) MarketOrder(
+	side string,
+	pair market.Pair,
+	quantity decimal.Decimal,
+) error {
+	// make request
+	return d.private("POST", "/AddOrder", &Payload{
+		Body: url.Values{
+			"ordertype": {"market"},
+			"type":      {side},
+			"volume":    {quantity.String()},
+			"pair":      {d.Symbol(pair)},
+		},
+	}, nil)
 }
@@ -0,0 +1,69 @@
+package driver
+
+import "strings"
+
+// Kraken error code prefixes and the errors they are
+var krakenErrors = []struct {
+	prefix string
+	err    error
+}{
+	{"EAPI:Rate limit exceeded", ErrRateLimited},
+	{"EOrder:Rate limit exceeded", ErrRateLimited},
+	{"EGeneral:Too many requests", ErrRateLimited},
+	{"EGeneral:Temporary lockout", ErrTemporaryLockout},
+	{"EAPI:Invalid nonce", ErrInvalidNonce},
+	{"EAPI:Invalid key", ErrInvalidKey},
+	{"EAPI:Invalid signature", ErrInvalidKey},
+	{"EAPI:Bad request", ErrInvalidArguments},
+	{"EGeneral:Permission denied", ErrPermissionDenied},
+	{"EGeneral:Invalid arguments", ErrInvalidArguments},
+	{"EQuery:Unknown asset pair", ErrUnknownPair},
+	{"EOrder:Insufficient funds", ErrInsufficientFunds},
+	{"EOrder:Order minimum not met", ErrOrderMinimum},
+	{"EOrder:Cost minimum not met", ErrOrderMinimum},
+	{"EService:Unavailable", ErrUnavailable},
+	{"EService:Busy", ErrUnavailable},
+	{"EService:Deadline elapsed", ErrUnavailable},
+	{"EGeneral:Internal error", ErrUnavailable},
+}
+
+// an error returned by Kraken, like "EAPI:Invalid nonce"
+type KrakenError struct {
+	Code string
+}
+
+func (err *KrakenError) Error() string {
+	return "kraken: " + err.Code
+}
+
+// match the error to one of the sentinel errors, if any
+func (err *KrakenError) Unwrap() error {
+	for _, known := range krakenErrors {
+		if strings.HasPrefix(err.Code, known.prefix) {
+			return known.err
+		}
+	}
+
+	return nil
+}
+
+func (err *KrakenError) exchange() string {
+	return "kraken"
+}
+
+// the category of the error, like "EAPI"
+func (err *KrakenError) Category() string {
+	category, _, _ := strings.Cut(err.Code, ":")
+	return category
+}
+
+// get the first error among Kraken's messages, ignoring warnings
+func parseKrakenErrors(messages []string) error {
+	for _, message := range messages {
+		if strings.HasPrefix(message, "E") {
+			return &KrakenError{message}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,211 @@
+package driver
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a Kraken driver with a server that answers with responses in order,
+// repeating the last one
+func newScriptedKraken(
+	t *testing.T,
+	responses ...string,
+) (*KrakenDriver, *int, func() (*http.Request, error)) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			response := responses[min(calls, len(responses)-1)]
+			calls++
+
+			// a response can start with its status, like "503 ..."
+			prefix, body, _ := strings.Cut(response, " ")
+			if status, err := strconv.Atoi(prefix); err == nil {
+				w.WriteHeader(status)
+				response = body
+			}
+
+			w.Write([]byte(response))
+		},
+	))
+	t.Cleanup(server.Close)
+
+	d, _ := NewKraken("key", "c2VjcmV0")
+	d.HTTPClient = server.Client()
+	d.sleep = func(time.Duration) {}
+
+	build := func() (*http.Request, error) {
+		return http.NewRequest("GET", server.URL, nil)
+	}
+
+	return d, &calls, build
+}
+
+func Test_KrakenErrorUnwrap(t *testing.T) {
+	// parseKrakenErrors()
+	err := parseKrakenErrors([]string{
+		"WGeneral:Unknown warning",
+		"EGeneral:Invalid arguments:volume",
+	})
+
+	// assert
+	if !errors.Is(err, ErrInvalidArguments) {
+		t.Errorf("err != ErrInvalidArguments: %v", err)
+	}
+
+	var krakenErr *KrakenError
+	if !errors.As(err, &krakenErr) || krakenErr.Category() != "EGeneral" {
+		t.Errorf("err is not an EGeneral *KrakenError: %v", err)
+	}
+
+	if parseKrakenErrors([]string{"WGeneral:Unknown warning"}) != nil {
+		t.Error("a warning is an error")
+	}
+}
+
+func Test_KrakenRetryRateLimited(t *testing.T) {
+	// set up driver
+	d, calls, build := newScriptedKraken(t,
+		`{"error":["EAPI:Rate limit exceeded"]}`,
+		`{"error":[],"result":{"ok":true}}`,
+	)
+
+	// do() something that isn't idempotent
+	var result struct{ Ok bool }
+	err := d.do(d.counter, build, false, &result)
+
+	// assert
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	if *calls != 2 {
+		t.Errorf("calls != 2: %d", *calls)
+	}
+
+	if !result.Ok {
+		t.Error("result was not unmarshaled")
+	}
+}
+
+func Test_KrakenRetryUnavailable(t *testing.T) {
+	// set up drivers
+	idempotent, idempotentCalls, idempotentBuild := newScriptedKraken(t,
+		`503 <html>unavailable</html>`,
+		`{"error":["EService:Unavailable"]}`,
+		`{"error":[],"result":{}}`,
+	)
+
+	mutation, mutationCalls, mutationBuild := newScriptedKraken(t,
+		`503 <html>unavailable</html>`,
+	)
+
+	// do()
+	idempotentErr := idempotent.do(idempotent.counter, idempotentBuild, true, nil)
+	mutationErr := mutation.do(mutation.counter, mutationBuild, false, nil)
+
+	// assert
+	if idempotentErr != nil || *idempotentCalls != 3 {
+		t.Errorf("idempotent request failed after %d calls: %v",
+			*idempotentCalls, idempotentErr)
+	}
+
+	var statusErr *HTTPStatusError
+	if !errors.As(mutationErr, &statusErr) || statusErr.StatusCode != 503 {
+		t.Errorf("err != 503: %v", mutationErr)
+	}
+
+	if *mutationCalls != 1 {
+		t.Errorf("mutation calls != 1: %d", *mutationCalls)
+	}
+}
+
+func Test_KrakenRetryGivesUp(t *testing.T) {
+	// set up driver
+	d, calls, build := newScriptedKraken(t,
+		`{"error":["EAPI:Rate limit exceeded"]}`,
+	)
+
+	// do()
+	err := d.do(d.counter, build, true, nil)
+
+	// assert
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("err != ErrRateLimited: %v", err)
+	}
+
+	if *calls != d.MaxRetries+1 {
+		t.Errorf("calls != %d: %d", d.MaxRetries+1, *calls)
+	}
+}
+
+func Test_KrakenLockoutNotRetried(t *testing.T) {
+	// set up driver
+	d, calls, build := newScriptedKraken(t,
+		`{"error":["EGeneral:Temporary lockout"]}`,
+	)
+
+	// do()
+	err := d.do(d.counter, build, true, nil)
+
+	// assert
+	if !errors.Is(err, ErrTemporaryLockout) {
+		t.Errorf("err != ErrTemporaryLockout: %v", err)
+	}
+
+	if *calls != 1 {
+		t.Errorf("calls != 1: %d", *calls)
+	}
+}
+
+func Test_KrakenRateLimitFillsCounter(t *testing.T) {
+	// set up driver
+	d, _, build := newScriptedKraken(t,
+		`{"error":["EAPI:Rate limit exceeded"]}`,
+		`{"error":[],"result":{}}`,
+	)
+
+	// do() a public request
+	err := d.do(d.publicCounter, build, true, nil)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if d.counter.count != 0 {
+		t.Errorf("private counter != 0: %v", d.counter.count)
+	}
+
+	if d.publicCounter.count < d.publicCounter.max {
+		t.Errorf("public counter was not filled: %v", d.publicCounter.count)
+	}
+}
+
+func Test_KrakenMalformedResponse(t *testing.T) {
+	// set up driver
+	d, calls, build := newScriptedKraken(t,
+		`{"error":[],"result":`,
+		`{"error":["EQuery:Unknown asset pair"]}`,
+	)
+
+	// do() twice
+	malformedErr := d.do(d.counter, build, true, nil)
+	krakenErr := d.do(d.counter, build, true, nil)
+
+	// assert
+	if !errors.Is(malformedErr, ErrMalformedResponse) {
+		t.Errorf("err != ErrMalformedResponse: %v", malformedErr)
+	}
+
+	if !errors.Is(krakenErr, ErrUnknownPair) {
+		t.Errorf("err != ErrUnknownPair: %v", krakenErr)
+	}
+
+	if *calls != 2 {
+		t.Errorf("calls != 2: %d", *calls)
+	}
+}
@@ -0,0 +1,599 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/haydenhigg/chrys/decimal"
+	"github.com/haydenhigg/chrys/frame"
+	"github.com/haydenhigg/chrys/market"
+	"github.com/haydenhigg/chrys/store"
+	"sync"
+	"time"
+)
+
+const (
+	KRAKEN_WS_URL      = "wss://ws.kraken.com/v2"
+	KRAKEN_WS_AUTH_URL = "wss://ws-auth.kraken.com/v2"
+)
+
+// a fill of one of the account's orders
+type Execution struct {
+	OrderID  string
+	ExecType string
+	Pair     market.Pair
+	Side     string
+	Quantity decimal.Decimal
+	Price    float64
+	Time     time.Time
+}
+
+type ohlcSubscription struct {
+	pair     market.Pair
+	interval time.Duration
+}
+
+// a KrakenStream keeps a FrameStore and BalanceStore up to date from Kraken's
+// WebSocket v2 API, reconnecting and resubscribing whenever a connection drops
+type KrakenStream struct {
+	PublicURL  string
+	PrivateURL string
+	Token      func() (string, error) // authenticates private channels
+	Frames     *store.FrameStore
+	Balances   *store.BalanceStore
+
+	DialTimeout       time.Duration
+	ReadTimeout       time.Duration // Kraken sends heartbeats every second
+	ReconnectDelay    time.Duration // doubles after each failed connection
+	MaxReconnectDelay time.Duration
+
+	mu          sync.Mutex
+	ohlc        []ohlcSubscription
+	trades      []market.Pair
+	balances    bool
+	executions  bool
+	forming     map[ohlcSubscription]*frame.Frame
+	onTrade     func(market.Pair, frame.Trade)
+	onExecution func(Execution)
+	onError     func(error)
+}
+
+func NewKrakenStream(
+	kraken *KrakenDriver,
+	frames *store.FrameStore,
+	balances *store.BalanceStore,
+) *KrakenStream {
+	s := &KrakenStream{
+		PublicURL:         KRAKEN_WS_URL,
+		PrivateURL:        KRAKEN_WS_AUTH_URL,
+		Frames:            frames,
+		Balances:          balances,
+		DialTimeout:       10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		ReconnectDelay:    time.Second,
+		MaxReconnectDelay: time.Minute,
+		forming:           map[ohlcSubscription]*frame.Frame{},
+	}
+
+	if kraken != nil {
+		s.Token = kraken.FetchWebSocketsToken
+	}
+
+	return s
+}
+
+// subscriptions
+func (s *KrakenStream) SubscribeOHLC(
+	pair market.Pair,
+	interval time.Duration,
+) *KrakenStream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ohlc = append(s.ohlc, ohlcSubscription{pair, interval})
+
+	return s
+}
+
+func (s *KrakenStream) SubscribeTrades(pair market.Pair) *KrakenStream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.trades = append(s.trades, pair)
+
+	return s
+}
+
+func (s *KrakenStream) SubscribeBalances() *KrakenStream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.balances = true
+
+	return s
+}
+
+func (s *KrakenStream) SubscribeExecutions() *KrakenStream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.executions = true
+
+	return s
+}
+
+// handlers
+func (s *KrakenStream) OnTrade(
+	handler func(market.Pair, frame.Trade),
+) *KrakenStream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onTrade = handler
+
+	return s
+}
+
+func (s *KrakenStream) OnExecution(handler func(Execution)) *KrakenStream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onExecution = handler
+
+	return s
+}
+
+// errors that don't stop the stream, such as dropped connections and rejected
+// subscriptions
+func (s *KrakenStream) OnError(handler func(error)) *KrakenStream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onError = handler
+
+	return s
+}
+
+func (s *KrakenStream) reportError(err error) {
+	s.mu.Lock()
+	handler := s.onError
+	s.mu.Unlock()
+
+	if handler != nil {
+		handler(err)
+	}
+}
+
+// subscribe messages
+type krakenWSRequest struct {
+	Method string         `json:"method"`
+	Params map[string]any `json:"params"`
+}
+
+func (s *KrakenStream) publicRequests() []krakenWSRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests := []krakenWSRequest{}
+	for _, sub := range s.ohlc {
+		requests = append(requests, krakenWSRequest{"subscribe", map[string]any{
+			"channel":  "ohlc",
+			"symbol":   []string{sub.pair.String()},
+			"interval": int(sub.interval.Minutes()),
+		}})
+	}
+
+	if len(s.trades) > 0 {
+		symbols := make([]string, len(s.trades))
+		for i, pair := range s.trades {
+			symbols[i] = pair.String()
+		}
+
+		requests = append(requests, krakenWSRequest{"subscribe", map[string]any{
+			"channel":  "trade",
+			"symbol":   symbols,
+			"snapshot": false,
+		}})
+	}
+
+	return requests
+}
+
+func (s *KrakenStream) privateRequests(token string) []krakenWSRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests := []krakenWSRequest{}
+	if s.balances {
+		requests = append(requests, krakenWSRequest{"subscribe", map[string]any{
+			"channel": "balances",
+			"token":   token,
+		}})
+	}
+
+	if s.executions {
+		requests = append(requests, krakenWSRequest{"subscribe", map[string]any{
+			"channel":     "executions",
+			"token":       token,
+			"snap_trades": false,
+			"snap_orders": false,
+		}})
+	}
+
+	return requests
+}
+
+// incoming messages
+type krakenWSMessage struct {
+	Method  string          `json:"method"`
+	Success *bool           `json:"success"`
+	Error   string          `json:"error"`
+	Channel string          `json:"channel"`
+	Type    string          `json:"type"`
+	Data    json.RawMessage `json:"data"`
+}
+
+func (s *KrakenStream) handle(raw []byte) error {
+	var message krakenWSMessage
+	if err := json.Unmarshal(raw, &message); err != nil {
+		return err
+	}
+
+	// acknowledgements
+	if message.Method != "" {
+		if message.Success != nil && !*message.Success {
+			return fmt.Errorf("kraken %s: %s", message.Method, message.Error)
+		}
+
+		return nil
+	}
+
+	switch message.Channel {
+	case "ohlc":
+		return s.handleOHLC(message.Data)
+	case "trade":
+		return s.handleTrades(message.Data)
+	case "balances":
+		return s.handleBalances(message.Type, message.Data)
+	case "executions":
+		return s.handleExecutions(message.Data)
+	}
+
+	// heartbeat, status and anything else
+	return nil
+}
+
+// forget the forming candles of a previous connection, which may have closed
+// while it was down; the snapshot sent after resubscribing rebuilds them
+func (s *KrakenStream) resetForming() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.forming = map[ohlcSubscription]*frame.Frame{}
+}
+
+func (s *KrakenStream) handleOHLC(data json.RawMessage) error {
+	var candles []struct {
+		Symbol        string    `json:"symbol"`
+		Open          float64   `json:"open"`
+		High          float64   `json:"high"`
+		Low           float64   `json:"low"`
+		Close         float64   `json:"close"`
+		Volume        float64   `json:"volume"`
+		IntervalBegin time.Time `json:"interval_begin"`
+		Interval      int       `json:"interval"`
+	}
+	if err := json.Unmarshal(data, &candles); err != nil {
+		return err
+	}
+
+	// a candle closes once the next one for its pair and interval arrives
+	closed := map[ohlcSubscription][]*frame.Frame{}
+	forming := map[ohlcSubscription]*frame.Frame{}
+
+	s.mu.Lock()
+	for _, candle := range candles {
+		pair, err := market.ParsePair(candle.Symbol)
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+
+		sub := ohlcSubscription{pair, time.Duration(candle.Interval) * time.Minute}
+		f := &frame.Frame{
+			Time:   candle.IntervalBegin,
+			Open:   candle.Open,
+			High:   candle.High,
+			Low:    candle.Low,
+			Close:  candle.Close,
+			Volume: candle.Volume,
+		}
+
+		last, ok := s.forming[sub]
+		if ok && f.Time.Before(last.Time) {
+			continue // out of order
+		} else if ok && f.Time.After(last.Time) {
+			closed[sub] = append(closed[sub], last)
+		}
+
+		s.forming[sub] = f
+		forming[sub] = f
+	}
+	s.mu.Unlock()
+
+	for sub, frames := range closed {
+		s.Frames.Set(sub.pair, sub.interval, frames)
+	}
+
+	for sub, f := range forming {
+		s.Frames.SetForming(sub.pair, sub.interval, f)
+	}
+
+	return nil
+}
+
+func (s *KrakenStream) handleTrades(data json.RawMessage) error {
+	var trades []struct {
+		Symbol    string    `json:"symbol"`
+		Price     float64   `json:"price"`
+		Qty       float64   `json:"qty"`
+		Timestamp time.Time `json:"timestamp"`
+	}
+	if err := json.Unmarshal(data, &trades); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	handler := s.onTrade
+	s.mu.Unlock()
+
+	if handler == nil {
+		return nil
+	}
+
+	for _, trade := range trades {
+		pair, err := market.ParsePair(trade.Symbol)
+		if err != nil {
+			return err
+		}
+
+		handler(pair, frame.Trade{
+			Time:   trade.Timestamp,
+			Price:  trade.Price,
+			Volume: trade.Qty,
+		})
+	}
+
+	return nil
+}
+
+func (s *KrakenStream) handleBalances(
+	messageType string,
+	data json.RawMessage,
+) error {
+	// json.Number keeps balances exact
+	var entries []struct {
+		Asset   string      `json:"asset"`
+		Balance json.Number `json:"balance"`
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+
+	balances := map[string]decimal.Decimal{}
+	for _, entry := range entries {
+		// leave out balances past Decimal's range rather than failing the
+		// others
+		balance, err := decimal.Parse(entry.Balance.String())
+		if errors.Is(err, decimal.ErrOverflow) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		// a snapshot's balances replace everything, so leave out zeros
+		if messageType == "snapshot" && balance.IsZero() {
+			continue
+		}
+
+		balances[entry.Asset] = balance
+	}
+
+	if messageType == "snapshot" {
+		s.Balances.Replace(balances)
+	} else {
+		s.Balances.Assign(balances)
+	}
+
+	return nil
+}
+
+func (s *KrakenStream) handleExecutions(data json.RawMessage) error {
+	var executions []struct {
+		OrderID   string      `json:"order_id"`
+		ExecType  string      `json:"exec_type"`
+		Symbol    string      `json:"symbol"`
+		Side      string      `json:"side"`
+		LastQty   json.Number `json:"last_qty"`
+		LastPrice float64     `json:"last_price"`
+		Timestamp time.Time   `json:"timestamp"`
+	}
+	if err := json.Unmarshal(data, &executions); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	handler := s.onExecution
+	s.mu.Unlock()
+
+	if handler == nil {
+		return nil
+	}
+
+	for _, execution := range executions {
+		// only fills carry a pair and quantity
+		if execution.ExecType != "trade" {
+			continue
+		}
+
+		pair, err := market.ParsePair(execution.Symbol)
+		if err != nil {
+			return err
+		}
+
+		quantity, err := decimal.Parse(execution.LastQty.String())
+		if err != nil {
+			return err
+		}
+
+		handler(Execution{
+			OrderID:  execution.OrderID,
+			ExecType: execution.ExecType,
+			Pair:     pair,
+			Side:     execution.Side,
+			Quantity: quantity,
+			Price:    execution.LastPrice,
+			Time:     execution.Timestamp,
+		})
+	}
+
+	return nil
+}
+
+// connections
+func (s *KrakenStream) session(
+	ctx context.Context,
+	url string,
+	requests []krakenWSRequest,
+) (bool, error) {
+	ws, err := dialWebSocket(url, s.DialTimeout)
+	if err != nil {
+		return false, err
+	}
+
+	// unblock reads when ctx is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ws.Close()
+		case <-done:
+			ws.Close()
+		}
+	}()
+
+	// (re)subscribe
+	for _, request := range requests {
+		body, err := json.Marshal(request)
+		if err != nil {
+			return false, err
+		}
+
+		if err := ws.WriteMessage(body); err != nil {
+			return false, err
+		}
+	}
+
+	received := false
+	for {
+		if s.ReadTimeout > 0 {
+			ws.SetReadDeadline(time.Now().Add(s.ReadTimeout))
+		}
+
+		message, err := ws.ReadMessage()
+		if err != nil {
+			return received, err
+		}
+		received = true
+
+		if err := s.handle(message); err != nil {
+			s.reportError(err)
+		}
+	}
+}
+
+// keep a connection open until ctx is done, backing off between failures
+func (s *KrakenStream) run(
+	ctx context.Context,
+	url string,
+	getRequests func() ([]krakenWSRequest, error),
+) {
+	delay := s.ReconnectDelay
+
+	for ctx.Err() == nil {
+		requests, err := getRequests()
+		received := false
+		if err == nil {
+			received, err = s.session(ctx, url, requests)
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		s.reportError(fmt.Errorf("kraken stream %s: %w", url, err))
+
+		// a connection that worked for a while starts the backoff over
+		if received {
+			delay = s.ReconnectDelay
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		delay = min(2*delay, s.MaxReconnectDelay)
+	}
+}
+
+// stream subscribed channels until ctx is done
+func (s *KrakenStream) Run(ctx context.Context) error {
+	s.mu.Lock()
+	public := len(s.ohlc) > 0 || len(s.trades) > 0
+	private := s.balances || s.executions
+	s.mu.Unlock()
+
+	if !public && !private {
+		return errors.New("no subscriptions")
+	} else if private && s.Token == nil {
+		return errors.New("private subscriptions without a token provider")
+	}
+
+	var wg sync.WaitGroup
+
+	if public {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.run(ctx, s.PublicURL, func() ([]krakenWSRequest, error) {
+				s.resetForming()
+				return s.publicRequests(), nil
+			})
+		}()
+	}
+
+	if private {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// tokens are fetched for every connection, since they expire
+			s.run(ctx, s.PrivateURL, func() ([]krakenWSRequest, error) {
+				token, err := s.Token()
+				if err != nil {
+					return nil, err
+				}
+
+				return s.privateRequests(token), nil
+			})
+		}()
+	}
+
+	wg.Wait()
+
+	return ctx.Err()
+}
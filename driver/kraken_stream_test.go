@@ -0,0 +1,376 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/haydenhigg/chrys/decimal"
+	"github.com/haydenhigg/chrys/frame"
+	"github.com/haydenhigg/chrys/market"
+	"github.com/haydenhigg/chrys/store"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// mock
+type OfflineAPI struct{}
+
+func (api OfflineAPI) FetchFramesSince(
+	pair market.Pair,
+	interval time.Duration,
+	since time.Time,
+) ([]*frame.Frame, error) {
+	return nil, store.ErrNoData
+}
+
+func (api OfflineAPI) FetchBalances() (map[string]decimal.Decimal, error) {
+	return map[string]decimal.Decimal{}, nil
+}
+
+var btcUSD = market.NewPair("BTC", "USD")
+
+// read a subscribe request from the client
+func readSubscribe(c *wsServerConn) (map[string]any, error) {
+	_, payload, err := c.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	var request struct {
+		Method string         `json:"method"`
+		Params map[string]any `json:"params"`
+	}
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return nil, err
+	}
+
+	return request.Params, nil
+}
+
+// run a stream until cancel is called, which waits for it to stop
+func runStream(s *KrakenStream) (cancel func()) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	return func() {
+		cancelCtx()
+		<-done
+	}
+}
+
+func waitFor(condition func() bool, t *testing.T) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func ohlcMessage(messageType string, start time.Time, closes ...float64) string {
+	candles := []map[string]any{}
+	for i, close := range closes {
+		candles = append(candles, map[string]any{
+			"symbol":         "BTC/USD",
+			"open":           close,
+			"high":           close,
+			"low":            close,
+			"close":          close,
+			"volume":         1,
+			"interval_begin": start.Add(time.Duration(i) * time.Hour),
+			"interval":       60,
+		})
+	}
+
+	message, _ := json.Marshal(map[string]any{
+		"channel": "ohlc",
+		"type":    messageType,
+		"data":    candles,
+	})
+
+	return string(message)
+}
+
+// tests
+func Test_KrakenStreamOHLC(t *testing.T) {
+	// set up server
+	start := time.Now().UTC().Truncate(time.Hour).Add(-2 * time.Hour)
+	params := make(chan map[string]any, 1)
+
+	server := newWSStandIn(t, func(c *wsServerConn) {
+		p, err := readSubscribe(c)
+		if err != nil {
+			return
+		}
+		params <- p
+
+		c.WriteText(`{"method":"subscribe","success":true}`)
+		c.WriteText(ohlcMessage("snapshot", start, 1, 2))
+		c.WriteText(`{"channel":"heartbeat"}`)
+		c.WriteText(ohlcMessage("update", start.Add(time.Hour), 3, 4))
+		c.Read()
+	})
+
+	frames := store.NewFrames(OfflineAPI{})
+	stream := NewKrakenStream(nil, frames, nil).SubscribeOHLC(btcUSD, time.Hour)
+	stream.PublicURL = server.URL
+
+	// Run()
+	cancel := runStream(stream)
+	waitFor(func() bool {
+		forming, ok := frames.Forming(btcUSD, time.Hour)
+		return ok && forming.Close == 4
+	}, t)
+	cancel()
+
+	// assert
+	p := <-params
+	if p["channel"] != "ohlc" || p["interval"] != 60. {
+		t.Errorf("params != ohlc at 60: %v", p)
+	}
+
+	closed := frames.Cache[btcUSD][time.Hour]
+	if len(closed) != 2 {
+		t.Fatalf("len(closed) != 2: %d", len(closed))
+	}
+
+	if closed[0].Close != 1 || closed[1].Close != 3 {
+		t.Errorf("closes != [1, 3]: [%v, %v]", closed[0].Close, closed[1].Close)
+	}
+
+	span, _ := frames.Covered(btcUSD, time.Hour)
+	if !span.End.Equal(start.Add(2 * time.Hour)) {
+		t.Errorf("span.End != %v: %v", start.Add(2*time.Hour), span.End)
+	}
+}
+
+func Test_KrakenStreamBalances(t *testing.T) {
+	// set up server
+	tokens := make(chan any, 1)
+
+	server := newWSStandIn(t, func(c *wsServerConn) {
+		p, err := readSubscribe(c)
+		if err != nil {
+			return
+		}
+		tokens <- p["token"]
+
+		c.WriteText(`{"channel":"balances","type":"snapshot","data":[` +
+			`{"asset":"USD","balance":100.5},` +
+			`{"asset":"ETH","balance":0},` +
+			`{"asset":"XBT","balance":0.1}]}`)
+		c.WriteText(`{"channel":"balances","type":"update","data":[` +
+			`{"asset":"USD","amount":-50,"balance":50.25}]}`)
+		c.Read()
+	})
+
+	balances := store.NewBalances(OfflineAPI{}).Alias("BTC", "XBT")
+	stream := NewKrakenStream(nil, nil, balances).SubscribeBalances()
+	stream.PrivateURL = server.URL
+	stream.Token = func() (string, error) {
+		return "token", nil
+	}
+
+	// Run()
+	cancel := runStream(stream)
+	waitFor(func() bool {
+		got, _ := balances.Get()
+		return got["USD"] == decimal.Decimal(5_025_000_000)
+	}, t)
+	cancel()
+
+	// assert
+	if token := <-tokens; token != "token" {
+		t.Errorf("token != \"token\": %v", token)
+	}
+
+	got, _ := balances.Get()
+	if len(got) != 2 || got["BTC"] != decimal.Decimal(10_000_000) {
+		t.Errorf("balances != {BTC: 0.1, USD: 50.25}: %v", got)
+	}
+}
+
+func Test_KrakenStreamExecutions(t *testing.T) {
+	// set up server
+	server := newWSStandIn(t, func(c *wsServerConn) {
+		readSubscribe(c)
+
+		c.WriteText(`{"channel":"executions","type":"update","data":[` +
+			`{"exec_type":"new","order_id":"A"},` +
+			`{"exec_type":"trade","order_id":"A","symbol":"BTC/USD",` +
+			`"side":"buy","last_qty":0.00012345,"last_price":60000,` +
+			`"timestamp":"2024-01-01T00:00:00Z"}]}`)
+		c.Read()
+	})
+
+	var mu sync.Mutex
+	executions := []Execution{}
+
+	stream := NewKrakenStream(nil, nil, nil).
+		SubscribeExecutions().
+		OnExecution(func(execution Execution) {
+			mu.Lock()
+			defer mu.Unlock()
+			executions = append(executions, execution)
+		})
+	stream.PrivateURL = server.URL
+	stream.Token = func() (string, error) {
+		return "token", nil
+	}
+
+	// Run()
+	cancel := runStream(stream)
+	waitFor(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(executions) > 0
+	}, t)
+	cancel()
+
+	// assert
+	if len(executions) != 1 {
+		t.Fatalf("len(executions) != 1: %d", len(executions))
+	}
+
+	execution := executions[0]
+	if execution.Pair != btcUSD || execution.Side != "buy" {
+		t.Errorf("execution != BTC/USD buy: %v", execution)
+	}
+
+	if execution.Quantity.String() != "0.00012345" {
+		t.Errorf("execution.Quantity != 0.00012345: %v", execution.Quantity)
+	}
+}
+
+func Test_KrakenStreamReconnect(t *testing.T) {
+	// set up server that drops the first connection
+	var connections atomic.Int32
+	subscribed := make(chan map[string]any, 2)
+
+	server := newWSStandIn(t, func(c *wsServerConn) {
+		n := connections.Add(1)
+
+		p, err := readSubscribe(c)
+		if err != nil {
+			return
+		}
+		subscribed <- p
+
+		if n == 1 {
+			return
+		}
+
+		c.WriteText(`{"channel":"trade","type":"update","data":[` +
+			`{"symbol":"BTC/USD","price":60000,"qty":0.5,` +
+			`"timestamp":"2024-01-01T00:00:00Z"}]}`)
+		c.Read()
+	})
+
+	var errs atomic.Int32
+	trades := make(chan frame.Trade, 1)
+
+	stream := NewKrakenStream(nil, nil, nil).
+		SubscribeTrades(btcUSD).
+		OnTrade(func(pair market.Pair, trade frame.Trade) {
+			trades <- trade
+		}).
+		OnError(func(err error) {
+			errs.Add(1)
+		})
+	stream.PublicURL = server.URL
+	stream.ReconnectDelay = time.Millisecond
+
+	// Run()
+	cancel := runStream(stream)
+	defer cancel()
+
+	var trade frame.Trade
+	select {
+	case trade = <-trades:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+	}
+
+	// assert
+	if n := connections.Load(); n != 2 {
+		t.Errorf("connections != 2: %d", n)
+	}
+
+	for range 2 {
+		if p := <-subscribed; p["channel"] != "trade" {
+			t.Errorf("resubscribed to %v", p["channel"])
+		}
+	}
+
+	if errs.Load() == 0 {
+		t.Error("dropped connection was not reported")
+	}
+
+	if trade.Price != 60000 || trade.Volume != 0.5 {
+		t.Errorf("trade != 0.5 at 60000: %v", trade)
+	}
+}
+
+func Test_KrakenStreamReconnectOHLC(t *testing.T) {
+	// set up server that drops the first connection and whose second snapshot
+	// starts after the candle that was forming
+	start := time.Now().UTC().Truncate(time.Hour).Add(-4 * time.Hour)
+	var connections atomic.Int32
+
+	server := newWSStandIn(t, func(c *wsServerConn) {
+		n := connections.Add(1)
+
+		if _, err := readSubscribe(c); err != nil {
+			return
+		}
+
+		if n == 1 {
+			c.WriteText(ohlcMessage("snapshot", start, 1, 2))
+			return
+		}
+
+		c.WriteText(ohlcMessage("snapshot", start.Add(2*time.Hour), 3, 4))
+		c.Read()
+	})
+
+	frames := store.NewFrames(OfflineAPI{})
+	stream := NewKrakenStream(nil, frames, nil).SubscribeOHLC(btcUSD, time.Hour)
+	stream.PublicURL = server.URL
+	stream.ReconnectDelay = time.Millisecond
+
+	// Run()
+	cancel := runStream(stream)
+	waitFor(func() bool {
+		forming, ok := frames.Forming(btcUSD, time.Hour)
+		return ok && forming.Close == 4
+	}, t)
+	cancel()
+
+	// assert
+	closed := frames.Cache[btcUSD][time.Hour]
+	if len(closed) != 2 {
+		t.Fatalf("len(closed) != 2: %d", len(closed))
+	}
+
+	if closed[0].Close != 1 || closed[1].Close != 3 {
+		t.Errorf("closes != [1, 3]: [%v, %v]", closed[0].Close, closed[1].Close)
+	}
+}
+
+func Test_KrakenStreamNoSubscriptions(t *testing.T) {
+	// Run()
+	err := NewKrakenStream(nil, nil, nil).Run(context.Background())
+
+	// assert
+	if err == nil {
+		t.Error("err == nil")
+	}
+}
@@ -0,0 +1,590 @@
+package driver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/haydenhigg/chrys/decimal"
+	"github.com/haydenhigg/chrys/frame"
+	"github.com/haydenhigg/chrys/market"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mock
+const TEST_SECRET = "kQH5HW/8p1uGOVjbgWA7FunAmGO8lsSUXNsu3eow76sz84Q18fWxnyRz" +
+	"BHCd3pd5nE9qa99HAZtuZuj6F1huXg=="
+
+// a request received by a stand-in server
+type standInRequest struct {
+	Path  string
+	Query url.Values
+	Form  url.Values
+}
+
+// a KrakenStandIn answers requests by path, checking the signatures of private
+// ones the way Kraken does
+type KrakenStandIn struct {
+	*httptest.Server
+	mu       sync.Mutex
+	routes   map[string]func(standInRequest) string
+	Requests []standInRequest
+}
+
+func newKrakenStandIn(
+	t *testing.T,
+	routes map[string]func(standInRequest) string,
+) (*KrakenDriver, *KrakenStandIn) {
+	secret, _ := base64.StdEncoding.DecodeString(TEST_SECRET)
+	standIn := &KrakenStandIn{routes: routes}
+
+	standIn.Server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			request := standInRequest{r.URL.Path, r.URL.Query(), r.PostForm}
+
+			standIn.mu.Lock()
+			standIn.Requests = append(standIn.Requests, request)
+			standIn.mu.Unlock()
+
+			// verify the signature independently of the driver
+			if strings.HasPrefix(r.URL.Path, "/0/private/") {
+				hash := sha256.Sum256([]byte(
+					r.PostForm.Get("nonce") + r.PostForm.Encode(),
+				))
+
+				h := hmac.New(sha512.New, secret)
+				h.Write([]byte(r.URL.Path))
+				h.Write(hash[:])
+				signature := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+				if r.Header.Get("API-Key") != "key" ||
+					r.Header.Get("API-Sign") != signature {
+					fmt.Fprint(w, `{"error":["EAPI:Invalid signature"]}`)
+					return
+				}
+			}
+
+			route, ok := standIn.routes[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+
+			fmt.Fprint(w, route(request))
+		},
+	))
+	t.Cleanup(standIn.Close)
+
+	nonce := int64(0)
+	d, _ := NewKraken("key", TEST_SECRET)
+	d.BaseURL = standIn.URL
+	d.HTTPClient = standIn.Client()
+	d.Nonce = func() int64 {
+		nonce++
+		return nonce
+	}
+	d.sleep = func(time.Duration) {}
+	d.publicCounter.sleep = func(time.Duration) {}
+
+	return d, standIn
+}
+
+// a row of Kraken's OHLC response
+func ohlcRow(t time.Time, close float64) string {
+	return fmt.Sprintf(
+		`[%d,"%v","%v","%v","%v","%v","%v",1]`,
+		t.Unix(), close, close+1, close-1, close, close, 2.5,
+	)
+}
+
+func ohlcResponse(
+	start time.Time,
+	interval time.Duration,
+	closes ...float64,
+) string {
+	rows := make([]string, len(closes))
+	for i, close := range closes {
+		rows[i] = ohlcRow(start.Add(time.Duration(i)*interval), close)
+	}
+
+	return fmt.Sprintf(
+		`{"error":[],"result":{"XXBTZUSD":[%s],"last":%d}}`,
+		strings.Join(rows, ","),
+		start.Unix(),
+	)
+}
+
+// tests
+func Test_KrakenSignature(t *testing.T) {
+	// set up driver with the values from Kraken's documentation
+	d, _ := NewKraken("key", TEST_SECRET)
+
+	// buildSignature()
+	signature := d.buildSignature("/0/private/AddOrder", url.Values{
+		"nonce":     {"1616492376594"},
+		"ordertype": {"limit"},
+		"pair":      {"XBTUSD"},
+		"price":     {"37500"},
+		"type":      {"buy"},
+		"volume":    {"1.25"},
+	})
+
+	// assert
+	expected := "4/dpxb3iT4tp/ZCVEwSnEsLxx0bqyhLpdfOpc6fn7OR8+UClSV5n9E6a" +
+		"SS8MPtnRfp32bAb0nmbRn6H8ndwLUQ=="
+	if signature != expected {
+		t.Errorf("signature != %s: %s", expected, signature)
+	}
+}
+
+func Test_KrakenFetchFramesSince(t *testing.T) {
+	// set up driver
+	start := time.Now().Truncate(time.Hour).Add(-2 * time.Hour)
+	d, standIn := newKrakenStandIn(t, map[string]func(standInRequest) string{
+		"/0/public/OHLC": func(standInRequest) string {
+			return ohlcResponse(start, time.Hour, 100, 110, 120)
+		},
+	})
+
+	// FetchFramesSince() and FetchFormingFramesSince()
+	pair := market.NewPair("BTC", "USD")
+
+	frames, err := d.FetchFramesSince(pair, time.Hour, start)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	closed, forming, err := d.FetchFormingFramesSince(pair, time.Hour, start)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	query := standIn.Requests[0].Query
+	if query.Get("pair") != "XBTUSD" || query.Get("interval") != "60" {
+		t.Errorf("query != XBTUSD at 60: %v", query)
+	}
+
+	if since := fmt.Sprint(start.Unix() - 1); query.Get("since") != since {
+		t.Errorf("since != %s: %s", since, query.Get("since"))
+	}
+
+	if len(frames) != 2 || len(closed) != 2 {
+		t.Fatalf("len(frames) != 2: %d, %d", len(frames), len(closed))
+	}
+
+	expected := &frame.Frame{
+		Time:   start.Add(time.Hour),
+		Open:   110,
+		High:   111,
+		Low:    109,
+		Close:  110,
+		Volume: 2.5,
+	}
+	if *frames[1] != *expected {
+		t.Errorf("frames[1] != %v: %v", expected, frames[1])
+	}
+
+	if forming.Close != 120 || !forming.Time.Equal(start.Add(2*time.Hour)) {
+		t.Errorf("forming != 120 at start+2h: %v", forming)
+	}
+}
+
+func Test_KrakenFetchFramesSinceBackfill(t *testing.T) {
+	// set up driver whose OHLC starts 2h after since
+	start := time.Now().Truncate(time.Hour).Add(-4 * time.Hour)
+	d, standIn := newKrakenStandIn(t, map[string]func(standInRequest) string{
+		"/0/public/OHLC": func(standInRequest) string {
+			return ohlcResponse(start.Add(2*time.Hour), time.Hour, 120, 130)
+		},
+		"/0/public/Trades": func(request standInRequest) string {
+			at := func(d time.Duration) float64 {
+				return float64(start.Add(d).UnixNano()) / 1e9
+			}
+
+			if request.Query.Get("since") == "page2" {
+				return fmt.Sprintf(`{"error":[],"result":{"XXBTZUSD":[`+
+					`["99","1",%f,"s","m","",3]],"last":"page3"}}`,
+					at(130*time.Minute),
+				)
+			}
+
+			return fmt.Sprintf(`{"error":[],"result":{"XXBTZUSD":[`+
+				`["100","1",%f,"b","m","",1],`+
+				`["110","2",%f,"b","m","",2]],"last":"page2"}}`,
+				at(10*time.Minute),
+				at(70*time.Minute),
+			)
+		},
+	})
+
+	// FetchFramesSince()
+	frames, err := d.FetchFramesSince(
+		market.NewPair("BTC", "USD"),
+		time.Hour,
+		start,
+	)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if len(frames) != 3 {
+		t.Fatalf("len(frames) != 3: %d", len(frames))
+	}
+
+	for i, close := range []float64{100, 110, 120} {
+		if !frames[i].Time.Equal(start.Add(time.Duration(i)*time.Hour)) ||
+			frames[i].Close != close {
+			t.Errorf("frames[%d] != %v at start+%dh: %v", i, close, i, frames[i])
+		}
+	}
+
+	cursor := standIn.Requests[1].Query.Get("since")
+	if cursor != fmt.Sprint(start.UnixNano()) {
+		t.Errorf("first cursor != %d: %s", start.UnixNano(), cursor)
+	}
+}
+
+func Test_KrakenFetchFramesSinceBackfillCapped(t *testing.T) {
+	// set up driver whose trades never run out, each page 10m after the last
+	start := time.Now().Truncate(time.Hour).Add(-4 * time.Hour)
+	d, standIn := newKrakenStandIn(t, map[string]func(standInRequest) string{
+		"/0/public/OHLC": func(standInRequest) string {
+			return ohlcResponse(start.Add(2*time.Hour), time.Hour, 120, 130)
+		},
+		"/0/public/Trades": func(request standInRequest) string {
+			cursor := request.Query.Get("since")
+			page := time.Duration(strings.Count(cursor, "+"))
+			at := float64(start.Add(page*10*time.Minute).UnixNano()) / 1e9
+
+			return fmt.Sprintf(`{"error":[],"result":{"XXBTZUSD":[`+
+				`["100","1",%f,"b","m","",1]],"last":"%s+"}}`,
+				at,
+				cursor,
+			)
+		},
+	})
+
+	// FetchFramesSince()
+	pair := market.NewPair("BTC", "USD")
+	frames, err := d.FetchFramesSince(pair, time.Hour, start)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert that the frame whose trades ran out is left out
+	if len(frames) != 2 ||
+		!frames[0].Time.Equal(start) ||
+		!frames[1].Time.Equal(start.Add(2*time.Hour)) {
+		t.Errorf("frames != start, start+2h: %v", frames)
+	}
+
+	if n := len(standIn.Requests); n != 1+KRAKEN_MAX_TRADE_PAGES {
+		t.Errorf("len(requests) != %d: %d", 1+KRAKEN_MAX_TRADE_PAGES, n)
+	}
+}
+
+func Test_KrakenFetchFramesSinceZero(t *testing.T) {
+	// set up driver
+	start := time.Now().Truncate(time.Hour).Add(-3 * time.Hour)
+	d, standIn := newKrakenStandIn(t, map[string]func(standInRequest) string{
+		"/0/public/OHLC": func(standInRequest) string {
+			return ohlcResponse(start, time.Hour, 100, 110, 120)
+		},
+	})
+
+	// FetchFramesSince() the latest frames
+	frames, err := d.FetchFramesSince(
+		market.NewPair("BTC", "USD"),
+		time.Hour,
+		time.Time{},
+	)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if len(frames) != 2 || !frames[0].Time.Equal(start) {
+		t.Errorf("frames != 2 from %v: %v", start, frames)
+	}
+
+	if len(standIn.Requests) != 1 {
+		t.Errorf("len(requests) != 1: %d", len(standIn.Requests))
+	}
+}
+
+func Test_KrakenFetchFramesSinceOutOfRange(t *testing.T) {
+	// set up driver
+	d, standIn := newKrakenStandIn(t, nil)
+
+	for _, since := range []time.Time{
+		time.Date(1600, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2300, 1, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		// FetchFramesSince()
+		_, err := d.FetchFramesSince(
+			market.NewPair("BTC", "USD"),
+			time.Hour,
+			since,
+		)
+
+		// assert
+		if !errors.Is(err, ErrInvalidArguments) {
+			t.Errorf("err != ErrInvalidArguments at %v: %v", since, err)
+		}
+	}
+
+	if len(standIn.Requests) != 0 {
+		t.Errorf("len(requests) != 0: %d", len(standIn.Requests))
+	}
+}
+
+func Test_KrakenPublicPaced(t *testing.T) {
+	// set up driver
+	d, _ := newKrakenStandIn(t, map[string]func(standInRequest) string{
+		"/0/public/OHLC": func(standInRequest) string {
+			return ohlcResponse(time.Now().Truncate(time.Hour), time.Hour, 1)
+		},
+	})
+
+	now := time.Now()
+	waits := []time.Duration{}
+	d.publicCounter.now = func() time.Time { return now }
+	d.publicCounter.sleep = func(d time.Duration) { waits = append(waits, d) }
+
+	// FetchFramesSince() three times at once
+	for range 3 {
+		d.FetchFramesSince(market.NewPair("BTC", "USD"), time.Hour, now)
+	}
+
+	// assert
+	if !slices.Equal(waits, []time.Duration{time.Second, 2 * time.Second}) {
+		t.Errorf("waits != [1s 2s]: %v", waits)
+	}
+}
+
+func Test_KrakenFetchBalances(t *testing.T) {
+	// set up driver
+	d, standIn := newKrakenStandIn(t, map[string]func(standInRequest) string{
+		"/0/private/Balance": func(standInRequest) string {
+			return `{"error":[],"result":{` +
+				`"XXBT":"0.0012345678","ZUSD":"100.1000","XETH":"0.0000000000"}}`
+		},
+	})
+
+	// FetchBalances() twice
+	d.FetchBalances()
+	balances, err := d.FetchBalances()
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if len(balances) != 2 {
+		t.Errorf("len(balances) != 2: %v", balances)
+	}
+
+	if balances["XXBT"].String() != "0.00123456" {
+		t.Errorf("XXBT != 0.00123456: %v", balances["XXBT"])
+	}
+
+	if balances["ZUSD"] != decimal.Decimal(10_010_000_000) {
+		t.Errorf("ZUSD != 100.1: %v", balances["ZUSD"])
+	}
+
+	first, second := standIn.Requests[0].Form, standIn.Requests[1].Form
+	if first.Get("nonce") != "1" || second.Get("nonce") != "2" {
+		t.Errorf("nonces != [1, 2]: [%s, %s]",
+			first.Get("nonce"), second.Get("nonce"))
+	}
+}
+
+func Test_KrakenFetchBalancesOutOfRange(t *testing.T) {
+	// set up driver with a balance past Decimal's range
+	d, _ := newKrakenStandIn(t, map[string]func(standInRequest) string{
+		"/0/private/Balance": func(standInRequest) string {
+			return `{"error":[],"result":{` +
+				`"SHIB":"100000000000.00","ZUSD":"100.1000"}}`
+		},
+	})
+
+	// FetchBalances()
+	balances, err := d.FetchBalances()
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if len(balances) != 1 || balances["ZUSD"] != 10_010_000_000 {
+		t.Errorf("balances != ZUSD 100.1: %v", balances)
+	}
+}
+
+func Test_KrakenMarketOrder(t *testing.T) {
+	// set up driver
+	d, standIn := newKrakenStandIn(t, map[string]func(standInRequest) string{
+		"/0/private/AddOrder": func(request standInRequest) string {
+			if request.Form.Get("volume") == "100" {
+				return `{"error":["EOrder:Insufficient funds"]}`
+			}
+
+			return `{"error":[],"result":{"txid":["OUF4EM-FRGI2-MQMWZD"]}}`
+		},
+	})
+
+	// MarketOrder() twice
+	pair := market.NewPair("DOGE", "BTC")
+	quantity, _ := decimal.Parse("0.00012345")
+
+	err := d.MarketOrder("buy", pair, quantity)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	insufficientErr := d.MarketOrder("sell", pair, decimal.New(100))
+
+	// assert
+	form := standIn.Requests[0].Form
+	expected := url.Values{
+		"nonce":     {"1"},
+		"ordertype": {"market"},
+		"type":      {"buy"},
+		"volume":    {"0.00012345"},
+		"pair":      {"XDGXBT"},
+	}
+	if form.Encode() != expected.Encode() {
+		t.Errorf("form != %v: %v", expected, form)
+	}
+
+	if !errors.Is(insufficientErr, ErrInsufficientFunds) {
+		t.Errorf("err != ErrInsufficientFunds: %v", insufficientErr)
+	}
+
+	if len(standIn.Requests) != 2 {
+		t.Errorf("len(requests) != 2: %d", len(standIn.Requests))
+	}
+}
+
+func Test_KrakenBadSignature(t *testing.T) {
+	// set up driver with the wrong secret
+	d, _ := newKrakenStandIn(t, map[string]func(standInRequest) string{
+		"/0/private/Balance": func(standInRequest) string {
+			return `{"error":[],"result":{}}`
+		},
+	})
+	d.Secret = []byte("wrong")
+
+	// FetchBalances()
+	_, err := d.FetchBalances()
+
+	// assert
+	if !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("err != ErrInvalidKey: %v", err)
+	}
+}
+
+func Test_KrakenFetchAssets(t *testing.T) {
+	// set up driver
+	d, _ := newKrakenStandIn(t, map[string]func(standInRequest) string{
+		"/0/public/Assets": func(standInRequest) string {
+			return `{"error":[],"result":{` +
+				`"XXBT":{"altname":"XBT"},` +
+				`"XXDG":{"altname":"XDG"},` +
+				`"ZUSD":{"altname":"ZUSD"},` +
+				`"ZEUR":{"altname":"ZEUR"},` +
+				`"XBT.F":{"altname":"XBT.F"}}}`
+		},
+		"/0/public/AssetPairs": func(standInRequest) string {
+			return `{"error":[],"result":{` +
+				`"XXBTZUSD":{"base":"XXBT","quote":"ZUSD",` +
+				`"wsname":"XBT/USD"},` +
+				`"XXBTZEUR":{"base":"XXBT","quote":"ZEUR",` +
+				`"wsname":"XBT/EUR"}}}`
+		},
+	})
+
+	// FetchAssets()
+	assets, err := d.FetchAssets()
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	expected := map[string]string{
+		"XXBT":  "BTC",
+		"XBT":   "BTC",
+		"XXDG":  "DOGE",
+		"XDG":   "DOGE",
+		"ZUSD":  "USD",
+		"USD":   "USD",
+		"ZEUR":  "EUR",
+		"XBT.F": "BTC.F",
+	}
+	for name, canonical := range expected {
+		if assets.Canonical(name) != canonical {
+			t.Errorf("%s != %s: %s", name, canonical, assets.Canonical(name))
+		}
+	}
+}
+
+func Test_KrakenFetchPairs(t *testing.T) {
+	// set up driver
+	d, _ := newKrakenStandIn(t, map[string]func(standInRequest) string{
+		"/0/public/AssetPairs": func(standInRequest) string {
+			return `{"error":[],"result":{` +
+				`"XXBTZUSD":{"altname":"XBTUSD","wsname":"XBT/USD",` +
+				`"status":"online"},` +
+				`"XETHXXBT":{"altname":"ETHXBT","wsname":"ETH/XBT",` +
+				`"status":"online"},` +
+				`"XXDGZUSD":{"altname":"XDGUSD","wsname":"XDG/USD",` +
+				`"status":"delisted"}}}`
+		},
+	})
+
+	// FetchPairs()
+	pairs, err := d.FetchPairs()
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	expected := []market.Pair{
+		market.NewPair("BTC", "USD").WithSymbol("XBTUSD"),
+		market.NewPair("ETH", "BTC").WithSymbol("ETHXBT"),
+	}
+	if !slices.Equal(pairs, expected) {
+		t.Errorf("pairs != %v: %v", expected, pairs)
+	}
+}
+
+func Test_KrakenOTP(t *testing.T) {
+	// set up driver
+	d, standIn := newKrakenStandIn(t, map[string]func(standInRequest) string{
+		"/0/private/Balance": func(standInRequest) string {
+			return `{"error":[],"result":{}}`
+		},
+	})
+	d.SetOTP(StaticOTP("hunter2"))
+
+	// FetchBalances()
+	_, err := d.FetchBalances()
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if otp := standIn.Requests[0].Form.Get("otp"); otp != "hunter2" {
+		t.Errorf("otp != hunter2: %s", otp)
+	}
+}
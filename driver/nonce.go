@@ -0,0 +1,110 @@
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// a NonceSource hands out strictly increasing nonces, even to concurrent
+// callers. Nonces follow the clock in milliseconds, but never go back when it
+// does; a persisted source also never goes back across restarts
+type NonceSource struct {
+	last atomic.Int64
+	now  func() time.Time
+
+	// persistence
+	path    string
+	mu      sync.Mutex
+	written int64
+	err     error
+}
+
+func NewNonceSource() *NonceSource {
+	return &NonceSource{now: time.Now}
+}
+
+// create a NonceSource that continues from the nonce in the file at path, if
+// there is one, and writes every nonce it hands out there
+func NewPersistentNonceSource(path string) (*NonceSource, error) {
+	source := &NonceSource{now: time.Now, path: path}
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return source, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	last, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	source.last.Store(last)
+	source.written = last
+
+	return source, nil
+}
+
+// write a nonce unless a greater one has already been written
+func (source *NonceSource) persist(nonce int64) {
+	source.mu.Lock()
+	defer source.mu.Unlock()
+
+	if nonce <= source.written {
+		return
+	}
+
+	// write to a temporary file first so that the file is never partial
+	tmp, err := os.CreateTemp(filepath.Dir(source.path), ".nonce-*")
+	if err != nil {
+		source.err = err
+		return
+	}
+
+	_, err = tmp.WriteString(strconv.FormatInt(nonce, 10))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), source.path)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		source.err = err
+		return
+	}
+
+	source.written, source.err = nonce, nil
+}
+
+// get the next nonce
+func (source *NonceSource) Next() int64 {
+	for {
+		last := source.last.Load()
+		next := max(last+1, source.now().UnixMilli())
+
+		if source.last.CompareAndSwap(last, next) {
+			if source.path != "" {
+				source.persist(next)
+			}
+
+			return next
+		}
+	}
+}
+
+// get the error from the last attempt to persist a nonce, if it failed
+func (source *NonceSource) Err() error {
+	source.mu.Lock()
+	defer source.mu.Unlock()
+
+	return source.err
+}
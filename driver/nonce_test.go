@@ -0,0 +1,115 @@
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_NonceSourceConcurrent(t *testing.T) {
+	// set up source with a stopped clock
+	now := time.Now()
+	source := NewNonceSource()
+	source.now = func() time.Time { return now }
+
+	// Next() from many goroutines
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		nonces = map[int64]bool{}
+	)
+
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for range 100 {
+				nonce := source.Next()
+
+				mu.Lock()
+				nonces[nonce] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// assert
+	if len(nonces) != 800 {
+		t.Errorf("len(nonces) != 800: %d", len(nonces))
+	}
+}
+
+func Test_NonceSourceClockGoesBack(t *testing.T) {
+	// set up source
+	now := time.Now()
+	source := NewNonceSource()
+	source.now = func() time.Time { return now }
+
+	// Next() before and after the clock goes back
+	first := source.Next()
+	now = now.Add(-time.Minute)
+	second := source.Next()
+
+	// assert
+	if first != now.Add(time.Minute).UnixMilli() {
+		t.Errorf("first != now: %d", first)
+	}
+
+	if second != first+1 {
+		t.Errorf("second != first + 1: %d", second)
+	}
+}
+
+func Test_PersistentNonceSource(t *testing.T) {
+	// set up source
+	path := filepath.Join(t.TempDir(), "nonce")
+	now := time.Now()
+
+	source, err := NewPersistentNonceSource(path)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+	source.now = func() time.Time { return now }
+
+	// Next() from a new source after the clock goes back
+	first := source.Next()
+
+	restarted, err := NewPersistentNonceSource(path)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+	restarted.now = func() time.Time { return now.Add(-time.Hour) }
+
+	second := restarted.Next()
+
+	// assert
+	if second != first+1 {
+		t.Errorf("second != first + 1: %d, %d", first, second)
+	}
+
+	if content, _ := os.ReadFile(path); string(content) == "" {
+		t.Error("nonce was not persisted")
+	}
+
+	if restarted.Err() != nil {
+		t.Errorf("Err() != nil: %v", restarted.Err())
+	}
+}
+
+func Test_PersistentNonceSourceCorrupt(t *testing.T) {
+	// set up file
+	path := filepath.Join(t.TempDir(), "nonce")
+	os.WriteFile(path, []byte("garbage"), 0644)
+
+	// NewPersistentNonceSource()
+	_, err := NewPersistentNonceSource(path)
+
+	// assert
+	if err == nil {
+		t.Error("err == nil")
+	}
+}
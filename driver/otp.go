@@ -0,0 +1,50 @@
+package driver
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// get one-time passwords that are always password, for API keys that are
+// protected by a static password
+func StaticOTP(password string) func() string {
+	return func() string {
+		return password
+	}
+}
+
+// get the 6-digit RFC 6238 code for a base32 secret at t
+func totpAt(key []byte, t time.Time) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(t.Unix()/30))
+
+	h := hmac.New(sha1.New, key)
+	h.Write(counter)
+	sum := h.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%06d", code%1_000_000)
+}
+
+// get one-time passwords from an authenticator app's base32 secret, for API
+// keys that are protected by 2FA
+func TOTP(secret string) (func() string, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := base32.StdEncoding.
+		WithPadding(base32.NoPadding).
+		DecodeString(strings.TrimRight(secret, "="))
+	if err != nil {
+		return nil, err
+	}
+
+	return func() string {
+		return totpAt(key, time.Now())
+	}, nil
+}
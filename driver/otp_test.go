@@ -0,0 +1,49 @@
+package driver
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_TOTP(t *testing.T) {
+	// RFC 6238's SHA-1 test vectors, truncated to 6 digits
+	key := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for seconds, expected := range vectors {
+		// totpAt()
+		code := totpAt(key, time.Unix(seconds, 0))
+
+		// assert
+		if code != expected {
+			t.Errorf("code at %d != %s: %s", seconds, expected, code)
+		}
+	}
+}
+
+func Test_TOTPSecret(t *testing.T) {
+	// TOTP() with the base32 encoding of the test key
+	otp, err := TOTP("gezd gnbv gy3t qojq gezd gnbv gy3t qojq")
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	key := []byte("12345678901234567890")
+	before := totpAt(key, time.Now())
+	code := otp()
+	after := totpAt(key, time.Now())
+
+	if code != before && code != after {
+		t.Errorf("otp() != %s: %s", before, code)
+	}
+
+	if _, err := TOTP("not base32!"); err == nil {
+		t.Error("err == nil")
+	}
+}
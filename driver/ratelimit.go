@@ -0,0 +1,134 @@
+package driver
+
+import (
+	"sync"
+	"time"
+)
+
+// Kraken's verification tiers, which set how fast private calls can be made
+type KrakenTier int
+
+const (
+	KRAKEN_STARTER KrakenTier = iota
+	KRAKEN_INTERMEDIATE
+	KRAKEN_PRO
+)
+
+// the most a tier's call counter can reach, and how much it decays per second
+func (tier KrakenTier) limits() (float64, float64) {
+	switch tier {
+	case KRAKEN_INTERMEDIATE:
+		return 20, 0.5
+	case KRAKEN_PRO:
+		return 20, 1
+	default:
+		return 15, 0.33
+	}
+}
+
+// the call counter cost of private endpoints that don't cost 1
+var krakenCallCosts = map[string]float64{
+	"/Ledgers":       2,
+	"/QueryLedgers":  2,
+	"/TradesHistory": 2,
+	"/QueryTrades":   2,
+	"/AddOrder":      0, // limited separately by the trading engine
+	"/CancelOrder":   0,
+	"/EditOrder":     0,
+}
+
+// a callCounter mirrors Kraken's call counter, which each call increases by
+// its cost and which decays at a constant rate; a call that would take it
+// past the maximum waits until it has decayed enough
+type callCounter struct {
+	mu    sync.Mutex
+	max   float64
+	decay float64 // per second
+	count float64
+	at    time.Time
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+func newCallCounter(tier KrakenTier) *callCounter {
+	maxCount, decay := tier.limits()
+
+	return &callCounter{
+		max:   maxCount,
+		decay: decay,
+		now:   time.Now,
+		sleep: time.Sleep,
+	}
+}
+
+// a counter that paces calls that each cost 1 to perSecond, in bursts of up
+// to a second's worth
+func newRateCounter(perSecond float64) *callCounter {
+	return &callCounter{
+		max:   perSecond,
+		decay: perSecond,
+		now:   time.Now,
+		sleep: time.Sleep,
+	}
+}
+
+// Kraken limits public calls by IP address to about one per second
+func newPublicCallCounter() *callCounter {
+	return newRateCounter(1)
+}
+
+// Binance limits the weight of requests by IP address per minute, which a
+// counter that decays by a sixtieth of the limit per second approximates
+func newWeightCounter(perMinute float64) *callCounter {
+	return &callCounter{
+		max:   perMinute,
+		decay: perMinute / 60,
+		now:   time.Now,
+		sleep: time.Sleep,
+	}
+}
+
+// decay the count up to now (must hold counter.mu)
+func (counter *callCounter) decayed() {
+	now := counter.now()
+	elapsed := now.Sub(counter.at).Seconds()
+	counter.count = max(counter.count-elapsed*counter.decay, 0)
+	counter.at = now
+}
+
+// reserve cost on the counter, and get how long to wait before calling
+func (counter *callCounter) reserve(cost float64) time.Duration {
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+
+	counter.decayed()
+
+	// reservations past the maximum queue up behind one another
+	counter.count += cost
+	if over := counter.count - counter.max; over > 0 {
+		return time.Duration(over / counter.decay * float64(time.Second))
+	}
+
+	return 0
+}
+
+func (counter *callCounter) wait(cost float64) {
+	if delay := counter.reserve(cost); delay > 0 {
+		counter.sleep(delay)
+	}
+}
+
+// raise the counter to at least count, as when the exchange reports that its
+// own is fuller
+func (counter *callCounter) raise(count float64) {
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+
+	counter.decayed()
+	counter.count = max(counter.count, count)
+}
+
+// fill the counter, as when the exchange reports that it is already full
+func (counter *callCounter) fill() {
+	counter.raise(counter.max)
+}
@@ -0,0 +1,53 @@
+package driver
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_CallCounterWaits(t *testing.T) {
+	// set up counter
+	now := time.Now()
+	waits := []time.Duration{}
+
+	counter := newCallCounter(KRAKEN_PRO)
+	counter.now = func() time.Time { return now }
+	counter.sleep = func(d time.Duration) { waits = append(waits, d) }
+
+	// wait() up to the maximum, then past it
+	for range 20 {
+		counter.wait(1)
+	}
+
+	counter.wait(1)
+	counter.wait(2)
+
+	// assert
+	if len(waits) != 2 {
+		t.Fatalf("len(waits) != 2: %v", waits)
+	}
+
+	if waits[0] != time.Second || waits[1] != 3*time.Second {
+		t.Errorf("waits != [1s, 3s]: %v", waits)
+	}
+}
+
+func Test_CallCounterDecays(t *testing.T) {
+	// set up counter
+	now := time.Now()
+	waits := []time.Duration{}
+
+	counter := newCallCounter(KRAKEN_INTERMEDIATE)
+	counter.now = func() time.Time { return now }
+	counter.sleep = func(d time.Duration) { waits = append(waits, d) }
+
+	// fill() and wait() after it decays
+	counter.fill()
+	now = now.Add(4 * time.Second)
+	counter.wait(2)
+
+	// assert
+	if len(waits) != 0 {
+		t.Errorf("len(waits) != 0: %v", waits)
+	}
+}
@@ -0,0 +1,47 @@
+package driver
+
+import (
+	"net/http"
+	"time"
+)
+
+// how a driver retries failed requests
+type retryPolicy struct {
+	maxRetries int
+	delay      time.Duration // before the first retry, doubling after each
+	sleep      func(time.Duration)
+}
+
+// send requests made by build until one succeeds, backing off exponentially
+// between attempts that can be retried; onErr is called with each error that
+// is about to be retried, and stops retrying if it returns an error
+func retry(
+	policy retryPolicy,
+	build func() (*http.Request, error),
+	send func(*http.Request) error,
+	idempotent bool,
+	onErr func(error) error,
+) error {
+	delay := policy.delay
+
+	for attempt := 0; ; attempt++ {
+		request, err := build()
+		if err != nil {
+			return err
+		}
+
+		err = send(request)
+		if err == nil {
+			return nil
+		} else if attempt >= policy.maxRetries || !isRetryable(err, idempotent) {
+			return err
+		}
+
+		if err := onErr(err); err != nil {
+			return err
+		}
+
+		policy.sleep(delay)
+		delay *= 2
+	}
+}
@@ -0,0 +1,38 @@
+package driver
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// get the numbers at indices of a row of an exchange's response, which can be
+// strings or numbers
+func parseRow(row []any, indices ...int) ([]float64, error) {
+	values := make([]float64, len(indices))
+
+	for i, index := range indices {
+		if index >= len(row) {
+			return nil, fmt.Errorf("%w: row %v is too short", ErrMalformedResponse, row)
+		}
+
+		switch value := row[index].(type) {
+		case string:
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrMalformedResponse, err)
+			}
+
+			values[i] = parsed
+		case float64:
+			values[i] = value
+		default:
+			return nil, fmt.Errorf(
+				"%w: %v is not a number",
+				ErrMalformedResponse,
+				row[index],
+			)
+		}
+	}
+
+	return values, nil
+}
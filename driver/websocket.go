@@ -0,0 +1,286 @@
+package driver
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// a minimal RFC 6455 client, enough for exchange streaming APIs
+const (
+	WS_GUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+
+	wsMaxMessageSize = 16 << 20
+)
+
+var ErrWebSocketClosed = errors.New("websocket closed")
+
+type wsConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	writeMu sync.Mutex
+	masked  bool // clients mask every frame they send
+}
+
+// get the Sec-WebSocket-Accept value for a Sec-WebSocket-Key
+func wsAccept(key string) string {
+	hash := sha1.Sum([]byte(key + WS_GUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func dialWebSocket(rawURL string, timeout time.Duration) (*wsConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// connect
+	host := u.Host
+	if u.Port() == "" {
+		switch u.Scheme {
+		case "ws":
+			host = net.JoinHostPort(u.Hostname(), "80")
+		case "wss":
+			host = net.JoinHostPort(u.Hostname(), "443")
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+
+	var conn net.Conn
+	switch u.Scheme {
+	case "ws":
+		conn, err = dialer.Dial("tcp", host)
+	case "wss":
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, &tls.Config{
+			ServerName: u.Hostname(),
+		})
+	default:
+		return nil, fmt.Errorf("unsupported websocket scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// handshake
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	request, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	request.Header.Set("Upgrade", "websocket")
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Sec-WebSocket-Key", key)
+	request.Header.Set("Sec-WebSocket-Version", "13")
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := request.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, fmt.Errorf("websocket handshake: %s", response.Status)
+	} else if response.Header.Get("Sec-WebSocket-Accept") != wsAccept(key) {
+		conn.Close()
+		return nil, errors.New("websocket handshake: bad Sec-WebSocket-Accept")
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	return &wsConn{conn: conn, reader: reader, masked: true}, nil
+}
+
+// framing
+func readWSFrame(reader *bufio.Reader) (bool, byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin, opcode := header[0]&0x80 != 0, header[0]&0x0f
+	masked := header[1]&0x80 != 0
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		if _, err := io.ReadFull(reader, extended); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		if _, err := io.ReadFull(reader, extended); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended)
+	}
+
+	if length > wsMaxMessageSize {
+		return false, 0, nil, fmt.Errorf("websocket frame of %d bytes", length)
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(reader, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+
+	return fin, opcode, payload, nil
+}
+
+func writeWSFrame(
+	writer io.Writer,
+	opcode byte,
+	payload []byte,
+	masked bool,
+) error {
+	frame := []byte{0x80 | opcode}
+
+	maskBit := byte(0)
+	if masked {
+		maskBit = 0x80
+	}
+
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+
+	if masked {
+		var mask [4]byte
+		rand.Read(mask[:])
+		frame = append(frame, mask[:]...)
+
+		start := len(frame)
+		frame = append(frame, payload...)
+		for i := range payload {
+			frame[start+i] ^= mask[i%4]
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	_, err := writer.Write(frame)
+	return err
+}
+
+// messages
+func (ws *wsConn) write(opcode byte, payload []byte) error {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+
+	return writeWSFrame(ws.conn, opcode, payload, ws.masked)
+}
+
+func (ws *wsConn) WriteMessage(data []byte) error {
+	return ws.write(wsText, data)
+}
+
+// read the next text or binary message, answering pings along the way
+func (ws *wsConn) ReadMessage() ([]byte, error) {
+	message := []byte{}
+	fragmented := false
+
+	for {
+		fin, opcode, payload, err := readWSFrame(ws.reader)
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case wsPing:
+			if err := ws.write(wsPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			ws.write(wsClose, payload)
+			return nil, ErrWebSocketClosed
+		case wsText, wsBinary:
+			if fragmented {
+				return nil, errors.New("websocket: unfinished fragmented message")
+			}
+		case wsContinuation:
+			if !fragmented {
+				return nil, errors.New("websocket: unexpected continuation")
+			}
+		default:
+			return nil, fmt.Errorf("websocket: unknown opcode %d", opcode)
+		}
+
+		message = append(message, payload...)
+		if len(message) > wsMaxMessageSize {
+			return nil, fmt.Errorf("websocket message over %d bytes", len(message))
+		} else if fin {
+			return message, nil
+		}
+
+		fragmented = true
+	}
+}
+
+func (ws *wsConn) SetReadDeadline(t time.Time) error {
+	return ws.conn.SetReadDeadline(t)
+}
+
+// send a close frame and close the connection
+func (ws *wsConn) Close() error {
+	ws.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	ws.write(wsClose, []byte{0x03, 0xe8}) // 1000: normal closure
+
+	return ws.conn.Close()
+}
@@ -0,0 +1,164 @@
+package driver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a local WebSocket server that runs serve for each connection
+type wsStandIn struct {
+	*httptest.Server
+	URL string
+}
+
+// the server's side of a connection
+type wsServerConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (c *wsServerConn) Read() (byte, []byte, error) {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, opcode, payload, err := readWSFrame(c.reader)
+	return opcode, payload, err
+}
+
+func (c *wsServerConn) Write(opcode byte, payload []byte) error {
+	return writeWSFrame(c.conn, opcode, payload, false)
+}
+
+func (c *wsServerConn) WriteText(message string) error {
+	return c.Write(wsText, []byte(message))
+}
+
+func newWSStandIn(t *testing.T, serve func(*wsServerConn)) *wsStandIn {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("Sec-WebSocket-Key")
+			if r.Header.Get("Upgrade") != "websocket" || key == "" {
+				http.Error(w, "not a websocket", http.StatusBadRequest)
+				return
+			}
+
+			conn, rw, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("err != nil: %v", err)
+				return
+			}
+			defer conn.Close()
+
+			fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+				"Upgrade: websocket\r\n"+
+				"Connection: Upgrade\r\n"+
+				"Sec-WebSocket-Accept: %s\r\n\r\n", wsAccept(key))
+			rw.Flush()
+
+			serve(&wsServerConn{conn, rw.Reader})
+		},
+	))
+	t.Cleanup(server.Close)
+
+	return &wsStandIn{server, "ws" + strings.TrimPrefix(server.URL, "http")}
+}
+
+// tests
+func Test_WebSocketFragmentsAndPings(t *testing.T) {
+	// set up server
+	pong := make(chan string, 1)
+	server := newWSStandIn(t, func(c *wsServerConn) {
+		c.Write(wsPing, []byte("hi"))
+
+		// send "hello, world" in two fragments
+		writer := c.conn
+		writer.Write([]byte{wsText, 7})
+		writer.Write([]byte("hello, "))
+		writeWSFrame(writer, wsContinuation, []byte("world"), false)
+
+		opcode, payload, _ := c.Read()
+		if opcode == wsPong {
+			pong <- string(payload)
+		}
+
+		c.Read() // wait for the close
+	})
+
+	// dial and read
+	ws, err := dialWebSocket(server.URL, time.Second)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+	defer ws.Close()
+
+	message, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if string(message) != "hello, world" {
+		t.Errorf("message != \"hello, world\": %q", message)
+	}
+
+	select {
+	case payload := <-pong:
+		if payload != "hi" {
+			t.Errorf("pong != \"hi\": %q", payload)
+		}
+	case <-time.After(time.Second):
+		t.Error("no pong")
+	}
+}
+
+func Test_WebSocketMasksClientFrames(t *testing.T) {
+	// set up server
+	received := make(chan []byte, 1)
+	server := newWSStandIn(t, func(c *wsServerConn) {
+		header := make([]byte, 2)
+		io.ReadFull(c.reader, header)
+		received <- header
+	})
+
+	// dial and write
+	ws, err := dialWebSocket(server.URL, time.Second)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+	defer ws.Close()
+
+	ws.WriteMessage([]byte("{}"))
+
+	// assert
+	header := <-received
+	if header[1]&0x80 == 0 {
+		t.Error("client frame is not masked")
+	}
+}
+
+func Test_WebSocketClose(t *testing.T) {
+	// set up server
+	server := newWSStandIn(t, func(c *wsServerConn) {
+		c.Write(wsClose, []byte{0x03, 0xe8})
+		c.Read()
+	})
+
+	// dial and read
+	ws, err := dialWebSocket(server.URL, time.Second)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+	defer ws.Close()
+
+	_, err = ws.ReadMessage()
+
+	// assert
+	if err != ErrWebSocketClosed {
+		t.Errorf("err != ErrWebSocketClosed: %v", err)
+	}
+}
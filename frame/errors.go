@@ -0,0 +1,39 @@
+package frame
+
+import (
+	"errors"
+	"fmt"
+	"github.com/haydenhigg/chrys/market"
+	"time"
+)
+
+// shared by the drivers that retrieve frames and the stores that cache them
+var (
+	ErrNoData             = errors.New("no data")
+	ErrInsufficientFrames = errors.New("insufficient frames")
+	ErrNoForming          = errors.New("no forming frame")
+	ErrLookAhead          = errors.New("frames after the simulated time")
+)
+
+// returned when fewer frames are available than were requested
+type InsufficientFramesError struct {
+	Pair      market.Pair
+	Interval  time.Duration
+	Requested int
+	Available int
+}
+
+func (err *InsufficientFramesError) Error() string {
+	return fmt.Sprintf(
+		"%v for %s at %v: requested %d, available %d",
+		ErrInsufficientFrames,
+		err.Pair,
+		err.Interval,
+		err.Requested,
+		err.Available,
+	)
+}
+
+func (err *InsufficientFramesError) Unwrap() error {
+	return ErrInsufficientFrames
+}
@@ -0,0 +1,38 @@
+package frame
+
+import "time"
+
+// aggregate sorted frames into frames of a longer interval; every bucket that
+// contains at least one frame is returned, so callers must drop buckets that
+// are only partially covered by the input
+func Resample(frames []*Frame, interval time.Duration) []*Frame {
+	resampled := []*Frame{}
+
+	var bucket *Frame
+	for _, f := range frames {
+		t := f.Time.Truncate(interval)
+
+		// start a new bucket
+		if bucket == nil || !bucket.Time.Equal(t) {
+			bucket = &Frame{
+				Time:   t,
+				Open:   f.Open,
+				High:   f.High,
+				Low:    f.Low,
+				Close:  f.Close,
+				Volume: f.Volume,
+			}
+			resampled = append(resampled, bucket)
+
+			continue
+		}
+
+		// add to the current bucket
+		bucket.High = max(bucket.High, f.High)
+		bucket.Low = min(bucket.Low, f.Low)
+		bucket.Close = f.Close
+		bucket.Volume += f.Volume
+	}
+
+	return resampled
+}
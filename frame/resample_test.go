@@ -0,0 +1,46 @@
+package frame
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Resample(t *testing.T) {
+	// create frames
+	start := time.Unix(0, 0).Add(1000 * time.Hour)
+	frames := []*Frame{
+		{Time: start, Open: 10, High: 12, Low: 9, Close: 11, Volume: 1},
+		{Time: start.Add(time.Minute), Open: 11, High: 15, Low: 10, Close: 14, Volume: 2},
+		{Time: start.Add(2 * time.Minute), Open: 14, High: 14, Low: 7, Close: 8, Volume: 3},
+		{Time: start.Add(3 * time.Minute), Open: 8, High: 9, Low: 8, Close: 9, Volume: 4},
+	}
+
+	// Resample()
+	resampled := Resample(frames, 3*time.Minute)
+
+	// assert
+	expected := []*Frame{
+		{Time: start, Open: 10, High: 15, Low: 7, Close: 8, Volume: 6},
+		{Time: start.Add(3 * time.Minute), Open: 8, High: 9, Low: 8, Close: 9, Volume: 4},
+	}
+
+	if len(resampled) != len(expected) {
+		t.Fatalf("len(resampled) != %d: %d", len(expected), len(resampled))
+	}
+
+	for i, f := range resampled {
+		if *f != *expected[i] {
+			t.Errorf("resampled[%d] != %v: %v", i, *expected[i], *f)
+		}
+	}
+}
+
+func Test_ResampleEmpty(t *testing.T) {
+	// Resample()
+	resampled := Resample([]*Frame{}, time.Hour)
+
+	// assert
+	if len(resampled) != 0 {
+		t.Errorf("len(resampled) != 0: %d", len(resampled))
+	}
+}
@@ -0,0 +1,43 @@
+package frame
+
+import "time"
+
+type Trade struct {
+	Time   time.Time
+	Price  float64
+	Volume float64
+}
+
+// build frames of interval from sorted trades; intervals without any trades
+// have no frame
+func FromTrades(trades []Trade, interval time.Duration) []*Frame {
+	frames := []*Frame{}
+
+	var current *Frame
+	for _, trade := range trades {
+		t := trade.Time.Truncate(interval)
+
+		// start a new frame
+		if current == nil || !current.Time.Equal(t) {
+			current = &Frame{
+				Time:   t,
+				Open:   trade.Price,
+				High:   trade.Price,
+				Low:    trade.Price,
+				Close:  trade.Price,
+				Volume: trade.Volume,
+			}
+			frames = append(frames, current)
+
+			continue
+		}
+
+		// add to the current frame
+		current.High = max(current.High, trade.Price)
+		current.Low = min(current.Low, trade.Price)
+		current.Close = trade.Price
+		current.Volume += trade.Volume
+	}
+
+	return frames
+}
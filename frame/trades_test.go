@@ -0,0 +1,43 @@
+package frame
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_FromTrades(t *testing.T) {
+	// set up trades
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	trades := []Trade{
+		{Time: start.Add(5 * time.Second), Price: 10, Volume: 1},
+		{Time: start.Add(20 * time.Second), Price: 12, Volume: 2},
+		{Time: start.Add(40 * time.Second), Price: 9, Volume: 0.5},
+		{Time: start.Add(59 * time.Second), Price: 11, Volume: 1},
+		{Time: start.Add(3*time.Minute + time.Second), Price: 13, Volume: 3},
+	}
+
+	// FromTrades()
+	frames := FromTrades(trades, time.Minute)
+
+	// assert
+	if len(frames) != 2 {
+		t.Fatalf("len(frames) != 2: %d", len(frames))
+	}
+
+	expected := Frame{Time: start, Open: 10, High: 12, Low: 9, Close: 11, Volume: 4.5}
+	if *frames[0] != expected {
+		t.Errorf("frames[0] != %v: %v", expected, *frames[0])
+	}
+
+	expected = Frame{
+		Time:   start.Add(3 * time.Minute),
+		Open:   13,
+		High:   13,
+		Low:    13,
+		Close:  13,
+		Volume: 3,
+	}
+	if *frames[1] != expected {
+		t.Errorf("frames[1] != %v: %v", expected, *frames[1])
+	}
+}
@@ -0,0 +1,200 @@
+package frame
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+type AnomalyKind int
+
+const (
+	Gap       AnomalyKind = iota // one or more intervals are missing
+	Duplicate                    // a frame has the same time as the prior one
+	Unordered                    // a frame is earlier than the prior one
+	Malformed                    // a frame's values are inconsistent
+)
+
+func (kind AnomalyKind) String() string {
+	switch kind {
+	case Gap:
+		return "gap"
+	case Duplicate:
+		return "duplicate"
+	case Unordered:
+		return "unordered"
+	case Malformed:
+		return "malformed"
+	default:
+		return fmt.Sprintf("AnomalyKind(%d)", int(kind))
+	}
+}
+
+type Anomaly struct {
+	Kind    AnomalyKind
+	Index   int       // the index of the offending frame
+	Time    time.Time // the time of the offending frame
+	Missing int       // the number of missing intervals before a Gap
+	Reason  string
+}
+
+func (anomaly Anomaly) String() string {
+	return fmt.Sprintf(
+		"%v at %v (index %d): %s",
+		anomaly.Kind,
+		anomaly.Time,
+		anomaly.Index,
+		anomaly.Reason,
+	)
+}
+
+// check a frame's values for consistency
+func Check(f *Frame) error {
+	switch {
+	case f.Open <= 0 || f.High <= 0 || f.Low <= 0 || f.Close <= 0:
+		return fmt.Errorf("non-positive price")
+	case f.High < f.Low:
+		return fmt.Errorf("high %v < low %v", f.High, f.Low)
+	case f.Open > f.High || f.Open < f.Low:
+		return fmt.Errorf("open %v outside [%v, %v]", f.Open, f.Low, f.High)
+	case f.Close > f.High || f.Close < f.Low:
+		return fmt.Errorf("close %v outside [%v, %v]", f.Close, f.Low, f.High)
+	case f.Volume < 0:
+		return fmt.Errorf("negative volume %v", f.Volume)
+	}
+
+	return nil
+}
+
+// report gaps, duplicates, ordering problems and malformed frames in a series
+// that should have one frame per interval
+func Validate(frames []*Frame, interval time.Duration) []Anomaly {
+	anomalies := []Anomaly{}
+
+	for i, f := range frames {
+		if err := Check(f); err != nil {
+			anomalies = append(anomalies, Anomaly{
+				Kind:   Malformed,
+				Index:  i,
+				Time:   f.Time,
+				Reason: err.Error(),
+			})
+		}
+
+		if i == 0 {
+			continue
+		}
+
+		prior := frames[i-1]
+		switch delta := f.Time.Sub(prior.Time); {
+		case delta == 0:
+			anomalies = append(anomalies, Anomaly{
+				Kind:   Duplicate,
+				Index:  i,
+				Time:   f.Time,
+				Reason: "same time as prior frame",
+			})
+		case delta < 0:
+			anomalies = append(anomalies, Anomaly{
+				Kind:   Unordered,
+				Index:  i,
+				Time:   f.Time,
+				Reason: fmt.Sprintf("%v before prior frame", -delta),
+			})
+		case delta > interval:
+			missing := int((delta - 1) / interval)
+			anomalies = append(anomalies, Anomaly{
+				Kind:    Gap,
+				Index:   i,
+				Time:    f.Time,
+				Missing: missing,
+				Reason:  fmt.Sprintf("%d frames missing", missing),
+			})
+		}
+	}
+
+	return anomalies
+}
+
+type RepairPolicy int
+
+const (
+	NoRepair    RepairPolicy = iota
+	DropInvalid              // sort, deduplicate and drop malformed frames
+	ForwardFill              // DropInvalid, then fill gaps with the prior close
+	Interpolate              // DropInvalid, then fill gaps linearly
+)
+
+// sort frames, keep the last of any duplicates and drop malformed frames
+func dropInvalid(frames []*Frame) []*Frame {
+	sorted := slices.Clone(frames)
+	slices.SortStableFunc(sorted, func(a, b *Frame) int {
+		return a.Time.Compare(b.Time)
+	})
+
+	valid := make([]*Frame, 0, len(sorted))
+	for i, f := range sorted {
+		if i+1 < len(sorted) && sorted[i+1].Time.Equal(f.Time) {
+			continue
+		} else if Check(f) != nil {
+			continue
+		}
+
+		valid = append(valid, f)
+	}
+
+	return valid
+}
+
+// build a zero-volume frame at t that moves from open to close
+func fillFrame(t time.Time, open, close float64) *Frame {
+	return &Frame{
+		Time:  t,
+		Open:  open,
+		High:  max(open, close),
+		Low:   min(open, close),
+		Close: close,
+	}
+}
+
+// repair a series that should have one frame per interval according to policy
+func Repair(
+	frames []*Frame,
+	interval time.Duration,
+	policy RepairPolicy,
+) []*Frame {
+	if policy == NoRepair {
+		return frames
+	}
+
+	frames = dropInvalid(frames)
+	if policy == DropInvalid || len(frames) == 0 {
+		return frames
+	}
+
+	repaired := make([]*Frame, 0, len(frames))
+	for i, f := range frames {
+		if i > 0 {
+			prior := frames[i-1]
+			missing := int((f.Time.Sub(prior.Time) - 1) / interval)
+
+			// fill each missing interval
+			price := prior.Close
+			for j := 1; j <= missing; j++ {
+				nextPrice := price
+				if policy == Interpolate {
+					step := (f.Open - prior.Close) / float64(missing+1)
+					nextPrice = prior.Close + step*float64(j)
+				}
+
+				t := prior.Time.Add(time.Duration(j) * interval)
+				repaired = append(repaired, fillFrame(t, price, nextPrice))
+				price = nextPrice
+			}
+		}
+
+		repaired = append(repaired, f)
+	}
+
+	return repaired
+}
@@ -0,0 +1,162 @@
+package frame
+
+import (
+	"testing"
+	"time"
+)
+
+// helpers
+func newFrame(t time.Time, open, close float64) *Frame {
+	return &Frame{
+		Time:   t,
+		Open:   open,
+		High:   max(open, close),
+		Low:    min(open, close),
+		Close:  close,
+		Volume: 1,
+	}
+}
+
+// tests
+func Test_Check(t *testing.T) {
+	// create frames
+	start := time.Unix(0, 0)
+	valid := newFrame(start, 10, 11)
+	invalid := []*Frame{
+		{Time: start, Open: 0, High: 1, Low: 1, Close: 1},
+		{Time: start, Open: 1, High: 1, Low: 2, Close: 1},
+		{Time: start, Open: 3, High: 2, Low: 1, Close: 1},
+		{Time: start, Open: 1, High: 2, Low: 1, Close: 3},
+		{Time: start, Open: 1, High: 2, Low: 1, Close: 1, Volume: -1},
+	}
+
+	// assert
+	if err := Check(valid); err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	for i, f := range invalid {
+		if Check(f) == nil {
+			t.Errorf("invalid[%d] passed Check()", i)
+		}
+	}
+}
+
+func Test_Validate(t *testing.T) {
+	// create frames
+	start := time.Unix(0, 0)
+	frames := []*Frame{
+		newFrame(start, 10, 11),
+		newFrame(start.Add(time.Minute), 11, 12),
+		newFrame(start.Add(time.Minute), 11, 12),
+		newFrame(start.Add(4*time.Minute), 12, 13),
+		{Time: start.Add(5 * time.Minute), Open: 13, High: 12, Low: 14, Close: 13},
+		newFrame(start.Add(3*time.Minute), 12, 13),
+	}
+
+	// Validate()
+	anomalies := Validate(frames, time.Minute)
+
+	// assert
+	expected := []Anomaly{
+		{Kind: Duplicate, Index: 2},
+		{Kind: Gap, Index: 3, Missing: 2},
+		{Kind: Malformed, Index: 4},
+		{Kind: Unordered, Index: 5},
+	}
+
+	if len(anomalies) != len(expected) {
+		t.Fatalf("len(anomalies) != %d: %v", len(expected), anomalies)
+	}
+
+	for i, anomaly := range anomalies {
+		if anomaly.Kind != expected[i].Kind ||
+			anomaly.Index != expected[i].Index ||
+			anomaly.Missing != expected[i].Missing {
+			t.Errorf("anomalies[%d] != %v: %v", i, expected[i], anomaly)
+		}
+	}
+}
+
+func Test_RepairDropInvalid(t *testing.T) {
+	// create frames
+	start := time.Unix(0, 0)
+	last := newFrame(start.Add(time.Minute), 11, 13)
+	frames := []*Frame{
+		newFrame(start.Add(time.Minute), 11, 12),
+		newFrame(start, 10, 11),
+		last,
+		{Time: start.Add(2 * time.Minute), Open: -1, High: 1, Low: 1, Close: 1},
+	}
+
+	// Repair()
+	repaired := Repair(frames, time.Minute, DropInvalid)
+
+	// assert
+	if len(repaired) != 2 {
+		t.Fatalf("len(repaired) != 2: %d", len(repaired))
+	}
+
+	if !repaired[0].Time.Equal(start) || repaired[1] != last {
+		t.Errorf("repaired != [start, last]: %v, %v", repaired[0], repaired[1])
+	}
+}
+
+func Test_RepairForwardFill(t *testing.T) {
+	// create frames
+	start := time.Unix(0, 0)
+	frames := []*Frame{
+		newFrame(start, 10, 11),
+		newFrame(start.Add(3*time.Minute), 14, 15),
+	}
+
+	// Repair()
+	repaired := Repair(frames, time.Minute, ForwardFill)
+
+	// assert
+	if len(repaired) != 4 {
+		t.Fatalf("len(repaired) != 4: %d", len(repaired))
+	}
+
+	for i, f := range repaired[1:3] {
+		expected := Frame{
+			Time:  start.Add(time.Duration(i+1) * time.Minute),
+			Open:  11,
+			High:  11,
+			Low:   11,
+			Close: 11,
+		}
+
+		if *f != expected {
+			t.Errorf("repaired[%d] != %v: %v", i+1, expected, *f)
+		}
+	}
+}
+
+func Test_RepairInterpolate(t *testing.T) {
+	// create frames
+	start := time.Unix(0, 0)
+	frames := []*Frame{
+		newFrame(start, 10, 11),
+		newFrame(start.Add(3*time.Minute), 14, 15),
+	}
+
+	// Repair()
+	repaired := Repair(frames, time.Minute, Interpolate)
+
+	// assert
+	if len(repaired) != 4 {
+		t.Fatalf("len(repaired) != 4: %d", len(repaired))
+	}
+
+	expectedCloses := []float64{11, 12, 13, 15}
+	for i, f := range repaired {
+		if f.Close != expectedCloses[i] {
+			t.Errorf("repaired[%d].Close != %v: %v", i, expectedCloses[i], f.Close)
+		}
+	}
+
+	if repaired[2].Open != 12 || repaired[2].Volume != 0 {
+		t.Errorf("repaired[2] != {Open: 12, Volume: 0}: %v", *repaired[2])
+	}
+}
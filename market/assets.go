@@ -0,0 +1,23 @@
+package market
+
+import "strings"
+
+// an AssetMap maps an exchange's names for assets to canonical names, like
+// XXBT to BTC
+type AssetMap map[string]string
+
+// get the canonical name of an asset; suffixes that mark balances that are
+// staked or otherwise held apart, like ".S" or ".F", are kept so that those
+// balances aren't counted as tradable
+func (assets AssetMap) Canonical(asset string) string {
+	name, suffix, hasSuffix := strings.Cut(asset, ".")
+	if canonical, ok := assets[name]; ok {
+		name = canonical
+	}
+
+	if hasSuffix {
+		return name + "." + suffix
+	}
+
+	return name
+}
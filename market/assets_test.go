@@ -0,0 +1,22 @@
+package market
+
+import "testing"
+
+func Test_Canonical(t *testing.T) {
+	// set up map
+	assets := AssetMap{"XXBT": "BTC", "XBT": "BTC", "ZUSD": "USD"}
+
+	// assert
+	for asset, expected := range map[string]string{
+		"XXBT":  "BTC",
+		"XBT":   "BTC",
+		"ZUSD":  "USD",
+		"ETH":   "ETH",
+		"XBT.F": "BTC.F",
+		"DOT.S": "DOT.S",
+	} {
+		if canonical := assets.Canonical(asset); canonical != expected {
+			t.Errorf("Canonical(%q) != %q: %q", asset, expected, canonical)
+		}
+	}
+}
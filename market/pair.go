@@ -0,0 +1,67 @@
+package market
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrInvalidPair = errors.New("invalid pair")
+	ErrUnknownPair = errors.New("unknown pair")
+)
+
+// a Pair is a base asset priced in a quote asset, like BTC/USD, along with the
+// exchange-specific symbol for it if it differs from what a driver would
+// derive from the assets, like XXBTZUSD
+type Pair struct {
+	Base   string
+	Quote  string
+	Symbol string
+}
+
+func NewPair(base, quote string) Pair {
+	return Pair{Base: base, Quote: quote}
+}
+
+// parse a pair like "BTC/USD"
+func ParsePair(s string) (Pair, error) {
+	base, quote, ok := strings.Cut(s, "/")
+	pair := NewPair(strings.TrimSpace(base), strings.TrimSpace(quote))
+
+	if !ok {
+		return Pair{}, fmt.Errorf("%w %q: missing \"/\"", ErrInvalidPair, s)
+	} else if err := pair.Validate(); err != nil {
+		return Pair{}, fmt.Errorf("%w %q: %w", ErrInvalidPair, s, err)
+	}
+
+	return pair, nil
+}
+
+func (pair Pair) Validate() error {
+	switch {
+	case pair.Base == "":
+		return errors.New("missing base asset")
+	case pair.Quote == "":
+		return errors.New("missing quote asset")
+	case strings.Contains(pair.Base, "/") || strings.Contains(pair.Quote, "/"):
+		return errors.New("asset contains \"/\"")
+	case pair.Base == pair.Quote:
+		return errors.New("base and quote assets are the same")
+	}
+
+	return nil
+}
+
+func (pair Pair) WithSymbol(symbol string) Pair {
+	pair.Symbol = symbol
+	return pair
+}
+
+func (pair Pair) Inverse() Pair {
+	return NewPair(pair.Quote, pair.Base)
+}
+
+func (pair Pair) String() string {
+	return pair.Base + "/" + pair.Quote
+}
@@ -0,0 +1,45 @@
+package market
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_ParsePair(t *testing.T) {
+	// ParsePair()
+	pair, err := ParsePair("BTC/USD")
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if pair.Base != "BTC" || pair.Quote != "USD" || pair.Symbol != "" {
+		t.Errorf("pair != BTC/USD: %#v", pair)
+	}
+
+	if pair.String() != "BTC/USD" {
+		t.Errorf("pair.String() != BTC/USD: %s", pair.String())
+	}
+}
+
+func Test_ParsePairInvalid(t *testing.T) {
+	for _, s := range []string{"", "XXBTZUSD", "/USD", "BTC/", "BTC/USD/EUR", "USD/USD"} {
+		// ParsePair()
+		_, err := ParsePair(s)
+
+		// assert
+		if !errors.Is(err, ErrInvalidPair) {
+			t.Errorf("%q: err != ErrInvalidPair: %v", s, err)
+		}
+	}
+}
+
+func Test_Inverse(t *testing.T) {
+	// Inverse()
+	pair := NewPair("EUR", "USD").WithSymbol("ZEURZUSD").Inverse()
+
+	// assert
+	if pair != NewPair("USD", "EUR") {
+		t.Errorf("pair != USD/EUR: %#v", pair)
+	}
+}
@@ -1,51 +1,177 @@
 package chrys
 
-import "time"
+import (
+	"slices"
+	"time"
+)
 
 type Block = func(now time.Time) error
-type Scheduler map[time.Duration][]Block
+
+// a Middleware wraps every Block scheduled at an interval
+type Middleware = func(interval time.Duration, next Block) Block
+
+// lifecycle hooks
+type TickHook = func(now time.Time) error
+type ErrorHook = func(now time.Time, err error) error // nil swallows err
+type StartHook = func(start time.Time) error
+type StopHook = func(end time.Time, err error)
+
+type Hooks struct {
+	BeforeTick []TickHook
+	AfterTick  []TickHook
+	OnError    []ErrorHook
+	OnStart    []StartHook
+	OnStop     []StopHook
+}
+
+// a Scheduler runs Blocks at their intervals, through middleware and hooks
+type Scheduler struct {
+	Blocks     map[time.Duration][]Block
+	Middleware []Middleware
+	Hooks      Hooks
+}
 
 // initializer
-func NewScheduler() Scheduler {
-	return Scheduler{}
+func NewScheduler() *Scheduler {
+	return &Scheduler{Blocks: map[time.Duration][]Block{}}
 }
 
-func (scheduler Scheduler) Add(interval time.Duration, block Block) Scheduler {
-	if blocks, ok := scheduler[interval]; ok {
-		scheduler[interval] = append(blocks, block)
-	} else {
-		scheduler[interval] = []Block{block}
-	}
+func (scheduler *Scheduler) Add(
+	interval time.Duration,
+	block Block,
+) *Scheduler {
+	scheduler.Blocks[interval] = append(scheduler.Blocks[interval], block)
+	return scheduler
+}
+
+// middleware and hooks
+func (scheduler *Scheduler) Use(middleware ...Middleware) *Scheduler {
+	scheduler.Middleware = append(scheduler.Middleware, middleware...)
+	return scheduler
+}
 
+func (scheduler *Scheduler) BeforeTick(hook TickHook) *Scheduler {
+	scheduler.Hooks.BeforeTick = append(scheduler.Hooks.BeforeTick, hook)
 	return scheduler
 }
 
-func (scheduler Scheduler) Run(now time.Time) error {
-	t := now.Truncate(time.Minute)
+func (scheduler *Scheduler) AfterTick(hook TickHook) *Scheduler {
+	scheduler.Hooks.AfterTick = append(scheduler.Hooks.AfterTick, hook)
+	return scheduler
+}
+
+func (scheduler *Scheduler) OnError(hook ErrorHook) *Scheduler {
+	scheduler.Hooks.OnError = append(scheduler.Hooks.OnError, hook)
+	return scheduler
+}
+
+func (scheduler *Scheduler) OnStart(hook StartHook) *Scheduler {
+	scheduler.Hooks.OnStart = append(scheduler.Hooks.OnStart, hook)
+	return scheduler
+}
+
+func (scheduler *Scheduler) OnStop(hook StopHook) *Scheduler {
+	scheduler.Hooks.OnStop = append(scheduler.Hooks.OnStop, hook)
+	return scheduler
+}
+
+// wrap a block so that the first middleware added is the outermost
+func (scheduler *Scheduler) wrap(interval time.Duration, block Block) Block {
+	for i := len(scheduler.Middleware) - 1; i >= 0; i-- {
+		block = scheduler.Middleware[i](interval, block)
+	}
+
+	return block
+}
+
+// pass an error through the error hooks, any of which may swallow it
+func (scheduler *Scheduler) handleError(t time.Time, err error) error {
+	for _, hook := range scheduler.Hooks.OnError {
+		if err == nil {
+			break
+		}
+
+		err = hook(t, err)
+	}
+
+	return err
+}
+
+func (scheduler *Scheduler) runTickHooks(t time.Time, hooks []TickHook) error {
+	for _, hook := range hooks {
+		if err := scheduler.handleError(t, hook(t)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (scheduler *Scheduler) Run(now time.Time) error {
+	t := now.Truncate(time.Second)
+
+	err := scheduler.runTickHooks(t, scheduler.Hooks.BeforeTick)
+	if err != nil {
+		return err
+	}
+
+	// run blocks from the shortest interval to the longest
+	intervals := make([]time.Duration, 0, len(scheduler.Blocks))
+	for interval := range scheduler.Blocks {
+		intervals = append(intervals, interval)
+	}
+	slices.Sort(intervals)
+
+	for _, interval := range intervals {
+		if !t.Truncate(interval).Equal(t) {
+			continue
+		}
 
-	for interval, blocks := range scheduler {
-		if t.Truncate(interval).Equal(t) {
-			for _, block := range blocks {
-				if err := block(t); err != nil {
-					return err
-				}
+		for _, block := range scheduler.Blocks[interval] {
+			err := scheduler.wrap(interval, block)(t)
+			if err = scheduler.handleError(t, err); err != nil {
+				return err
 			}
 		}
 	}
 
+	return scheduler.runTickHooks(t, scheduler.Hooks.AfterTick)
+}
+
+func (scheduler *Scheduler) start(t time.Time) error {
+	for _, hook := range scheduler.Hooks.OnStart {
+		if err := hook(t); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func (scheduler Scheduler) RunBetween(
+func (scheduler *Scheduler) stop(t time.Time, err error) error {
+	for _, hook := range scheduler.Hooks.OnStop {
+		hook(t, err)
+	}
+
+	return err
+}
+
+func (scheduler *Scheduler) RunBetween(
 	start, end time.Time,
 	step time.Duration,
 ) error {
 	start, end = start.Truncate(step), end.Truncate(step)
-	for t := start; t.Before(end); t = t.Add(step) {
+
+	if err := scheduler.start(start); err != nil {
+		return scheduler.stop(start, err)
+	}
+
+	t := start
+	for ; t.Before(end); t = t.Add(step) {
 		if err := scheduler.Run(t); err != nil {
-			return err
+			return scheduler.stop(t, err)
 		}
 	}
 
-	return nil
+	return scheduler.stop(t, nil)
 }
@@ -1,6 +1,8 @@
 package chrys
 
 import (
+	"errors"
+	"slices"
 	"testing"
 	"time"
 )
@@ -13,11 +15,11 @@ func Test_Add(t *testing.T) {
 	scheduler.Add(time.Minute, func(now time.Time) error { return nil })
 
 	// assert
-	if blocks, ok := scheduler[time.Minute]; !ok {
-		t.Errorf("scheduler[time.Minute] does not exist")
+	if blocks, ok := scheduler.Blocks[time.Minute]; !ok {
+		t.Errorf("scheduler.Blocks[time.Minute] does not exist")
 	} else {
 		if len(blocks) != 1 {
-			t.Errorf("len(scheduler[time.Minute]) != 1: %d", len(blocks))
+			t.Errorf("len(scheduler.Blocks[time.Minute]) != 1: %d", len(blocks))
 		}
 	}
 }
@@ -94,3 +96,230 @@ func Test_RunBetween(t *testing.T) {
 		t.Errorf("last time != %v: %v", expectedLastTime, lastTime)
 	}
 }
+
+func Test_RunSubMinute(t *testing.T) {
+	// create Scheduler
+	scheduler := NewScheduler()
+
+	// mock
+	didRun10Sec, didRun30Sec := false, false
+	scheduler.Add(10*time.Second, func(now time.Time) error {
+		didRun10Sec = true
+		return nil
+	}).Add(30*time.Second, func(now time.Time) error {
+		didRun30Sec = true
+		return nil
+	})
+
+	// Run()
+	now := time.Now().Truncate(time.Minute).Add(20*time.Second + time.Millisecond)
+	if err := scheduler.Run(now); err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	// assert
+	if !didRun10Sec {
+		t.Errorf("10 * time.Second Block did not run")
+	}
+
+	if didRun30Sec {
+		t.Errorf("30 * time.Second Block did run")
+	}
+}
+
+func Test_RunBetweenSubMinute(t *testing.T) {
+	// create Scheduler
+	scheduler := NewScheduler()
+
+	// mock
+	times := []time.Time{}
+	scheduler.Add(30*time.Second, func(now time.Time) error {
+		times = append(times, now)
+		return nil
+	})
+
+	// RunBetween()
+	start, _ := time.Parse(time.RFC3339, "2025-06-29T08:00:05Z")
+	end, _ := time.Parse(time.RFC3339, "2025-06-29T08:02:00Z")
+	err := scheduler.RunBetween(start, end, 10*time.Second)
+	if err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	// assert
+	if len(times) != 4 {
+		t.Errorf("len(times) != 4: %d", len(times))
+	}
+
+	expectedLastTime, _ := time.Parse(time.RFC3339, "2025-06-29T08:01:30Z")
+	if len(times) > 0 && !times[len(times)-1].Equal(expectedLastTime) {
+		t.Errorf("last time != %v: %v", expectedLastTime, times[len(times)-1])
+	}
+}
+
+// tests -> middleware and hooks
+func Test_Use(t *testing.T) {
+	// create Scheduler
+	scheduler := NewScheduler()
+
+	// mock
+	calls := []string{}
+	scheduler.Add(time.Minute, func(now time.Time) error {
+		calls = append(calls, "block")
+		return nil
+	}).Use(func(interval time.Duration, next Block) Block {
+		return func(now time.Time) error {
+			calls = append(calls, "outer")
+			return next(now)
+		}
+	}).Use(func(interval time.Duration, next Block) Block {
+		return func(now time.Time) error {
+			calls = append(calls, "inner "+interval.String())
+			return next(now)
+		}
+	})
+
+	// Run()
+	if err := scheduler.Run(time.Now().Truncate(time.Minute)); err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	// assert
+	expectedCalls := []string{"outer", "inner 1m0s", "block"}
+	if !slices.Equal(calls, expectedCalls) {
+		t.Errorf("calls != %v: %v", expectedCalls, calls)
+	}
+}
+
+func Test_TickHooks(t *testing.T) {
+	// create Scheduler
+	scheduler := NewScheduler()
+
+	// mock
+	calls := []string{}
+	scheduler.Add(time.Minute, func(now time.Time) error {
+		calls = append(calls, "block")
+		return nil
+	}).BeforeTick(func(now time.Time) error {
+		calls = append(calls, "before")
+		return nil
+	}).AfterTick(func(now time.Time) error {
+		calls = append(calls, "after")
+		return nil
+	})
+
+	// Run()
+	if err := scheduler.Run(time.Now().Truncate(time.Minute)); err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	// assert
+	expectedCalls := []string{"before", "block", "after"}
+	if !slices.Equal(calls, expectedCalls) {
+		t.Errorf("calls != %v: %v", expectedCalls, calls)
+	}
+}
+
+func Test_OnError(t *testing.T) {
+	// create Scheduler
+	scheduler := NewScheduler()
+
+	// mock
+	blockErr := errors.New("block failed")
+	var handledErr error
+	didRunAfter := false
+	scheduler.Add(time.Minute, func(now time.Time) error {
+		return blockErr
+	}).OnError(func(now time.Time, err error) error {
+		handledErr = err
+		return nil
+	}).AfterTick(func(now time.Time) error {
+		didRunAfter = true
+		return nil
+	})
+
+	// Run()
+	if err := scheduler.Run(time.Now().Truncate(time.Minute)); err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	// assert
+	if handledErr != blockErr {
+		t.Errorf("handledErr != blockErr: %v", handledErr)
+	}
+
+	if !didRunAfter {
+		t.Errorf("AfterTick hook did not run")
+	}
+}
+
+func Test_OnErrorPropagate(t *testing.T) {
+	// create Scheduler
+	scheduler := NewScheduler()
+
+	// mock
+	blockErr := errors.New("block failed")
+	didRunAfter := false
+	scheduler.Add(time.Minute, func(now time.Time) error {
+		return blockErr
+	}).OnError(func(now time.Time, err error) error {
+		return err
+	}).AfterTick(func(now time.Time) error {
+		didRunAfter = true
+		return nil
+	})
+
+	// Run()
+	if err := scheduler.Run(time.Now().Truncate(time.Minute)); err != blockErr {
+		t.Errorf("err != blockErr: %v", err)
+	}
+
+	// assert
+	if didRunAfter {
+		t.Errorf("AfterTick hook did run")
+	}
+}
+
+func Test_StartStopHooks(t *testing.T) {
+	// create Scheduler
+	scheduler := NewScheduler()
+
+	// mock
+	var startTime, stopTime time.Time
+	var stopErr error
+	ticks := 0
+	scheduler.Add(time.Hour, func(now time.Time) error {
+		ticks++
+		return nil
+	}).OnStart(func(start time.Time) error {
+		startTime = start
+		return nil
+	}).OnStop(func(end time.Time, err error) {
+		stopTime = end
+		stopErr = err
+	})
+
+	// RunBetween()
+	start, _ := time.Parse(time.RFC3339, "2025-06-29T08:00:00Z")
+	end := start.Add(3 * time.Hour)
+	if err := scheduler.RunBetween(start, end, time.Hour); err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	// assert
+	if ticks != 3 {
+		t.Errorf("ticks != 3: %d", ticks)
+	}
+
+	if !startTime.Equal(start) {
+		t.Errorf("start time != %v: %v", start, startTime)
+	}
+
+	if !stopTime.Equal(end) {
+		t.Errorf("stop time != %v: %v", end, stopTime)
+	}
+
+	if stopErr != nil {
+		t.Errorf("stopErr != nil: %v", stopErr)
+	}
+}
@@ -1,68 +1,271 @@
 package store
 
+import (
+	"github.com/haydenhigg/chrys/decimal"
+	"github.com/haydenhigg/chrys/market"
+	"maps"
+	"sync"
+	"time"
+)
+
 type BalanceAPI interface {
-	FetchBalances() (map[string]float64, error)
+	FetchBalances() (map[string]decimal.Decimal, error)
+}
+
+// a BalanceAPI whose names for assets differ from canonical ones
+type AssetAPI interface {
+	FetchAssets() (market.AssetMap, error)
 }
 
+// a BalanceStore keeps balances under canonical asset names only. It is safe
+// for concurrent use; Balances and Aliases should only be accessed directly
+// when no other goroutine is using the store
 type BalanceStore struct {
 	api      BalanceAPI
-	Balances map[string]float64
-	Aliases  map[string]string
+	Balances map[string]decimal.Decimal
+	Aliases  map[string]string // alias -> canonical name
+	assets   market.AssetMap   // retrieved from an AssetAPI
+	TTL      time.Duration     // how long balances stay cached; 0 is forever
+	mu       sync.RWMutex
+	flights  flightGroup
+	now      func() time.Time
+	loaded   bool // whether balances were fetched or Set
+	loadedAt time.Time
+	pending  map[string]decimal.Decimal // Set during a retrieval; nil if none
 }
 
 func NewBalances(api BalanceAPI) *BalanceStore {
 	return &BalanceStore{
 		api:      api,
-		Balances: map[string]float64{},
+		Balances: map[string]decimal.Decimal{},
 		Aliases:  map[string]string{},
+		now:      time.Now,
+	}
+}
+
+// setters
+func (store *BalanceStore) SetTTL(ttl time.Duration) *BalanceStore {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.TTL = max(ttl, 0)
+
+	return store
+}
+
+func (store *BalanceStore) getCached() (map[string]decimal.Decimal, bool) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	// check if balances have been loaded and have not expired
+	if !store.loaded {
+		return nil, false
+	} else if store.TTL > 0 && store.now().Sub(store.loadedAt) >= store.TTL {
+		return nil, false
 	}
+
+	return maps.Clone(store.Balances), true
 }
 
-func (store *BalanceStore) Get() (map[string]float64, error) {
-	// check if balances is not empty
-	if len(store.Balances) > 0 {
-		return store.Balances, nil
+// retrieve the api's asset names once, if it has its own
+func (store *BalanceStore) fetchAssets() error {
+	assetAPI, ok := store.api.(AssetAPI)
+	if !ok {
+		return nil
 	}
 
-	// retrieve from data source
-	balances, err := store.api.FetchBalances()
+	store.mu.RLock()
+	loaded := store.assets != nil
+	store.mu.RUnlock()
+
+	if loaded {
+		return nil
+	}
+
+	assets, err := assetAPI.FetchAssets()
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	// cache retrieved data
-	store.Set(balances)
+	store.mu.Lock()
+	store.assets = assets
+	store.mu.Unlock()
 
-	return store.Balances, nil
+	return nil
 }
 
-func (store *BalanceStore) Set(balances map[string]float64) *BalanceStore {
-	// update all balances additively
+func (store *BalanceStore) fetch() (map[string]decimal.Decimal, bool, error) {
+	var balances map[string]decimal.Decimal
+
+	// retrieve from data source, or wait for a simultaneous retrieval
+	shared, err := store.flights.do("balances", func() error {
+		if err := store.fetchAssets(); err != nil {
+			return err
+		}
+
+		// keep track of balances Set while retrieving
+		store.mu.Lock()
+		store.pending = map[string]decimal.Decimal{}
+		store.mu.Unlock()
+
+		fetched, err := store.api.FetchBalances()
+		if err != nil {
+			store.mu.Lock()
+			store.pending = nil
+			store.mu.Unlock()
+
+			return err
+		}
+
+		// cache retrieved data, then reapply what was Set in the meantime
+		balances = store.replace(fetched)
+
+		return nil
+	})
+
+	return balances, shared, err
+}
+
+func (store *BalanceStore) Get() (map[string]decimal.Decimal, error) {
+	for {
+		// check cache
+		if balances, ok := store.getCached(); ok {
+			return balances, nil
+		}
+
+		balances, shared, err := store.fetch()
+		if err != nil {
+			return nil, err
+		} else if !shared {
+			return balances, nil
+		}
+	}
+}
+
+// retrieve balances from the data source even if they are cached, replacing
+// the cached balances entirely
+func (store *BalanceStore) Refresh() (map[string]decimal.Decimal, error) {
+	balances, shared, err := store.fetch()
+	if err != nil {
+		return nil, err
+	} else if shared {
+		// another caller's retrieval may have started before this call
+		balances, _, err = store.fetch()
+	}
+
+	return balances, err
+}
+
+// get the canonical name of an asset (must hold store.mu)
+func (store *BalanceStore) canonical(asset string) string {
+	if canonical, ok := store.Aliases[asset]; ok {
+		return canonical
+	}
+
+	return store.assets.Canonical(asset)
+}
+
+// add balances to the cache under canonical names; a balance that leaves
+// Decimal's range is kept at its bound (must hold store.mu)
+func (store *BalanceStore) add(balances map[string]decimal.Decimal) {
 	for asset, balance := range balances {
-		store.Balances[asset] += balance
+		canonical := store.canonical(asset)
+		store.Balances[canonical], _ = store.Balances[canonical].Add(balance)
+	}
+}
 
-		// update alias balances
-		if alias, ok := store.Aliases[asset]; ok {
-			store.Balances[alias] += balance
+// overwrite the cache with balances, plus any that were Set while they were
+// being retrieved
+func (store *BalanceStore) replace(
+	balances map[string]decimal.Decimal,
+) map[string]decimal.Decimal {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.Balances = make(map[string]decimal.Decimal, len(balances))
+	store.add(balances)
+	store.add(store.pending)
+	store.pending = nil
+	store.loaded, store.loadedAt = true, store.now()
+
+	return maps.Clone(store.Balances)
+}
+
+func (store *BalanceStore) Set(balances map[string]decimal.Decimal) *BalanceStore {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	// update all balances additively
+	store.add(balances)
+
+	// a retrieval in flight has to reapply the update
+	if store.pending != nil {
+		for asset, balance := range balances {
+			canonical := store.canonical(asset)
+			store.pending[canonical], _ = store.pending[canonical].Add(balance)
 		}
 	}
 
+	// balances that are Set before any retrieval count as loaded
+	if !store.loaded {
+		store.loaded, store.loadedAt = true, store.now()
+	}
+
 	return store
 }
 
-func (store *BalanceStore) Alias(asset, assetAlias string) *BalanceStore {
-	if asset != assetAlias {
-		store.Aliases[asset] = assetAlias // alias
-		store.Aliases[assetAlias] = asset // inverted alias
+// overwrite all balances, as from a snapshot
+func (store *BalanceStore) Replace(
+	balances map[string]decimal.Decimal,
+) *BalanceStore {
+	store.replace(balances)
+	return store
+}
+
+// overwrite the balances of only the given assets
+func (store *BalanceStore) Assign(
+	balances map[string]decimal.Decimal,
+) *BalanceStore {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for asset, balance := range balances {
+		store.Balances[store.canonical(asset)] = balance
+	}
+
+	if !store.loaded {
+		store.loaded, store.loadedAt = true, store.now()
 	}
 
 	return store
 }
 
-func (store *BalanceStore) Aliased(asset string) (string, bool) {
-	if alias, ok := store.Aliases[asset]; ok {
-		return alias, true
-	} else {
-		return asset, false
+// keep balances of alias under asset, its canonical name, like
+// Alias("BTC", "XBT")
+func (store *BalanceStore) Alias(asset, alias string) *BalanceStore {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if asset == alias {
+		return store
 	}
+
+	store.Aliases[alias] = asset
+
+	// move any balance that is already kept under the alias
+	if balance, ok := store.Balances[alias]; ok {
+		delete(store.Balances, alias)
+		store.Balances[asset], _ = store.Balances[asset].Add(balance)
+	}
+
+	return store
+}
+
+// get the canonical name of an asset, and whether it differs from the asset
+func (store *BalanceStore) Aliased(asset string) (string, bool) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	canonical := store.canonical(asset)
+	return canonical, canonical != asset
 }
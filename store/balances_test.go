@@ -1,8 +1,14 @@
 package store
 
 import (
+	"errors"
+	"github.com/haydenhigg/chrys/decimal"
+	"github.com/haydenhigg/chrys/market"
 	"math"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // mock
@@ -10,24 +16,48 @@ type MockBalanceAPI struct {
 	callback func()
 }
 
-func (api MockBalanceAPI) FetchBalances() (map[string]float64, error) {
+func (api MockBalanceAPI) FetchBalances() (map[string]decimal.Decimal, error) {
 	if api.callback != nil {
 		api.callback()
 	}
 
-	return map[string]float64{
+	return decimals(map[string]float64{
 		"USD": 133.7,
 		"BTC": 0.001337,
 		"ETH": 0.01337,
-	}, nil
+	}), nil
+}
+
+type FuncBalanceAPI func() (map[string]decimal.Decimal, error)
+
+func (api FuncBalanceAPI) FetchBalances() (map[string]decimal.Decimal, error) {
+	return api()
 }
 
 // tests
-func assertBalancesEqual(a, b map[string]float64, t *testing.T) {
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-6
+}
+
+// convert floats to decimals
+func decimals(balances map[string]float64) map[string]decimal.Decimal {
+	converted := make(map[string]decimal.Decimal, len(balances))
+	for asset, balance := range balances {
+		converted[asset], _ = decimal.FromFloat(balance)
+	}
+
+	return converted
+}
+
+func assertBalancesEqual(
+	a map[string]decimal.Decimal,
+	b map[string]float64,
+	t *testing.T,
+) {
 	for k, va := range a {
 		if vb, ok := b[k]; !ok {
 			t.Errorf(`b["%s"] does not exist`, k)
-		} else if math.Abs(va-vb) > 1e-6 {
+		} else if math.Abs(va.Float()-vb) > 1e-6 {
 			t.Errorf(`a["%s"] != b["%s"]: %v != %v`, k, k, va, vb)
 		}
 	}
@@ -64,9 +94,14 @@ func Test_GetUncached(t *testing.T) {
 
 func Test_GetUncachedWithAlias(t *testing.T) {
 	// set up store
-	store := NewBalances(MockBalanceAPI{})
-	store.Alias("BTC", "XXBT") // alias
-	store.Alias("ZUSD", "USD") // inverted alias
+	store := NewBalances(FuncBalanceAPI(func() (map[string]decimal.Decimal, error) {
+		return decimals(map[string]float64{
+			"ZUSD": 133.7,
+			"XXBT": 0.001337,
+			"ETH":  0.01337,
+		}), nil
+	}))
+	store.Alias("BTC", "XXBT").Alias("USD", "ZUSD")
 
 	// Get()
 	balances, err := store.Get()
@@ -76,11 +111,46 @@ func Test_GetUncachedWithAlias(t *testing.T) {
 
 	// assert
 	assertBalancesEqual(balances, map[string]float64{
-		"USD":  133.70,
-		"ZUSD": 133.70,
-		"BTC":  0.001337,
-		"XXBT": 0.001337,
-		"ETH":  0.01337,
+		"USD": 133.70,
+		"BTC": 0.001337,
+		"ETH": 0.01337,
+	}, t)
+}
+
+type AssetBalanceAPI struct {
+	FuncBalanceAPI
+	assets market.AssetMap
+}
+
+func (api AssetBalanceAPI) FetchAssets() (market.AssetMap, error) {
+	return api.assets, nil
+}
+
+func Test_GetUncachedWithAssets(t *testing.T) {
+	// set up store
+	store := NewBalances(AssetBalanceAPI{
+		FuncBalanceAPI(func() (map[string]decimal.Decimal, error) {
+			return decimals(map[string]float64{
+				"ZUSD":  133.7,
+				"XXBT":  0.001,
+				"XBT":   0.000337,
+				"XBT.F": 0.5,
+			}), nil
+		}),
+		market.AssetMap{"ZUSD": "USD", "XXBT": "BTC", "XBT": "BTC"},
+	})
+
+	// Get()
+	balances, err := store.Get()
+	if err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	// assert
+	assertBalancesEqual(balances, map[string]float64{
+		"USD":   133.70,
+		"BTC":   0.001337,
+		"BTC.F": 0.5,
 	}, t)
 }
 
@@ -116,6 +186,125 @@ func Test_GetCached(t *testing.T) {
 	}
 }
 
+func Test_GetError(t *testing.T) {
+	// set up mock
+	fetchErr := errors.New("fetch failed")
+	mockAPI := FuncBalanceAPI(func() (map[string]decimal.Decimal, error) {
+		return nil, fetchErr
+	})
+
+	// Get()
+	balances, err := NewBalances(mockAPI).Get()
+
+	// assert
+	if !errors.Is(err, fetchErr) {
+		t.Errorf("err != fetchErr: %v", err)
+	}
+
+	if balances != nil {
+		t.Errorf("balances != nil: %v", balances)
+	}
+}
+
+func Test_GetCachedEmpty(t *testing.T) {
+	// set up mock
+	calls := 0
+	mockAPI := FuncBalanceAPI(func() (map[string]decimal.Decimal, error) {
+		calls++
+		return decimals(map[string]float64{}), nil
+	})
+
+	// set up store
+	store := NewBalances(mockAPI)
+
+	// Get() twice
+	store.Get()
+	balances, err := store.Get()
+	if err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	// assert
+	assertBalancesEqual(balances, map[string]float64{}, t)
+
+	if calls != 1 {
+		t.Errorf("calls != 1: %d", calls)
+	}
+}
+
+func Test_GetExpired(t *testing.T) {
+	// set up mock
+	didUseAPI := false
+	mockAPI := MockBalanceAPI{callback: func() { didUseAPI = true }}
+
+	// set up store with a controllable clock
+	now := time.Now()
+	store := NewBalances(mockAPI).SetTTL(time.Minute)
+	store.now = func() time.Time { return now }
+
+	// Get()
+	store.Get()
+	store.Set(decimals(map[string]float64{"USD": 10}))
+
+	// Get() again before expiry
+	didUseAPI = false
+	now = now.Add(59 * time.Second)
+	balances, _ := store.Get()
+
+	// assert
+	if didUseAPI {
+		t.Errorf("cache was not hit")
+	}
+
+	if !almostEqual(balances["USD"].Float(), 143.7) {
+		t.Errorf(`balances["USD"] != 143.7: %v`, balances["USD"])
+	}
+
+	// Get() again after expiry
+	now = now.Add(time.Second)
+	balances, _ = store.Get()
+
+	// assert
+	if !didUseAPI {
+		t.Errorf("cache was hit")
+	}
+
+	assertBalancesEqual(balances, map[string]float64{
+		"USD": 133.7,
+		"BTC": 0.001337,
+		"ETH": 0.01337,
+	}, t)
+}
+
+func Test_Refresh(t *testing.T) {
+	// set up mock
+	didUseAPI := false
+	mockAPI := MockBalanceAPI{callback: func() { didUseAPI = true }}
+
+	// set up store
+	store := NewBalances(mockAPI)
+	store.Set(decimals(map[string]float64{"USD": 10, "SOL": 1}))
+
+	// Refresh()
+	balances, err := store.Refresh()
+	if err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	// assert
+	if !didUseAPI {
+		t.Errorf("cache was hit")
+	}
+
+	expectedBalances := map[string]float64{
+		"USD": 133.7,
+		"BTC": 0.001337,
+		"ETH": 0.01337,
+	}
+	assertBalancesEqual(balances, expectedBalances, t)
+	assertBalancesEqual(store.Balances, expectedBalances, t)
+}
+
 func Test_Set_balances(t *testing.T) {
 	// set up store
 	store := NewBalances(MockBalanceAPI{})
@@ -126,7 +315,7 @@ func Test_Set_balances(t *testing.T) {
 		"BTC": 0.001337,
 		"ETH": 0.01337,
 	}
-	store.Set(expectedBalances)
+	store.Set(decimals(expectedBalances))
 
 	// assert
 	assertBalancesEqual(store.Balances, expectedBalances, t)
@@ -137,17 +326,17 @@ func Test_SetAddSubtract(t *testing.T) {
 	store := NewBalances(MockBalanceAPI{})
 
 	// Set()
-	store.Set(map[string]float64{
+	store.Set(decimals(map[string]float64{
 		"USD": 133.70,
 		"BTC": 0.001337,
 		"ETH": 0.01337,
-	})
+	}))
 
 	// Set() with existing keys
-	store.Set(map[string]float64{
+	store.Set(decimals(map[string]float64{
 		"USD": -43.94,
 		"ETH": 0.01337,
-	})
+	}))
 
 	// assert
 	assertBalancesEqual(store.Balances, map[string]float64{
@@ -157,77 +346,209 @@ func Test_SetAddSubtract(t *testing.T) {
 	}, t)
 }
 
+func Test_SetExact(t *testing.T) {
+	// set up store
+	store := NewBalances(MockBalanceAPI{})
+
+	// Set() a tenth ten times
+	for range 10 {
+		store.Set(decimals(map[string]float64{"BTC": 0.1}))
+	}
+
+	// assert
+	if btc := store.Balances["BTC"]; btc != decimal.New(1) {
+		t.Errorf(`store.Balances["BTC"] != 1: %v`, btc)
+	}
+}
+
 func Test_SetWithAlias(t *testing.T) {
 	// set up store
 	store := NewBalances(MockBalanceAPI{})
-	store.Alias("BTC", "XXBT") // alias
-	store.Alias("ZUSD", "USD") // inverted alias
+	store.Alias("BTC", "XXBT").Alias("USD", "ZUSD")
 
 	// Set()
-	store.Set(map[string]float64{
-		"USD": 133.70,
-		"BTC": 0.001337,
-		"ETH": 0.01337,
-	})
+	store.Set(decimals(map[string]float64{
+		"ZUSD": 133.70,
+		"BTC":  0.001,
+		"XXBT": 0.000337,
+		"ETH":  0.01337,
+	}))
 
 	// assert
 	assertBalancesEqual(store.Balances, map[string]float64{
-		"USD":  133.70,
-		"ZUSD": 133.70,
-		"BTC":  0.001337,
-		"XXBT": 0.001337,
-		"ETH":  0.01337,
+		"USD": 133.70,
+		"BTC": 0.001337,
+		"ETH": 0.01337,
 	}, t)
 }
 
 func Test_SetAddSubtractWithAlias(t *testing.T) {
 	// set up store
 	store := NewBalances(MockBalanceAPI{})
-	store.Alias("BTC", "XXBT") // normal alias
-	store.Alias("ZUSD", "USD") // inverted alias
+	store.Alias("BTC", "XXBT").Alias("USD", "ZUSD")
 
 	// Set()
-	store.Set(map[string]float64{
+	store.Set(decimals(map[string]float64{
 		"USD": 133.70,
 		"BTC": 0.001337,
 		"ETH": 0.01337,
-	})
+	}))
 
-	// Set() with existing keys
-	store.Set(map[string]float64{
-		"USD": 32.13,
-		"BTC": -0.000337,
-	})
+	// Set() with aliased keys
+	store.Set(decimals(map[string]float64{
+		"ZUSD": 32.13,
+		"XXBT": -0.000337,
+	}))
 
 	// assert
 	assertBalancesEqual(store.Balances, map[string]float64{
-		"USD":  165.83,
-		"ZUSD": 165.83,
-		"BTC":  0.001,
-		"XXBT": 0.001,
-		"ETH":  0.01337,
+		"USD": 165.83,
+		"BTC": 0.001,
+		"ETH": 0.01337,
+	}, t)
+}
+
+func Test_AliasAfterSet(t *testing.T) {
+	// set up store
+	store := NewBalances(MockBalanceAPI{})
+	store.Set(decimals(map[string]float64{"XXBT": 0.001, "BTC": 0.000337}))
+
+	// Alias()
+	store.Alias("BTC", "XXBT")
+
+	// assert
+	assertBalancesEqual(store.Balances, map[string]float64{
+		"BTC": 0.001337,
 	}, t)
 }
 
 func Test_Aliased(t *testing.T) {
 	// set up store
 	store := NewBalances(MockBalanceAPI{})
-	store.Alias("BTC", "XXBT") // alias
-	store.Alias("ZUSD", "USD") // inverted alias
+	store.Alias("BTC", "XXBT")
 
 	// Aliased()
-	alias, ok := store.Aliased("XXBT")
+	canonical, ok := store.Aliased("XXBT")
 	if !ok {
-		t.Errorf(`"ZUSD" is not aliased`)
-	} else if alias != "BTC" {
-		t.Errorf(`alias != "BTC": %s`, alias)
+		t.Errorf(`"XXBT" is not aliased`)
+	} else if canonical != "BTC" {
+		t.Errorf(`canonical != "BTC": %s`, canonical)
 	}
 
-	// inverted Aliased()
-	alias, ok = store.Aliased("ZUSD")
-	if !ok {
-		t.Errorf(`"ZUSD" is not aliased`)
-	} else if alias != "USD" {
-		t.Errorf(`alias != "USD": %s`, alias)
+	// Aliased() with a canonical name
+	canonical, ok = store.Aliased("BTC")
+	if ok {
+		t.Errorf(`"BTC" is aliased`)
+	} else if canonical != "BTC" {
+		t.Errorf(`canonical != "BTC": %s`, canonical)
+	}
+}
+
+// tests -> concurrency
+func Test_GetSetConcurrent(t *testing.T) {
+	// set up store
+	store := NewBalances(MockBalanceAPI{})
+
+	// Get() and Set() concurrently
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			store.Set(decimals(map[string]float64{"USD": 1, "BTC": -0.00001}))
+		}()
+
+		go func() {
+			defer wg.Done()
+			if _, err := store.Get(); err != nil {
+				t.Errorf("err != nil: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// assert (balances are only fetched if a Get() ran before any Set())
+	balances, _ := store.Get()
+	if usd := balances["USD"].Float(); math.Abs(usd-50) > 1e-6 &&
+		math.Abs(usd-183.7) > 1e-6 {
+		t.Errorf(`balances["USD"] != 50 or 183.7: %v`, usd)
+	}
+}
+
+func Test_SetDuringGet(t *testing.T) {
+	// set up mock that waits to return until after Set()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	mockAPI := MockBalanceAPI{callback: func() {
+		close(started)
+		<-release
+	}}
+
+	// set up store
+	store := NewBalances(mockAPI)
+
+	// Set() while Get() is retrieving
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := store.Get(); err != nil {
+			t.Errorf("err != nil: %v", err)
+		}
+	}()
+
+	<-started
+	store.Set(decimals(map[string]float64{"USD": 1}))
+	close(release)
+	<-done
+
+	// assert
+	balances, _ := store.Get()
+	assertBalancesEqual(balances, map[string]float64{
+		"USD": 134.7,
+		"BTC": 0.001337,
+		"ETH": 0.01337,
+	}, t)
+}
+
+func Test_GetDeduplicated(t *testing.T) {
+	// set up mock
+	calls := atomic.Int32{}
+	release := make(chan struct{})
+	mockAPI := MockBalanceAPI{callback: func() {
+		calls.Add(1)
+		<-release
+	}}
+
+	// set up store
+	store := NewBalances(mockAPI)
+
+	// Get() concurrently
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			balances, err := store.Get()
+			if err != nil {
+				t.Errorf("err != nil: %v", err)
+			}
+
+			assertBalancesEqual(balances, map[string]float64{
+				"USD": 133.7,
+				"BTC": 0.001337,
+				"ETH": 0.01337,
+			}, t)
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	// assert
+	if n := calls.Load(); n != 1 {
+		t.Errorf("calls != 1: %d", n)
 	}
 }
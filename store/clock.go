@@ -0,0 +1,96 @@
+package store
+
+import (
+	"fmt"
+	"github.com/haydenhigg/chrys/market"
+	"sync"
+	"time"
+)
+
+// a simulated time for backtests, safe for concurrent use
+type Clock struct {
+	mu  sync.RWMutex
+	now time.Time
+}
+
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+func (clock *Clock) Now() time.Time {
+	clock.mu.RLock()
+	defer clock.mu.RUnlock()
+
+	return clock.now
+}
+
+func (clock *Clock) Set(now time.Time) *Clock {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+
+	clock.now = now
+
+	return clock
+}
+
+// set the clock to the time of a tick, as a scheduler's BeforeTick hook
+func (clock *Clock) Advance(now time.Time) error {
+	clock.Set(now)
+	return nil
+}
+
+// only make frames available once they have closed by the time that now
+// returns, so that a backtest can't look ahead; nil disables this
+func (store *FrameStore) SetClock(now func() time.Time) *FrameStore {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.now = now
+
+	return store
+}
+
+// get the time before which every frame of interval has closed, if there is a
+// simulated time
+func (store *FrameStore) horizon(interval time.Duration) (time.Time, bool) {
+	store.mu.RLock()
+	now := store.now
+	store.mu.RUnlock()
+
+	if now == nil {
+		return time.Time{}, false
+	}
+
+	return now().Truncate(interval), true
+}
+
+// check that the frames that start in [start, end) have closed, bounding a
+// zero end by the simulated time
+func (store *FrameStore) bound(
+	pair market.Pair,
+	interval time.Duration,
+	start, end time.Time,
+) (time.Time, error) {
+	horizon, ok := store.horizon(interval)
+	if !ok {
+		return end, nil
+	}
+
+	if end.IsZero() {
+		end = start
+		if start.Before(horizon) {
+			return horizon, nil
+		}
+	} else if !end.After(horizon) {
+		return end, nil
+	}
+
+	return end, fmt.Errorf(
+		"%w for %s at %v: %v is after %v",
+		ErrLookAhead,
+		pair,
+		interval,
+		end,
+		horizon,
+	)
+}
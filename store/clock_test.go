@@ -0,0 +1,107 @@
+package store
+
+import (
+	"errors"
+	"github.com/haydenhigg/chrys/frame"
+	"github.com/haydenhigg/chrys/market"
+	"testing"
+	"time"
+)
+
+// mock
+var SIMULATED_START = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// 100 minutes of frames from SIMULATED_START, whatever the time is
+func newHistoryAPI(sinces *[]time.Time) FuncFrameAPI {
+	return func(
+		pair market.Pair,
+		interval time.Duration,
+		since time.Time,
+	) ([]*frame.Frame, error) {
+		*sinces = append(*sinces, since)
+
+		frames := []*frame.Frame{}
+		for i := range 100 {
+			t := SIMULATED_START.Add(time.Duration(i) * time.Minute)
+			if !t.Before(since) {
+				frames = append(frames, &frame.Frame{Time: t, Close: float64(i)})
+			}
+		}
+
+		return frames, nil
+	}
+}
+
+// tests
+func Test_GetSinceBoundedByClock(t *testing.T) {
+	// set up store
+	sinces := []time.Time{}
+	clock := NewClock(SIMULATED_START.Add(10*time.Minute + 30*time.Second))
+	store := NewFrames(newHistoryAPI(&sinces)).SetClock(clock.Now)
+
+	// GetSince()
+	frames, err := store.GetSince(btcUSD, time.Minute, SIMULATED_START)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if len(frames) != 10 || frames[9].Close != 9 {
+		t.Errorf("frames != 0..9: %v", frames)
+	}
+
+	if cached := store.Cache[btcUSD][time.Minute]; len(cached) != 10 {
+		t.Errorf("len(cache) != 10: %d", len(cached))
+	}
+}
+
+func Test_GetNBeforeLookAhead(t *testing.T) {
+	// set up store
+	sinces := []time.Time{}
+	clock := NewClock(SIMULATED_START.Add(10 * time.Minute))
+	store := NewFrames(newHistoryAPI(&sinces)).SetClock(clock.Now)
+
+	// GetNBefore() now, in the future and then after the clock advances
+	_, err := store.GetNBefore(btcUSD, time.Minute, 5, clock.Now())
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	later := SIMULATED_START.Add(20 * time.Minute)
+	_, lookAheadErr := store.GetNBefore(btcUSD, time.Minute, 5, later)
+
+	clock.Advance(later)
+	frames, err := store.GetNBefore(btcUSD, time.Minute, 5, later)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if !errors.Is(lookAheadErr, ErrLookAhead) {
+		t.Errorf("err != ErrLookAhead: %v", lookAheadErr)
+	}
+
+	if len(frames) != 5 || frames[4].Close != 19 {
+		t.Errorf("frames != 15..19: %v", frames)
+	}
+
+	// only the missing frames are retrieved
+	if len(sinces) != 2 || !sinces[1].Equal(later.Add(-5*time.Minute)) {
+		t.Errorf("sinces != start+5m, start+15m: %v", sinces)
+	}
+}
+
+func Test_GetFormingLookAhead(t *testing.T) {
+	// set up store
+	now := SIMULATED_START
+	clock := NewClock(now)
+	store := NewFrames(newLiveAPI(&now, &[]time.Time{})).SetClock(clock.Now)
+
+	// GetForming()
+	_, err := store.GetForming(btcUSD, time.Minute, now)
+
+	// assert
+	if !errors.Is(err, ErrLookAhead) {
+		t.Errorf("err != ErrLookAhead: %v", err)
+	}
+}
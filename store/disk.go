@@ -0,0 +1,530 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/haydenhigg/chrys/frame"
+	"github.com/haydenhigg/chrys/market"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// file layout: a header of magic, version, interval and the time the file
+// covers frames since, followed by fixed-size frame records that each end in a
+// CRC32 of the record
+const (
+	DISK_CACHE_MAGIC   = "CHRYSFRM"
+	DISK_CACHE_VERSION = 1
+
+	diskHeaderSize = 8 + 2 + 8 + 8
+	diskRecordSize = 8 + 5*8 + 4
+)
+
+// a DiskCache is a FrameAPI that keeps every frame it retrieves from another
+// FrameAPI in an append-only file per pair and interval, so that only frames
+// after the end of the file are retrieved again after a restart. Files are
+// read into memory, where the newest frames are kept within Retention
+type DiskCache struct {
+	api       FrameAPI
+	Dir       string
+	Retention Retention
+	mu        sync.Mutex
+	locks     map[string]*sync.Mutex // per file, held while retrieving
+	series    map[string]*diskSeries
+	clock     uint64
+}
+
+type diskSeries struct {
+	since   time.Time      // the file has every frame from since to its last
+	frames  []*frame.Frame // set while holding both its file's lock and mu
+	trimmed bool           // frames only has the newest of the file's frames
+	used    uint64
+}
+
+func NewDiskCache(dir string, api FrameAPI) *DiskCache {
+	return &DiskCache{
+		api:    api,
+		Dir:    dir,
+		locks:  map[string]*sync.Mutex{},
+		series: map[string]*diskSeries{},
+	}
+}
+
+// setters
+func (cache *DiskCache) SetRetention(retention Retention) *DiskCache {
+	cache.mu.Lock()
+	cache.Retention = retention
+	paths := make([]string, 0, len(cache.series))
+	for path := range cache.series {
+		paths = append(paths, path)
+	}
+	cache.mu.Unlock()
+
+	// apply the new limits to everything already in memory
+	for _, path := range paths {
+		unlock := cache.lock(path)
+
+		cache.mu.Lock()
+		if _, ok := cache.series[path]; ok {
+			cache.retain(path)
+		}
+		cache.mu.Unlock()
+
+		unlock()
+	}
+
+	return cache
+}
+
+// lock the file at path, so that retrievals of other pairs and intervals
+// don't wait on each other
+func (cache *DiskCache) lock(path string) func() {
+	cache.mu.Lock()
+	lock, ok := cache.locks[path]
+	if !ok {
+		lock = &sync.Mutex{}
+		cache.locks[path] = lock
+	}
+	cache.mu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+func (cache *DiskCache) path(
+	pair market.Pair,
+	interval time.Duration,
+) string {
+	replacer := strings.NewReplacer("/", "-", "\\", "-", ":", "-")
+	name := replacer.Replace(pair.String())
+	return filepath.Join(cache.Dir, fmt.Sprintf("%s_%v.frames", name, interval))
+}
+
+// encoding
+func encodeHeader(interval time.Duration, since time.Time) []byte {
+	header := make([]byte, 0, diskHeaderSize)
+	header = append(header, DISK_CACHE_MAGIC...)
+	header = binary.LittleEndian.AppendUint16(header, DISK_CACHE_VERSION)
+	header = binary.LittleEndian.AppendUint64(header, uint64(interval))
+	header = binary.LittleEndian.AppendUint64(header, uint64(since.UnixNano()))
+
+	return header
+}
+
+func encodeFrames(frames []*frame.Frame) []byte {
+	records := make([]byte, 0, len(frames)*diskRecordSize)
+
+	for _, f := range frames {
+		start := len(records)
+		records = binary.LittleEndian.AppendUint64(
+			records,
+			uint64(f.Time.UnixNano()),
+		)
+
+		for _, v := range []float64{f.Open, f.High, f.Low, f.Close, f.Volume} {
+			bits := math.Float64bits(v)
+			records = binary.LittleEndian.AppendUint64(records, bits)
+		}
+
+		records = binary.LittleEndian.AppendUint32(
+			records,
+			crc32.ChecksumIEEE(records[start:]),
+		)
+	}
+
+	return records
+}
+
+func decodeFrame(record []byte) (*frame.Frame, bool) {
+	body, sum := record[:diskRecordSize-4], record[diskRecordSize-4:]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(sum) {
+		return nil, false
+	}
+
+	values := [5]float64{}
+	for i := range values {
+		bits := binary.LittleEndian.Uint64(body[8+i*8:])
+		values[i] = math.Float64frombits(bits)
+	}
+
+	return &frame.Frame{
+		Time:   time.Unix(0, int64(binary.LittleEndian.Uint64(body))),
+		Open:   values[0],
+		High:   values[1],
+		Low:    values[2],
+		Close:  values[3],
+		Volume: values[4],
+	}, true
+}
+
+// reading and writing
+var errBadHeader = errors.New("bad frame cache header")
+
+// read a file, keeping every record up to the first corrupt, torn or
+// out-of-order one; ok is false if the file had to be cut short
+func readSeries(
+	path string,
+	interval time.Duration,
+) (series *diskSeries, ok bool, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+
+	header := make([]byte, diskHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, false, errBadHeader
+	}
+
+	since := time.Unix(0, int64(binary.LittleEndian.Uint64(header[18:])))
+	if !bytes.Equal(header, encodeHeader(interval, since)) {
+		return nil, false, errBadHeader
+	}
+
+	series = &diskSeries{since: since, frames: []*frame.Frame{}}
+	record := make([]byte, diskRecordSize)
+
+	for {
+		_, err := io.ReadFull(reader, record)
+		if err == io.EOF {
+			return series, true, nil
+		} else if err != nil {
+			return series, false, nil // torn write
+		}
+
+		f, valid := decodeFrame(record)
+		if !valid {
+			return series, false, nil
+		}
+
+		n := len(series.frames)
+		if n > 0 && !f.Time.After(series.frames[n-1].Time) {
+			return series, false, nil
+		}
+
+		series.frames = append(series.frames, f)
+	}
+}
+
+// atomically replace a file with a series
+func writeSeries(
+	path string,
+	interval time.Duration,
+	series *diskSeries,
+) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	content := encodeHeader(interval, series.since)
+	content = append(content, encodeFrames(series.frames)...)
+	if _, err := temp.Write(content); err != nil {
+		temp.Close()
+		return err
+	}
+
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), path)
+}
+
+func appendFrames(path string, frames []*frame.Frame) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(encodeFrames(frames)); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// get a series with every frame of its file since a time, from memory or else
+// from the file, discarding a file with a bad header and rewriting one with
+// corrupt records (must hold the file's lock)
+func (cache *DiskCache) load(
+	pair market.Pair,
+	interval time.Duration,
+	since time.Time,
+) (*diskSeries, error) {
+	path := cache.path(pair, interval)
+
+	cache.mu.Lock()
+	series, ok := cache.series[path]
+	cache.mu.Unlock()
+
+	// frames that retention dropped from memory are read from the file again
+	if ok && !(series.trimmed && since.Before(series.frames[0].Time)) {
+		return series, nil
+	}
+
+	series, ok, err := readSeries(path, interval)
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, errBadHeader) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		if err := writeSeries(path, interval, series); err != nil {
+			return nil, err
+		}
+	}
+
+	return series, nil
+}
+
+// keep the frames of a series in memory, within the retention limits
+func (cache *DiskCache) keep(
+	path string,
+	series *diskSeries,
+	frames []*frame.Frame,
+) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	series.frames = frames
+	cache.series[path] = series
+
+	cache.clock++
+	series.used = cache.clock
+	cache.retain(path)
+}
+
+// drop the oldest frames of a series before index lo from memory (must hold
+// cache.mu and the file's lock)
+func (cache *DiskCache) keepSince(path string, lo int) {
+	if lo <= 0 {
+		return
+	}
+
+	// copy the kept frames so that the dropped ones can be garbage collected
+	series := cache.series[path]
+	series.frames = slices.Clone(series.frames[lo:])
+	series.trimmed = true
+}
+
+// enforce the retention limits after frames of a series were kept in memory
+// (must hold cache.mu and the file's lock)
+func (cache *DiskCache) retain(path string) {
+	retention := cache.Retention
+
+	// without a request to keep, only the oldest frames are dropped
+	if retention.MaxFrames > 0 || retention.MaxAge > 0 {
+		frames := cache.series[path].frames
+		lo, _ := keptRange(frames, retention.MaxFrames, retention.MaxAge, window{})
+		cache.keepSince(path, lo)
+	}
+
+	if retention.MaxTotalFrames <= 0 {
+		return
+	}
+
+	uses := make(map[string]seriesUse, len(cache.series))
+	for p, series := range cache.series {
+		uses[p] = seriesUse{len(series.frames), series.used}
+	}
+
+	// evict whole series, least recently used first
+	evicted, total := lruEvictions(uses, path, retention.MaxTotalFrames)
+	for _, p := range evicted {
+		delete(cache.series, p)
+	}
+
+	// trim the given series if it exceeds the budget on its own
+	if total > retention.MaxTotalFrames {
+		frames := cache.series[path].frames
+		lo, _ := keptRange(frames, retention.MaxTotalFrames, 0, window{})
+		cache.keepSince(path, lo)
+	}
+}
+
+func framesSince(frames []*frame.Frame, t time.Time) []*frame.Frame {
+	index, _ := findFrame(frames, t)
+	return slices.Clip(frames[index:])
+}
+
+func (cache *DiskCache) FetchFramesSince(
+	pair market.Pair,
+	interval time.Duration,
+	since time.Time,
+) ([]*frame.Frame, error) {
+	return cache.fetch(pair, interval, since, func(
+		since time.Time,
+	) ([]*frame.Frame, error) {
+		return cache.api.FetchFramesSince(pair, interval, since)
+	})
+}
+
+// retrieve frames since a time, from the file where possible and otherwise
+// with fetchSince
+func (cache *DiskCache) fetch(
+	pair market.Pair,
+	interval time.Duration,
+	since time.Time,
+	fetchSince func(since time.Time) ([]*frame.Frame, error),
+) ([]*frame.Frame, error) {
+	path := cache.path(pair, interval)
+
+	unlock := cache.lock(path)
+	defer unlock()
+
+	series, err := cache.load(pair, interval, since)
+	if err != nil {
+		return nil, err
+	}
+
+	// retrieve everything if the file doesn't go back far enough
+	if series == nil || since.Before(series.since) {
+		frames, err := fetchSince(since)
+		if err != nil {
+			return nil, err
+		} else if len(frames) == 0 {
+			return frames, nil
+		}
+
+		// the data source may return fewer frames than it has (Kraken only
+		// returns the latest 720), so the file only covers frames from since
+		// if the first retrieved frame is the first one after it
+		fetched := &diskSeries{since: since, frames: frames}
+		if first := frames[0].Time; first.After(ceilTime(since, interval)) {
+			fetched.since = first
+		}
+
+		if series != nil {
+			// the file can only be extended back to since if the retrieved
+			// frames reach its head; otherwise it is left as it is
+			end := frames[len(frames)-1].Time.Add(interval)
+			if end.Before(series.since) {
+				return framesSince(frames, since), nil
+			}
+
+			if series.since.Before(fetched.since) {
+				fetched.since = series.since
+			}
+
+			fetched.frames = mergeFrames(frames, series.frames)
+		}
+
+		if err := writeSeries(path, interval, fetched); err != nil {
+			return nil, err
+		}
+
+		merged := framesSince(fetched.frames, since)
+		cache.keep(path, fetched, fetched.frames)
+
+		return merged, nil
+	}
+
+	// otherwise only retrieve frames after the end of the file, even if since
+	// is later, so that the file stays contiguous
+	tail := series.since
+	if n := len(series.frames); n > 0 {
+		tail = series.frames[n-1].Time.Add(interval)
+	}
+
+	frames, err := fetchSince(tail)
+	if err != nil && !errors.Is(err, ErrNoData) {
+		return nil, err
+	}
+
+	// only append frames that are newer than the file's last frame
+	frames = framesSince(frames, tail)
+	if len(frames) > 0 {
+		if err := appendFrames(path, frames); err != nil {
+			return nil, err
+		}
+	}
+
+	appended := slices.Concat(series.frames, frames)
+	cache.keep(path, series, appended)
+
+	return framesSince(appended, since), nil
+}
+
+// a DiskCache of a FormingFrameAPI, which passes forming frames through
+// without writing them to the file
+type formingDiskCache struct {
+	*DiskCache
+}
+
+func (cache formingDiskCache) FetchFormingFramesSince(
+	pair market.Pair,
+	interval time.Duration,
+	since time.Time,
+) ([]*frame.Frame, *frame.Frame, error) {
+	live := cache.api.(FormingFrameAPI)
+
+	var forming *frame.Frame
+	frames, err := cache.fetch(pair, interval, since, func(
+		since time.Time,
+	) ([]*frame.Frame, error) {
+		frames, f, err := live.FetchFormingFramesSince(pair, interval, since)
+		forming = f
+
+		return frames, err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return frames, forming, nil
+}
+
+// wrap an api in a DiskCache that keeps its ability to retrieve forming frames
+func newCachedAPI(dir string, api FrameAPI) FrameAPI {
+	cache := NewDiskCache(dir, api)
+	if _, ok := api.(FormingFrameAPI); ok {
+		return formingDiskCache{cache}
+	}
+
+	return cache
+}
+
+// get the DiskCache that wraps an api, if any
+func diskCacheOf(api FrameAPI) (*DiskCache, bool) {
+	switch cache := api.(type) {
+	case *DiskCache:
+		return cache, true
+	case formingDiskCache:
+		return cache.DiskCache, true
+	}
+
+	return nil, false
+}
+
+// get the api beneath a DiskCache, if any
+func uncachedAPI(api FrameAPI) FrameAPI {
+	switch cache := api.(type) {
+	case *DiskCache:
+		return cache.api
+	case formingDiskCache:
+		return cache.api
+	}
+
+	return api
+}
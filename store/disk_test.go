@@ -0,0 +1,287 @@
+package store
+
+import (
+	"github.com/haydenhigg/chrys/frame"
+	"github.com/haydenhigg/chrys/market"
+	"os"
+	"testing"
+	"time"
+)
+
+var (
+	btcUSD = market.NewPair("BTC", "USD")
+	ethUSD = market.NewPair("ETH", "USD")
+	solUSD = market.NewPair("SOL", "USD")
+)
+
+func Test_DiskCacheReusedAfterRestart(t *testing.T) {
+	// set up mock
+	dir := t.TempDir()
+	now := time.Now().Truncate(time.Hour)
+	start := now.Add(-3 * time.Hour)
+	sinces := []time.Time{}
+	api := newClockedAPI(&now, &sinces)
+
+	// FetchFramesSince()
+	_, err := NewDiskCache(dir, api).FetchFramesSince(btcUSD, time.Hour, start)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// FetchFramesSince() from a new cache after time passes
+	tail := now
+	now = now.Add(2 * time.Hour)
+	frames, err := NewDiskCache(dir, api).FetchFramesSince(
+		btcUSD,
+		time.Hour,
+		start.Add(time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	assertFrameTimesEqual(frames, []*frame.Frame{
+		{Time: start.Add(time.Hour)},
+		{Time: start.Add(2 * time.Hour)},
+		{Time: start.Add(3 * time.Hour)},
+		{Time: start.Add(4 * time.Hour)},
+	}, t)
+
+	if len(sinces) != 2 {
+		t.Fatalf("len(sinces) != 2: %d", len(sinces))
+	}
+
+	if !sinces[1].Equal(tail) {
+		t.Errorf("tail since != %v: %v", tail, sinces[1])
+	}
+}
+
+func Test_DiskCacheRefetchHead(t *testing.T) {
+	// set up mock
+	dir := t.TempDir()
+	now := time.Now().Truncate(time.Hour)
+	sinces := []time.Time{}
+	cache := NewDiskCache(dir, newClockedAPI(&now, &sinces))
+
+	// FetchFramesSince()
+	cache.FetchFramesSince(btcUSD, time.Hour, now.Add(-2*time.Hour))
+
+	// FetchFramesSince() with a longer lookback
+	frames, err := cache.FetchFramesSince(btcUSD, time.Hour, now.Add(-4*time.Hour))
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if len(frames) != 4 {
+		t.Errorf("len(frames) != 4: %d", len(frames))
+	}
+
+	if len(sinces) != 2 || !sinces[1].Equal(now.Add(-4*time.Hour)) {
+		t.Errorf("head since != now-4h: %v", sinces)
+	}
+}
+
+func Test_DiskCacheCorruptRecord(t *testing.T) {
+	// set up mock
+	dir := t.TempDir()
+	now := time.Now().Truncate(time.Hour)
+	start := now.Add(-3 * time.Hour)
+	sinces := []time.Time{}
+	api := newClockedAPI(&now, &sinces)
+
+	cache := NewDiskCache(dir, api)
+	cache.FetchFramesSince(btcUSD, time.Hour, start)
+
+	// corrupt the second record
+	path := cache.path(btcUSD, time.Hour)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	content[diskHeaderSize+diskRecordSize+8] ^= 0xff
+	os.WriteFile(path, content, 0644)
+
+	// FetchFramesSince() from a new cache
+	frames, err := NewDiskCache(dir, api).FetchFramesSince(btcUSD, time.Hour, start)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if len(frames) != 3 {
+		t.Errorf("len(frames) != 3: %d", len(frames))
+	}
+
+	if len(sinces) != 2 || !sinces[1].Equal(start.Add(time.Hour)) {
+		t.Errorf("tail since != start+1h: %v", sinces)
+	}
+
+	if info, _ := os.Stat(path); info.Size() != diskHeaderSize+3*diskRecordSize {
+		t.Errorf("file size != 3 records: %d", info.Size())
+	}
+}
+
+func Test_DiskCacheBadHeader(t *testing.T) {
+	// set up mock
+	dir := t.TempDir()
+	now := time.Now().Truncate(time.Hour)
+	start := now.Add(-3 * time.Hour)
+	sinces := []time.Time{}
+	api := newClockedAPI(&now, &sinces)
+
+	cache := NewDiskCache(dir, api)
+	cache.FetchFramesSince(btcUSD, time.Hour, start)
+	os.WriteFile(cache.path(btcUSD, time.Hour), []byte("garbage"), 0644)
+
+	// FetchFramesSince() from a new cache
+	frames, err := NewDiskCache(dir, api).FetchFramesSince(btcUSD, time.Hour, start)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if len(frames) != 3 {
+		t.Errorf("len(frames) != 3: %d", len(frames))
+	}
+
+	if len(sinces) != 2 || !sinces[1].Equal(start) {
+		t.Errorf("since != start: %v", sinces)
+	}
+}
+
+func Test_SetCacheDir(t *testing.T) {
+	// set up mock
+	dir := t.TempDir()
+	now := time.Now().Truncate(time.Hour)
+	sinces := []time.Time{}
+	api := newClockedAPI(&now, &sinces)
+
+	// GetNBefore() from two stores
+	NewFrames(api).SetCacheDir(dir).GetNBefore(btcUSD, time.Hour, 3, now)
+	frames, err := NewFrames(api).
+		SetCacheDir(dir).
+		GetNBefore(btcUSD, time.Hour, 3, now)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if len(frames) != 3 {
+		t.Errorf("len(frames) != 3: %d", len(frames))
+	}
+
+	if len(sinces) != 2 || !sinces[1].Equal(now) {
+		t.Errorf("second since != now: %v", sinces)
+	}
+}
+
+func Test_DiskCacheCappedSource(t *testing.T) {
+	// set up mock that only returns the latest 3 frames
+	dir := t.TempDir()
+	now := time.Now().Truncate(time.Hour)
+	sinces := []time.Time{}
+	clocked := newClockedAPI(&now, &sinces)
+	cache := NewDiskCache(dir, FuncFrameAPI(func(
+		pair market.Pair,
+		interval time.Duration,
+		since time.Time,
+	) ([]*frame.Frame, error) {
+		frames, err := clocked(pair, interval, since)
+		return frames[max(len(frames)-3, 0):], err
+	}))
+
+	// FetchFramesSince() twice with a lookback longer than the cap
+	start := now.Add(-10 * time.Hour)
+	cache.FetchFramesSince(btcUSD, time.Hour, start)
+	frames, err := cache.FetchFramesSince(btcUSD, time.Hour, start)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert (the file doesn't cover the frames the source left out)
+	if len(frames) != 3 {
+		t.Errorf("len(frames) != 3: %d", len(frames))
+	}
+
+	if len(sinces) != 2 || !sinces[1].Equal(start) {
+		t.Errorf("head since != %v: %v", start, sinces)
+	}
+}
+
+func Test_DiskCacheLockedPerFile(t *testing.T) {
+	// set up mock whose BTC/USD frames wait to be released
+	now := time.Now().Truncate(time.Hour)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	cache := NewDiskCache(t.TempDir(), FuncFrameAPI(func(
+		pair market.Pair,
+		interval time.Duration,
+		since time.Time,
+	) ([]*frame.Frame, error) {
+		if pair == btcUSD {
+			close(started)
+			<-release
+		}
+
+		return []*frame.Frame{{Time: since}}, nil
+	}))
+
+	// FetchFramesSince() for another pair while BTC/USD is retrieving
+	retrieved := make(chan struct{})
+	go func() {
+		defer close(retrieved)
+		cache.FetchFramesSince(btcUSD, time.Hour, now)
+	}()
+	<-started
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cache.FetchFramesSince(market.NewPair("ETH", "USD"), time.Hour, now)
+	}()
+
+	// assert
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("ETH/USD waited on BTC/USD")
+	}
+
+	close(release)
+	<-retrieved
+}
+
+func Test_DiskCacheRetention(t *testing.T) {
+	// set up mock
+	dir := t.TempDir()
+	now := time.Now().Truncate(time.Hour)
+	sinces := []time.Time{}
+	cache := NewDiskCache(dir, newClockedAPI(&now, &sinces)).
+		SetRetention(Retention{MaxFrames: 2})
+
+	// FetchFramesSince() twice
+	start := now.Add(-5 * time.Hour)
+	cache.FetchFramesSince(btcUSD, time.Hour, start)
+	kept := len(cache.series[cache.path(btcUSD, time.Hour)].frames)
+
+	frames, err := cache.FetchFramesSince(btcUSD, time.Hour, start)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert (dropped frames are read from the file again)
+	if kept != 2 {
+		t.Errorf("kept != 2: %d", kept)
+	}
+
+	if len(frames) != 5 {
+		t.Errorf("len(frames) != 5: %d", len(frames))
+	}
+
+	if len(sinces) != 2 || !sinces[1].Equal(now) {
+		t.Errorf("tail since != now: %v", sinces)
+	}
+}
@@ -0,0 +1,14 @@
+package store
+
+import "github.com/haydenhigg/chrys/frame"
+
+// the errors are defined in the frame package so that drivers can return them
+// without depending on the stores
+var (
+	ErrNoData             = frame.ErrNoData
+	ErrInsufficientFrames = frame.ErrInsufficientFrames
+	ErrNoForming          = frame.ErrNoForming
+	ErrLookAhead          = frame.ErrLookAhead
+)
+
+type InsufficientFramesError = frame.InsufficientFramesError
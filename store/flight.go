@@ -0,0 +1,44 @@
+package store
+
+import "sync"
+
+// a flightGroup deduplicates simultaneous calls that share a key, so that only
+// the first caller does the work while the rest wait for its result
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+type flight struct {
+	done chan struct{}
+	err  error
+}
+
+// run fn once per key at a time; shared reports whether the caller waited on
+// another caller's fn instead of running its own
+func (group *flightGroup) do(key string, fn func() error) (bool, error) {
+	group.mu.Lock()
+	if group.calls == nil {
+		group.calls = map[string]*flight{}
+	}
+
+	if call, ok := group.calls[key]; ok {
+		group.mu.Unlock()
+		<-call.done
+
+		return true, call.err
+	}
+
+	call := &flight{done: make(chan struct{})}
+	group.calls[key] = call
+	group.mu.Unlock()
+
+	call.err = fn()
+
+	group.mu.Lock()
+	delete(group.calls, key)
+	group.mu.Unlock()
+	close(call.done)
+
+	return false, call.err
+}
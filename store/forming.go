@@ -0,0 +1,147 @@
+package store
+
+import (
+	"fmt"
+	"github.com/haydenhigg/chrys/frame"
+	"github.com/haydenhigg/chrys/market"
+	"time"
+)
+
+// replace the forming frame of a pair and interval, unless a closed frame has
+// already replaced it (must hold store.mu)
+func (store *FrameStore) setForming(
+	pair market.Pair,
+	interval time.Duration,
+	forming *frame.Frame,
+) {
+	pair = cacheKey(pair)
+
+	frames := store.Cache[pair][interval]
+	if forming == nil ||
+		len(frames) > 0 && !forming.Time.After(frames[len(frames)-1].Time) {
+		delete(store.forming[pair], interval)
+		return
+	}
+
+	if _, ok := store.forming[pair]; !ok {
+		store.forming[pair] = map[time.Duration]*frame.Frame{}
+	}
+
+	store.forming[pair][interval] = forming
+}
+
+// replace the forming frame of a pair and interval, as pushed by a stream
+func (store *FrameStore) SetForming(
+	pair market.Pair,
+	interval time.Duration,
+	forming *frame.Frame,
+) *FrameStore {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.setForming(pair, interval, forming)
+
+	return store
+}
+
+// get the forming frame of a pair and interval as of the last retrieval,
+// without retrieving anything
+func (store *FrameStore) Forming(
+	pair market.Pair,
+	interval time.Duration,
+) (*frame.Frame, bool) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	forming, ok := store.forming[cacheKey(pair)][interval]
+	return forming, ok
+}
+
+// retrieve the frame of a pair and interval that is forming at t, along with
+// any closed frames since the end of the cache; the forming frame is never
+// added to the closed frames
+func (store *FrameStore) GetForming(
+	pair market.Pair,
+	interval time.Duration,
+	t time.Time,
+) (*frame.Frame, error) {
+	// a forming frame would include trades after the simulated time
+	if _, ok := store.horizon(interval); ok {
+		return nil, fmt.Errorf("%w for %s at %v", ErrLookAhead, pair, interval)
+	}
+
+	if base, ok := store.getBaseInterval(interval); ok {
+		return store.getResampledForming(pair, interval, base, t)
+	}
+
+	return store.getForming(pair, interval, t, t.Truncate(interval))
+}
+
+// retrieve the frame that is forming at t, and make sure that the cache covers
+// every closed frame since a time
+func (store *FrameStore) getForming(
+	pair market.Pair,
+	interval time.Duration,
+	t, since time.Time,
+) (*frame.Frame, error) {
+	store.mu.RLock()
+	_, isLive := store.api.(FormingFrameAPI)
+	span, covered := store.coverage[cacheKey(pair)][interval]
+	store.mu.RUnlock()
+
+	if !isLive {
+		return nil, fmt.Errorf("%w for %s at %v", ErrNoForming, pair, interval)
+	}
+
+	// continue from the end of the cache if it covers since, so that it stays
+	// contiguous
+	if covered && !since.Before(span.Start) && !since.After(span.End) {
+		since = span.End
+	}
+
+	key := pair.String() + "|" + interval.String()
+	_, err := store.flights.do(key, func() error {
+		return store.fetch(pair, interval, since, window{start: since})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	forming, ok := store.Forming(pair, interval)
+	if !ok || forming.Time.Before(t.Truncate(interval)) {
+		return nil, fmt.Errorf("%w for %s at %v", ErrNoForming, pair, interval)
+	}
+
+	return forming, nil
+}
+
+// build the forming frame of interval from the closed and forming frames of a
+// shorter base interval
+func (store *FrameStore) getResampledForming(
+	pair market.Pair,
+	interval, base time.Duration,
+	t time.Time,
+) (*frame.Frame, error) {
+	start := t.Truncate(interval)
+
+	forming, err := store.getForming(pair, base, t, start)
+	if err != nil {
+		return nil, err
+	}
+
+	// get the closed frames of the bucket that precede the forming frame
+	frames := []*frame.Frame{}
+	if forming.Time.After(start) {
+		closed, err := store.get(pair, base, start, forming.Time)
+		if err != nil {
+			return nil, err
+		}
+
+		frames = append(frames, closed...)
+	}
+
+	frames = append(frames, forming)
+	resampled := frame.Resample(frames, interval)
+
+	return resampled[len(resampled)-1], nil
+}
@@ -0,0 +1,168 @@
+package store
+
+import (
+	"errors"
+	"github.com/haydenhigg/chrys/frame"
+	"github.com/haydenhigg/chrys/market"
+	"testing"
+	"time"
+)
+
+// mock
+type LiveFrameAPI struct {
+	FuncFrameAPI
+	now *time.Time
+}
+
+func (api LiveFrameAPI) FetchFormingFramesSince(
+	pair market.Pair,
+	interval time.Duration,
+	since time.Time,
+) ([]*frame.Frame, *frame.Frame, error) {
+	frames, err := api.FetchFramesSince(pair, interval, since)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	t := api.now.Truncate(interval)
+	return frames, &frame.Frame{Time: t, Close: -float64(t.Unix())}, nil
+}
+
+func newLiveAPI(now *time.Time, sinces *[]time.Time) LiveFrameAPI {
+	return LiveFrameAPI{newClockedAPI(now, sinces), now}
+}
+
+// tests
+func Test_GetForming(t *testing.T) {
+	// set up store
+	now := time.Now().Truncate(time.Hour).Add(30 * time.Minute)
+	sinces := []time.Time{}
+	store := NewFrames(newLiveAPI(&now, &sinces))
+
+	// GetForming()
+	forming, err := store.GetForming(btcUSD, time.Hour, now)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if start := now.Truncate(time.Hour); !forming.Time.Equal(start) {
+		t.Errorf("forming.Time != %v: %v", start, forming.Time)
+	}
+
+	if n := len(store.Cache[btcUSD][time.Hour]); n != 0 {
+		t.Errorf("len(closed frames) != 0: %d", n)
+	}
+
+	cached, ok := store.Forming(btcUSD, time.Hour)
+	if !ok || cached != forming {
+		t.Errorf("Forming() != forming: %v", cached)
+	}
+}
+
+func Test_GetFormingAfterClose(t *testing.T) {
+	// set up store
+	now := time.Now().Truncate(time.Hour).Add(30 * time.Minute)
+	sinces := []time.Time{}
+	store := NewFrames(newLiveAPI(&now, &sinces))
+
+	// GetForming() before and after the forming frame closes
+	first, _ := store.GetForming(btcUSD, time.Hour, now)
+
+	now = now.Add(time.Hour)
+	frames, err := store.GetNBefore(btcUSD, time.Hour, 1, now)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	assertFrameTimesEqual(frames, []*frame.Frame{{Time: first.Time}}, t)
+
+	if frames[0].Close < 0 {
+		t.Errorf("closed frame is the forming frame: %v", frames[0].Close)
+	}
+
+	forming, ok := store.Forming(btcUSD, time.Hour)
+	if !ok || !forming.Time.Equal(first.Time.Add(time.Hour)) {
+		t.Errorf("Forming() was not updated: %v", forming)
+	}
+
+	if !sinces[1].Equal(first.Time) {
+		t.Errorf("since != %v: %v", first.Time, sinces[1])
+	}
+}
+
+func Test_GetFormingUnsupported(t *testing.T) {
+	// set up store
+	now := time.Now()
+	sinces := []time.Time{}
+	store := NewFrames(newClockedAPI(&now, &sinces))
+
+	// GetForming()
+	_, err := store.GetForming(btcUSD, time.Hour, now)
+
+	// assert
+	if !errors.Is(err, ErrNoForming) {
+		t.Errorf("err != ErrNoForming: %v", err)
+	}
+
+	if len(sinces) != 0 {
+		t.Errorf("len(sinces) != 0: %d", len(sinces))
+	}
+}
+
+func Test_GetFormingResampled(t *testing.T) {
+	// set up store
+	now := time.Now().Truncate(4 * time.Hour).Add(2*time.Hour + 30*time.Minute)
+	sinces := []time.Time{}
+	store := NewFrames(newLiveAPI(&now, &sinces)).SetBaseInterval(time.Hour)
+
+	// GetForming()
+	forming, err := store.GetForming(btcUSD, 4*time.Hour, now)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	start := now.Truncate(4 * time.Hour)
+	if !forming.Time.Equal(start) {
+		t.Errorf("forming.Time != %v: %v", start, forming.Time)
+	}
+
+	if len(sinces) != 1 || !sinces[0].Equal(start) {
+		t.Errorf("sinces != [%v]: %v", start, sinces)
+	}
+
+	if close := -float64(now.Truncate(time.Hour).Unix()); forming.Close != close {
+		t.Errorf("forming.Close != %v: %v", close, forming.Close)
+	}
+}
+
+func Test_GetFormingCacheDir(t *testing.T) {
+	// set up store
+	dir := t.TempDir()
+	now := time.Now().Truncate(time.Hour).Add(30 * time.Minute)
+	sinces := []time.Time{}
+	api := newLiveAPI(&now, &sinces)
+
+	// GetForming() and then GetNBefore() from a new store
+	_, err := NewFrames(api).
+		SetCacheDir(dir).
+		GetForming(btcUSD, time.Hour, now)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	frames, err := NewFrames(api).
+		SetCacheDir(dir).
+		GetNBefore(btcUSD, time.Hour, 1, now)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if frames[0].Close < 0 {
+		t.Errorf("forming frame was written to disk: %v", frames[0].Close)
+	}
+}
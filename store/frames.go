@@ -1,141 +1,501 @@
 package store
 
 import (
+	"errors"
+	"fmt"
 	"github.com/haydenhigg/chrys/frame"
+	"github.com/haydenhigg/chrys/market"
+	"slices"
+	"sync"
 	"time"
 )
 
 type FrameAPI interface {
 	FetchFramesSince(
-		pair string,
+		pair market.Pair,
 		interval time.Duration,
 		since time.Time,
 	) ([]*frame.Frame, error)
 }
 
+// a FrameAPI that can also retrieve the frame that is still forming, which is
+// returned separately from the closed frames
+type FormingFrameAPI interface {
+	FrameAPI
+	FetchFormingFramesSince(
+		pair market.Pair,
+		interval time.Duration,
+		since time.Time,
+	) ([]*frame.Frame, *frame.Frame, error)
+}
+
+type AnomalyHandler = func(
+	pair market.Pair,
+	interval time.Duration,
+	anomalies []frame.Anomaly,
+)
+
 type PartialFrameCache = map[time.Duration][]*frame.Frame
-type FrameCache = map[string]PartialFrameCache
+type FrameCache = map[market.Pair]PartialFrameCache // keyed without symbols
+
+// the key of a pair in a FrameStore's maps, which is the same whether or not
+// the pair has a symbol
+func cacheKey(pair market.Pair) market.Pair {
+	return pair.WithSymbol("")
+}
+
+// the time range [Start, End) over which the cache holds every frame that the
+// data source has
+type Coverage struct {
+	Start time.Time
+	End   time.Time
+}
 
+// a FrameStore is safe for concurrent use; Cache should only be accessed
+// directly when no other goroutine is using the store
 type FrameStore struct {
-	api   FrameAPI
-	Cache map[string]PartialFrameCache
+	api           FrameAPI
+	Cache         map[market.Pair]PartialFrameCache
+	PriceInterval time.Duration // the frame interval used to look up prices
+	AllowPartial  bool          // GetNBefore returns fewer frames than asked
+	BaseInterval  time.Duration // longer intervals are resampled from this one
+	RepairPolicy  frame.RepairPolicy
+	onAnomalies   AnomalyHandler
+	mu            sync.RWMutex
+	flights       flightGroup
+	coverage      map[market.Pair]map[time.Duration]Coverage
+	forming       map[market.Pair]map[time.Duration]*frame.Frame
+	Retention     Retention
+	usage         usage
+	now           func() time.Time // the simulated time, if any
 }
 
 func NewFrames(api FrameAPI) *FrameStore {
 	return &FrameStore{
-		api:   api,
-		Cache: FrameCache{},
+		api:           api,
+		Cache:         FrameCache{},
+		PriceInterval: time.Minute,
+		coverage:      map[market.Pair]map[time.Duration]Coverage{},
+		forming:       map[market.Pair]map[time.Duration]*frame.Frame{},
 	}
 }
 
-// binary search a sorted frame slice for the first frame that starts at/after t
-func searchFrames(frames []*frame.Frame, t time.Time) int {
-	low, high := 0, len(frames)-1
-	epochs := 0
+// setters
+func (store *FrameStore) SetPriceInterval(interval time.Duration) *FrameStore {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	// a non-positive interval would make every price lookup miss, so it is
+	// ignored
+	if interval > 0 {
+		store.PriceInterval = interval
+	}
+
+	return store
+}
+
+func (store *FrameStore) SetAllowPartial(allowPartial bool) *FrameStore {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.AllowPartial = allowPartial
+
+	return store
+}
+
+// keep retrieved frames in files under dir that persist between runs
+func (store *FrameStore) SetCacheDir(dir string) *FrameStore {
+	store.mu.Lock()
+	defer store.mu.Unlock()
 
-	for high-low > 0 {
-		epochs++
-		if frames[low].Time.Equal(t) {
-			return low
-		} else if frames[high].Time.Equal(t) {
-			return high
+	store.api = newCachedAPI(dir, uncachedAPI(store.api))
+
+	// frames read from the files are kept within the same limits
+	cache, _ := diskCacheOf(store.api)
+	cache.SetRetention(store.Retention)
+
+	return store
+}
+
+// derive frames of every multiple of interval from the cached frames of
+// interval, rather than retrieving each interval separately; 0 disables this
+func (store *FrameStore) SetBaseInterval(interval time.Duration) *FrameStore {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.BaseInterval = max(interval, 0)
+
+	return store
+}
+
+// repair retrieved frames before caching them
+func (store *FrameStore) SetRepairPolicy(policy frame.RepairPolicy) *FrameStore {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.RepairPolicy = policy
+
+	return store
+}
+
+// report anomalies in retrieved frames, before they are repaired
+func (store *FrameStore) OnAnomalies(handler AnomalyHandler) *FrameStore {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.onAnomalies = handler
+
+	return store
+}
+
+// validate and repair retrieved frames, continuing from the last cached frame
+// before them so that gaps between the cache and the new frames are also found
+func (store *FrameStore) repair(
+	pair market.Pair,
+	interval time.Duration,
+	frames []*frame.Frame,
+) []*frame.Frame {
+	store.mu.RLock()
+	policy, handler := store.RepairPolicy, store.onAnomalies
+
+	var prior *frame.Frame
+	if len(frames) > 0 {
+		cached := store.Cache[cacheKey(pair)][interval]
+		if index, _ := findFrame(cached, frames[0].Time); index > 0 {
+			prior = cached[index-1]
 		}
+	}
+	store.mu.RUnlock()
+
+	if policy == frame.NoRepair && handler == nil {
+		return frames
+	}
+
+	if prior != nil {
+		frames = append([]*frame.Frame{prior}, frames...)
+	}
 
-		if high-low == 1 {
-			if frames[low].Time.Before(t) && frames[high].Time.After(t) {
-				return high
-			} else {
-				break
+	if handler != nil {
+		anomalies := frame.Validate(frames, interval)
+
+		// index anomalies into the retrieved frames
+		if prior != nil {
+			for i := range anomalies {
+				anomalies[i].Index--
 			}
 		}
 
-		midIndex := (low + high) / 2
-
-		if frames[midIndex].Time.Before(t) {
-			low = midIndex
-		} else if frames[midIndex].Time.After(t) {
-			high = midIndex
-		} else {
-			return midIndex
+		if len(anomalies) > 0 {
+			handler(pair, interval, anomalies)
 		}
 	}
 
-	return -1
+	frames = frame.Repair(frames, interval, policy)
+
+	if prior != nil && len(frames) > 0 && frames[0] == prior {
+		frames = frames[1:]
+	}
+
+	return frames
 }
 
-func (store *FrameStore) getCachedSince(
-	pair string,
+// get the interval to resample a given interval from, if any
+func (store *FrameStore) getBaseInterval(
 	interval time.Duration,
-	t time.Time,
-) ([]*frame.Frame, bool) {
-	// check if pair is in cache
-	if _, ok := store.Cache[pair]; !ok {
-		return nil, false
+) (time.Duration, bool) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	base := store.BaseInterval
+	if base <= 0 || interval <= base || interval%base != 0 {
+		return 0, false
 	}
 
-	// check if interval is in pair's partial cache
-	frames, ok := store.Cache[pair][interval]
-	if !ok || len(frames) == 0 {
-		return nil, false
+	return base, true
+}
+
+func (store *FrameStore) getAPI() FrameAPI {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	return store.api
+}
+
+func (store *FrameStore) isPartialAllowed() bool {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	return store.AllowPartial
+}
+
+// binary search a sorted frame slice for the first frame that starts at/after
+// t, and whether it starts exactly at t
+func findFrame(frames []*frame.Frame, t time.Time) (int, bool) {
+	return slices.BinarySearchFunc(frames, t, func(f *frame.Frame, t time.Time) int {
+		return f.Time.Compare(t)
+	})
+}
+
+// check whether the cache covers [start, end) (or [start, ...) if end is zero)
+// and, if it does not, determine the time to retrieve frames since
+func (store *FrameStore) getMissingSince(
+	pair market.Pair,
+	interval time.Duration,
+	start, end time.Time,
+) (time.Time, bool) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	span, ok := store.coverage[cacheKey(pair)][interval]
+	if !ok || start.Before(span.Start) {
+		// missing the head (or everything)
+		return start, false
+	} else if end.IsZero() && !start.Before(span.End) {
+		// missing everything after the cached tail
+		return start, false
+	} else if end.After(span.End) {
+		// missing the tail
+		return maxTime(start, span.End), false
 	}
 
-	// chop off older frames
-	if index := searchFrames(frames, t); index > -1 {
-		return frames[index:], true
+	return time.Time{}, true
+}
+
+// get cached frames that start in [start, end) (or [start, ...) if end is zero)
+func (store *FrameStore) getCached(
+	pair market.Pair,
+	interval time.Duration,
+	start, end time.Time,
+) []*frame.Frame {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	frames := store.Cache[cacheKey(pair)][interval]
+
+	startIndex, _ := findFrame(frames, start)
+	endIndex := len(frames)
+	if !end.IsZero() {
+		endIndex, _ = findFrame(frames, end)
 	}
 
-	return nil, false
+	// clipped so that appending to the result can't overwrite the cache
+	return slices.Clip(frames[startIndex:max(startIndex, endIndex)])
 }
 
-func (store *FrameStore) GetSince(
-	pair string,
+// retrieve frames since t from the data source and cache them, keeping the
+// needed ones
+func (store *FrameStore) fetch(
+	pair market.Pair,
 	interval time.Duration,
 	t time.Time,
+	needed window,
+) error {
+	api := store.getAPI()
+
+	var (
+		frames  []*frame.Frame
+		forming *frame.Frame
+		err     error
+	)
+
+	// the data source gets the pair with its symbol, if it has one
+	live, isLive := api.(FormingFrameAPI)
+	if isLive {
+		frames, forming, err = live.FetchFormingFramesSince(pair, interval, t)
+	} else {
+		frames, err = api.FetchFramesSince(pair, interval, t)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// frames that haven't closed by the simulated time are never cached
+	if horizon, ok := store.horizon(interval); ok {
+		index, _ := findFrame(frames, horizon)
+		frames, forming = frames[:index], nil
+	}
+
+	frames = store.repair(pair, interval, frames)
+
+	// the data source has no frames between t and the first retrieved frame,
+	// so the cache covers everything from t to the end of the last one
+	store.mu.Lock()
+	store.set(pair, interval, frames, t, needed)
+	if isLive {
+		store.setForming(pair, interval, forming)
+	}
+	store.mu.Unlock()
+
+	return nil
+}
+
+func ceilTime(t time.Time, d time.Duration) time.Time {
+	if truncated := t.Truncate(d); truncated.Before(t) {
+		return truncated.Add(d)
+	}
+
+	return t
+}
+
+// get frames of interval by aggregating frames of a shorter base interval
+func (store *FrameStore) getResampled(
+	pair market.Pair,
+	interval, base time.Duration,
+	start, end time.Time,
 ) ([]*frame.Frame, error) {
-	// check cache
-	if frames, ok := store.getCachedSince(pair, interval, t); ok {
-		return frames, nil
+	// a bucket is in [start, end) if it starts in it, but it contains frames
+	// up to a whole interval later
+	start = ceilTime(start, interval)
+	if !end.IsZero() {
+		end = ceilTime(end, interval)
 	}
 
-	// retrieve from data source
-	frames, err := store.api.FetchFramesSince(pair, interval, t)
+	frames, err := store.get(pair, base, start, end)
 	if err != nil {
 		return nil, err
 	}
 
-	// cache retrieved data
-	store.Set(pair, interval, frames)
+	// only keep buckets that the base interval's cache covers entirely
+	span, _ := store.Covered(pair, base)
+	resampled := []*frame.Frame{}
 
-	return frames, nil
+	for _, bucket := range frame.Resample(frames, interval) {
+		if bucket.Time.Before(span.Start) {
+			continue
+		} else if bucket.Time.Add(interval).After(span.End) {
+			break
+		}
+
+		resampled = append(resampled, bucket)
+	}
+
+	return resampled, nil
+}
+
+func (store *FrameStore) get(
+	pair market.Pair,
+	interval time.Duration,
+	start, end time.Time,
+) ([]*frame.Frame, error) {
+	end, err := store.bound(pair, interval, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	// an open-ended request wants every frame that has closed by now, so the
+	// cache only covers it up to the latest one
+	if latest := time.Now().Truncate(interval); end.IsZero() &&
+		latest.After(start) {
+		end = latest
+	}
+
+	if base, ok := store.getBaseInterval(interval); ok {
+		return store.getResampled(pair, interval, base, start, end)
+	}
+
+	key := pair.String() + "|" + interval.String()
+
+	for {
+		// check cache
+		since, ok := store.getMissingSince(pair, interval, start, end)
+		store.touch(pair, interval, ok)
+		if ok {
+			return store.getCached(pair, interval, start, end), nil
+		}
+
+		// retrieve missing frames from data source, or wait for a simultaneous
+		// retrieval of the same pair and interval and then check the cache
+		// again
+		shared, err := store.flights.do(key, func() error {
+			return store.fetch(pair, interval, since, window{start, end})
+		})
+		if errors.Is(err, ErrNoData) && since.After(start) {
+			// the data source has nothing newer than the cached tail, like
+			// historical data that ends before now
+			return store.getCached(pair, interval, start, end), nil
+		} else if err != nil {
+			return nil, err
+		} else if !shared {
+			return store.getCached(pair, interval, start, end), nil
+		}
+	}
+}
+
+func (store *FrameStore) GetSince(
+	pair market.Pair,
+	interval time.Duration,
+	t time.Time,
+) ([]*frame.Frame, error) {
+	return store.get(pair, interval, t, time.Time{})
+}
+
+// get frames that start in [start, end), retrieving only the frames that are
+// missing from the cache
+func (store *FrameStore) GetBetween(
+	pair market.Pair,
+	interval time.Duration,
+	start, end time.Time,
+) ([]*frame.Frame, error) {
+	if !end.After(start) {
+		return []*frame.Frame{}, nil
+	}
+
+	return store.get(pair, interval, start, end)
 }
 
 func (store *FrameStore) GetNBefore(
-	pair string,
+	pair market.Pair,
 	interval time.Duration,
 	n int,
 	t time.Time,
 ) ([]*frame.Frame, error) {
-	t = t.Truncate(interval).Add(time.Duration(-n) * interval)
+	if n <= 0 {
+		return nil, fmt.Errorf("non-positive frame count %d", n)
+	}
+
+	end := t.Truncate(interval)
+	start := end.Add(time.Duration(-n) * interval)
 
-	frames, err := store.GetSince(pair, interval, t)
+	frames, err := store.GetBetween(pair, interval, start, end)
 	if err != nil {
 		return nil, err
 	}
 
-	return frames[:n], nil
+	// keep the latest n
+	frames = frames[max(len(frames)-n, 0):]
+
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("%w for %s at %v", ErrNoData, pair, interval)
+	}
+
+	if len(frames) < n && !store.isPartialAllowed() {
+		return nil, &InsufficientFramesError{
+			Pair:      pair,
+			Interval:  interval,
+			Requested: n,
+			Available: len(frames),
+		}
+	}
+
+	return frames, nil
 }
 
 func (store *FrameStore) getCachedPriceAt(
-	pair string,
+	pair market.Pair,
 	t time.Time,
 ) (float64, bool) {
-	// check all cached intervals to find a Close price for the given time
-	if intervalFrames, ok := store.Cache[pair]; ok {
-		frameTime := t.Truncate(time.Minute)
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	// check all cached intervals to find a frame that closed exactly at the
+	// start of the price interval containing t
+	if intervalFrames, ok := store.Cache[cacheKey(pair)]; ok {
+		frameTime := t.Truncate(store.PriceInterval)
 
 		for interval, frames := range intervalFrames {
 			priorFrameTime := frameTime.Add(-interval)
-			if index := searchFrames(frames, priorFrameTime); index > -1 {
+			if index, ok := findFrame(frames, priorFrameTime); ok {
 				return frames[index].Close, true
 			}
 		}
@@ -144,15 +504,19 @@ func (store *FrameStore) getCachedPriceAt(
 	return 0, false
 }
 
-func (store *FrameStore) GetPriceAt(pair string, t time.Time) (float64, error) {
+func (store *FrameStore) GetPriceAt(pair market.Pair, t time.Time) (float64, error) {
 	// check cache
 	price, ok := store.getCachedPriceAt(pair, t)
 	if ok {
 		return price, nil
 	}
 
+	store.mu.RLock()
+	priceInterval := store.PriceInterval
+	store.mu.RUnlock()
+
 	// retrieve and cache data
-	frames, err := store.GetNBefore(pair, time.Minute, 1, t)
+	frames, err := store.GetNBefore(pair, priceInterval, 1, t)
 	if err != nil {
 		return 0, err
 	}
@@ -160,6 +524,51 @@ func (store *FrameStore) GetPriceAt(pair string, t time.Time) (float64, error) {
 	return frames[len(frames)-1].Close, nil
 }
 
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+
+	return b
+}
+
+// extend the covered time range of a pair and interval to include [start,
+// end) (must hold store.mu)
+func (store *FrameStore) cover(
+	pair market.Pair,
+	interval time.Duration,
+	start, end time.Time,
+) {
+	if _, ok := store.coverage[pair]; !ok {
+		store.coverage[pair] = map[time.Duration]Coverage{}
+	}
+
+	span, ok := store.coverage[pair][interval]
+	if !ok || start.After(span.End) || end.Before(span.Start) {
+		// disjoint ranges can't be merged, so keep the newer one
+		store.coverage[pair][interval] = Coverage{start, end}
+		return
+	}
+
+	if start.Before(span.Start) {
+		span.Start = start
+	}
+
+	span.End = maxTime(span.End, end)
+	store.coverage[pair][interval] = span
+}
+
+func (store *FrameStore) Covered(
+	pair market.Pair,
+	interval time.Duration,
+) (Coverage, bool) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	span, ok := store.coverage[cacheKey(pair)][interval]
+	return span, ok
+}
+
 // merge and deduplicate two sorted frame slices
 func mergeFrames(a, b []*frame.Frame) []*frame.Frame {
 	merged := make([]*frame.Frame, 0, len(a)+len(b))
@@ -185,26 +594,57 @@ func mergeFrames(a, b []*frame.Frame) []*frame.Frame {
 	return merged
 }
 
-func (store *FrameStore) Set(
-	pair string,
+// merge frames into the cache, which then covers everything from since to the
+// end of the last frame, keeping the needed ones (must hold store.mu)
+func (store *FrameStore) set(
+	pair market.Pair,
 	interval time.Duration,
 	frames []*frame.Frame,
-) *FrameStore {
+	since time.Time,
+	needed window,
+) {
+	pair = cacheKey(pair)
+
+	if len(frames) > 0 {
+		store.cover(pair, interval, since, frames[len(frames)-1].Time.Add(interval))
+	} else {
+		store.cover(pair, interval, since, since)
+	}
+
 	// check if pair is in cache
 	if _, ok := store.Cache[pair]; !ok {
-		store.Cache[pair] = PartialFrameCache{interval: frames}
-		return store
+		store.Cache[pair] = PartialFrameCache{}
 	}
 
-	// check if interval is in pair's partial cache {
-	oldFrames, ok := store.Cache[pair][interval]
-	if !ok {
-		store.Cache[pair][interval] = frames
+	// check if interval is in pair's partial cache, and merge frames if it is
+	if oldFrames, ok := store.Cache[pair][interval]; ok {
+		frames = mergeFrames(frames, oldFrames)
+	}
+
+	store.Cache[pair][interval] = frames
+	store.retain(pair, interval, needed)
+
+	// a forming frame is stale once a closed frame replaces it
+	forming, ok := store.forming[pair][interval]
+	if ok && len(frames) > 0 && !forming.Time.After(frames[len(frames)-1].Time) {
+		delete(store.forming[pair], interval)
+	}
+}
+
+func (store *FrameStore) Set(
+	pair market.Pair,
+	interval time.Duration,
+	frames []*frame.Frame,
+) *FrameStore {
+	if len(frames) == 0 {
 		return store
 	}
 
-	// merge frames
-	store.Cache[pair][interval] = mergeFrames(frames, oldFrames)
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	// frames cover the time from the start of the first to the end of the last
+	store.set(pair, interval, frames, frames[0].Time, window{})
 
 	return store
 }
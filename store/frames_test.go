@@ -1,7 +1,11 @@
 package store
 
 import (
+	"errors"
 	"github.com/haydenhigg/chrys/frame"
+	"github.com/haydenhigg/chrys/market"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -12,7 +16,7 @@ type MockFrameAPI struct {
 }
 
 func (api MockFrameAPI) FetchFramesSince(
-	pair string,
+	pair market.Pair,
 	interval time.Duration,
 	since time.Time,
 ) ([]*frame.Frame, error) {
@@ -37,6 +41,20 @@ func (api MockFrameAPI) FetchFramesSince(
 	return frames, nil
 }
 
+type FuncFrameAPI func(
+	pair market.Pair,
+	interval time.Duration,
+	since time.Time,
+) ([]*frame.Frame, error)
+
+func (api FuncFrameAPI) FetchFramesSince(
+	pair market.Pair,
+	interval time.Duration,
+	since time.Time,
+) ([]*frame.Frame, error) {
+	return api(pair, interval, since)
+}
+
 // tests
 func assertFrameTimesEqual(a, b []*frame.Frame, t *testing.T) {
 	n := max(len(a), len(b))
@@ -81,7 +99,7 @@ func Test_GetSinceExactTimeUncached(t *testing.T) {
 
 	// GetSince()
 	since := time.Now().Truncate(time.Hour).Add(-3 * time.Hour)
-	frames, err := NewFrames(mockAPI).GetSince("BTC/USD", time.Hour, since)
+	frames, err := NewFrames(mockAPI).GetSince(btcUSD, time.Hour, since)
 	if err != nil {
 		t.Errorf("err != nil: %v", err)
 	}
@@ -108,14 +126,14 @@ func Test_GetSinceExactTimeCached(t *testing.T) {
 
 	// GetSince()
 	since := time.Now().Truncate(time.Hour).Add(-10 * time.Hour)
-	store.GetSince("BTC/USD", time.Hour, since)
+	store.GetSince(btcUSD, time.Hour, since)
 
 	// reset didUseAPI
 	didUseAPI = false
 
 	// GetSince() again
 	since = since.Add(7 * time.Hour) // time.Now().Add(-3 * time.Hour)
-	frames, err := store.GetSince("BTC/USD", time.Hour, since)
+	frames, err := store.GetSince(btcUSD, time.Hour, since)
 	if err != nil {
 		t.Errorf("err != nil: %v", err)
 	}
@@ -142,14 +160,14 @@ func Test_GetSinceExactTimeCachedMiss(t *testing.T) {
 
 	// GetSince()
 	since := time.Now().Truncate(time.Hour).Add(-time.Hour)
-	store.GetSince("BTC/USD", time.Hour, since)
+	store.GetSince(btcUSD, time.Hour, since)
 
 	// reset didUseAPI
 	didUseAPI = false
 
 	// GetSince() again
 	since = since.Add(-2 * time.Hour)
-	frames, err := store.GetSince("BTC/USD", time.Hour, since)
+	frames, err := store.GetSince(btcUSD, time.Hour, since)
 	if err != nil {
 		t.Errorf("err != nil: %v", err)
 	}
@@ -174,7 +192,7 @@ func Test_GetSinceInexactTimeUncached(t *testing.T) {
 
 	// GetSince()
 	since := time.Now().Add(-3 * time.Hour)
-	frames, err := NewFrames(mockAPI).GetSince("BTC/USD", time.Hour, since)
+	frames, err := NewFrames(mockAPI).GetSince(btcUSD, time.Hour, since)
 	if err != nil {
 		t.Errorf("err != nil: %v", err)
 	}
@@ -201,14 +219,14 @@ func Test_GetSinceInexactTimeCached(t *testing.T) {
 
 	// GetSince()
 	since := time.Now().Add(-10 * time.Hour)
-	store.GetSince("BTC/USD", time.Hour, since)
+	store.GetSince(btcUSD, time.Hour, since)
 
 	// reset didUseAPI
 	didUseAPI = false
 
 	// GetSince() again
 	since = since.Add(7 * time.Hour) // time.Now().Add(-3 * time.Hour)
-	frames, err := store.GetSince("BTC/USD", time.Hour, since)
+	frames, err := store.GetSince(btcUSD, time.Hour, since)
 	if err != nil {
 		t.Errorf("err != nil: %v", err)
 	}
@@ -235,14 +253,14 @@ func Test_GetSinceInexactTimeCachedMiss(t *testing.T) {
 
 	// GetSince()
 	since := time.Now().Add(-time.Hour)
-	store.GetSince("BTC/USD", time.Hour, since)
+	store.GetSince(btcUSD, time.Hour, since)
 
 	// reset didUseAPI
 	didUseAPI = false
 
 	// GetSince() again
 	since = since.Add(-2 * time.Hour)
-	frames, err := store.GetSince("BTC/USD", time.Hour, since)
+	frames, err := store.GetSince(btcUSD, time.Hour, since)
 	if err != nil {
 		t.Errorf("err != nil: %v", err)
 	}
@@ -259,6 +277,32 @@ func Test_GetSinceInexactTimeCachedMiss(t *testing.T) {
 	}
 }
 
+func Test_GetSinceTopUpTail(t *testing.T) {
+	// set up store whose cache was filled a few hours ago
+	now := time.Now().Truncate(time.Hour)
+	source := now.Add(-3 * time.Hour)
+	sinces := []time.Time{}
+	store := NewFrames(newClockedAPI(&source, &sinces))
+
+	// GetSince() before and after the source catches up
+	store.GetSince(btcUSD, time.Hour, now.Add(-5*time.Hour))
+
+	source = now
+	frames, err := store.GetSince(btcUSD, time.Hour, now.Add(-5*time.Hour))
+	if err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	// assert
+	if len(frames) != 5 || !frames[4].Time.Equal(now.Add(-time.Hour)) {
+		t.Errorf("frames != 5 up to %v: %v", now.Add(-time.Hour), frames)
+	}
+
+	if len(sinces) != 2 || !sinces[1].Equal(now.Add(-3*time.Hour)) {
+		t.Errorf("sinces != start, tail: %v", sinces)
+	}
+}
+
 // tests -> GetNBefore
 // tests -> GetNBefore -> Exact Time
 func Test_GetNBeforeExactTime(t *testing.T) {
@@ -267,7 +311,7 @@ func Test_GetNBeforeExactTime(t *testing.T) {
 
 	// GetNBefore()
 	now := time.Now().Truncate(time.Hour)
-	frames, err := store.GetNBefore("BTC/USD", time.Hour, 5, now)
+	frames, err := store.GetNBefore(btcUSD, time.Hour, 5, now)
 	if err != nil {
 		t.Errorf("err != nil: %v", err)
 	}
@@ -282,6 +326,33 @@ func Test_GetNBeforeExactTime(t *testing.T) {
 	}, t)
 }
 
+func Test_GetNBeforeAppendDoesNotOverwrite(t *testing.T) {
+	// set up store
+	store := NewFrames(MockFrameAPI{})
+
+	// GetNBefore()
+	now := time.Now().Truncate(time.Hour)
+	frames, err := store.GetNBefore(btcUSD, time.Hour, 3, now.Add(-time.Hour))
+	if err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	_ = append(frames, &frame.Frame{Time: now.Add(time.Hour)})
+
+	// assert
+	frames, err = store.GetNBefore(btcUSD, time.Hour, 4, now)
+	if err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	assertFrameTimesEqual(frames, []*frame.Frame{
+		{Time: now.Add(-4 * time.Hour)},
+		{Time: now.Add(-3 * time.Hour)},
+		{Time: now.Add(-2 * time.Hour)},
+		{Time: now.Add(-time.Hour)},
+	}, t)
+}
+
 // tests -> GetNBefore -> Inexact Time
 func Test_GetNBeforeInexactTime(t *testing.T) {
 	// set up store
@@ -289,7 +360,7 @@ func Test_GetNBeforeInexactTime(t *testing.T) {
 
 	// GetNBefore()
 	now := time.Now()
-	frames, err := store.GetNBefore("BTC/USD", time.Minute, 5, now)
+	frames, err := store.GetNBefore(btcUSD, time.Minute, 5, now)
 	if err != nil {
 		t.Errorf("err != nil: %v", err)
 	}
@@ -305,6 +376,440 @@ func Test_GetNBeforeInexactTime(t *testing.T) {
 	}, t)
 }
 
+// tests -> GetNBefore -> Insufficient Data
+func newListedAPI(listed time.Time) FuncFrameAPI {
+	// only serves frames from after the pair was listed
+	return func(
+		pair market.Pair,
+		interval time.Duration,
+		since time.Time,
+	) ([]*frame.Frame, error) {
+		frames, _ := MockFrameAPI{}.FetchFramesSince(pair, interval, since)
+
+		for i, f := range frames {
+			if !f.Time.Before(listed) {
+				return frames[i:], nil
+			}
+		}
+
+		return []*frame.Frame{}, nil
+	}
+}
+
+func Test_GetNBeforeInsufficient(t *testing.T) {
+	// set up store
+	now := time.Now().Truncate(time.Hour)
+	store := NewFrames(newListedAPI(now.Add(-3 * time.Hour)))
+
+	// GetNBefore()
+	frames, err := store.GetNBefore(btcUSD, time.Hour, 5, now)
+
+	// assert
+	var insufficientErr *InsufficientFramesError
+	if !errors.As(err, &insufficientErr) {
+		t.Fatalf("err is not *InsufficientFramesError: %v", err)
+	}
+
+	if !errors.Is(err, ErrInsufficientFrames) {
+		t.Errorf("err is not ErrInsufficientFrames: %v", err)
+	}
+
+	if insufficientErr.Requested != 5 || insufficientErr.Available != 3 {
+		t.Errorf(
+			"requested, available != 5, 3: %d, %d",
+			insufficientErr.Requested,
+			insufficientErr.Available,
+		)
+	}
+
+	if frames != nil {
+		t.Errorf("frames != nil: %v", frames)
+	}
+}
+
+func Test_GetNBeforePartial(t *testing.T) {
+	// set up store
+	now := time.Now().Truncate(time.Hour)
+	store := NewFrames(newListedAPI(now.Add(-3 * time.Hour))).
+		SetAllowPartial(true)
+
+	// GetNBefore()
+	frames, err := store.GetNBefore(btcUSD, time.Hour, 5, now)
+	if err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	// assert
+	assertFrameTimesEqual(frames, []*frame.Frame{
+		{Time: now.Add(-3 * time.Hour)},
+		{Time: now.Add(-2 * time.Hour)},
+		{Time: now.Add(-time.Hour)},
+	}, t)
+}
+
+func Test_GetNBeforeNoData(t *testing.T) {
+	// set up store
+	now := time.Now().Truncate(time.Hour)
+	store := NewFrames(newListedAPI(now)).SetAllowPartial(true)
+
+	// GetNBefore()
+	frames, err := store.GetNBefore(btcUSD, time.Hour, 5, now)
+
+	// assert
+	if !errors.Is(err, ErrNoData) {
+		t.Errorf("err is not ErrNoData: %v", err)
+	}
+
+	if frames != nil {
+		t.Errorf("frames != nil: %v", frames)
+	}
+}
+
+func Test_GetNBeforeNonPositive(t *testing.T) {
+	// set up store
+	store := NewFrames(MockFrameAPI{})
+
+	// GetNBefore()
+	now := time.Now().Truncate(time.Hour)
+	_, zeroErr := store.GetNBefore(btcUSD, time.Hour, 0, now)
+	_, negativeErr := store.GetNBefore(btcUSD, time.Hour, -1, now)
+
+	// assert
+	if zeroErr == nil || negativeErr == nil {
+		t.Errorf("err == nil: %v, %v", zeroErr, negativeErr)
+	}
+}
+
+func Test_GetNBeforeExcludesLaterFrames(t *testing.T) {
+	// set up store
+	store := NewFrames(MockFrameAPI{})
+
+	// GetSince() to cache frames that close after the GetNBefore() time
+	now := time.Now().Truncate(time.Hour)
+	store.GetSince(btcUSD, time.Hour, now.Add(-10*time.Hour))
+
+	// GetNBefore()
+	frames, err := store.GetNBefore(btcUSD, time.Hour, 2, now.Add(-5*time.Hour))
+	if err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	// assert
+	assertFrameTimesEqual(frames, []*frame.Frame{
+		{Time: now.Add(-7 * time.Hour)},
+		{Time: now.Add(-6 * time.Hour)},
+	}, t)
+}
+
+// tests -> GetNBefore -> Top-Up
+func newClockedAPI(now *time.Time, sinces *[]time.Time) FuncFrameAPI {
+	// serves closed frames as of *now and records every since
+	return func(
+		pair market.Pair,
+		interval time.Duration,
+		since time.Time,
+	) ([]*frame.Frame, error) {
+		*sinces = append(*sinces, since)
+
+		frames := []*frame.Frame{}
+		for t := since; !t.Add(interval).After(*now); t = t.Add(interval) {
+			frames = append(frames, &frame.Frame{Time: t, Close: float64(t.Unix())})
+		}
+
+		return frames, nil
+	}
+}
+
+func Test_GetNBeforeTopUpTail(t *testing.T) {
+	// set up store
+	now := time.Now().Truncate(time.Hour)
+	sinces := []time.Time{}
+	store := NewFrames(newClockedAPI(&now, &sinces))
+
+	// GetNBefore()
+	store.GetNBefore(btcUSD, time.Hour, 3, now)
+
+	// GetNBefore() again after time passes
+	start := now
+	now = now.Add(2 * time.Hour)
+	frames, err := store.GetNBefore(btcUSD, time.Hour, 3, now)
+	if err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	// assert
+	assertFrameTimesEqual(frames, []*frame.Frame{
+		{Time: now.Add(-3 * time.Hour)},
+		{Time: now.Add(-2 * time.Hour)},
+		{Time: now.Add(-time.Hour)},
+	}, t)
+
+	if len(sinces) != 2 {
+		t.Fatalf("len(sinces) != 2: %d", len(sinces))
+	}
+
+	if !sinces[1].Equal(start) {
+		t.Errorf("tail since != %v: %v", start, sinces[1])
+	}
+
+	span, _ := store.Covered(btcUSD, time.Hour)
+	if !span.Start.Equal(start.Add(-3*time.Hour)) || !span.End.Equal(now) {
+		t.Errorf("coverage != [start-3h, now): %v", span)
+	}
+}
+
+func Test_GetNBeforeTopUpHead(t *testing.T) {
+	// set up store
+	now := time.Now().Truncate(time.Hour)
+	sinces := []time.Time{}
+	store := NewFrames(newClockedAPI(&now, &sinces))
+
+	// GetNBefore()
+	store.GetNBefore(btcUSD, time.Hour, 3, now)
+
+	// GetNBefore() again with a longer lookback
+	frames, err := store.GetNBefore(btcUSD, time.Hour, 5, now)
+	if err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	// assert
+	if len(frames) != 5 {
+		t.Errorf("len(frames) != 5: %d", len(frames))
+	}
+
+	if len(sinces) != 2 {
+		t.Fatalf("len(sinces) != 2: %d", len(sinces))
+	}
+
+	if !sinces[1].Equal(now.Add(-5 * time.Hour)) {
+		t.Errorf("head since != now-5h: %v", sinces[1])
+	}
+}
+
+func Test_GetNBeforeStaleTailUnavailable(t *testing.T) {
+	// set up store
+	now := time.Now().Truncate(time.Hour)
+	sinces := []time.Time{}
+	store := NewFrames(newClockedAPI(&now, &sinces)).SetAllowPartial(true)
+
+	// GetNBefore()
+	store.GetNBefore(btcUSD, time.Hour, 3, now)
+
+	// GetNBefore() for a time whose last frame hasn't closed at the source
+	frames, err := store.GetNBefore(btcUSD, time.Hour, 3, now.Add(time.Hour))
+	if err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	// assert
+	assertFrameTimesEqual(frames, []*frame.Frame{
+		{Time: now.Add(-2 * time.Hour)},
+		{Time: now.Add(-time.Hour)},
+	}, t)
+
+	if len(sinces) != 2 {
+		t.Errorf("len(sinces) != 2: %d", len(sinces))
+	}
+}
+
+func Test_GetNBeforeCoveredWithoutFrames(t *testing.T) {
+	// set up mock
+	calls := 0
+	now := time.Now().Truncate(time.Hour)
+	listedAPI := newListedAPI(now.Add(-2 * time.Hour))
+	mockAPI := FuncFrameAPI(func(
+		pair market.Pair,
+		interval time.Duration,
+		since time.Time,
+	) ([]*frame.Frame, error) {
+		calls++
+		return listedAPI(pair, interval, since)
+	})
+
+	// set up store
+	store := NewFrames(mockAPI).SetAllowPartial(true)
+
+	// GetNBefore() twice for a pair listed partway through the lookback
+	store.GetNBefore(btcUSD, time.Hour, 5, now)
+	frames, err := store.GetNBefore(btcUSD, time.Hour, 5, now)
+	if err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	// assert
+	if len(frames) != 2 {
+		t.Errorf("len(frames) != 2: %d", len(frames))
+	}
+
+	if calls != 1 {
+		t.Errorf("calls != 1: %d", calls)
+	}
+}
+
+// tests -> GetNBefore -> Resampled
+func Test_GetNBeforeResampled(t *testing.T) {
+	// set up store
+	now := time.Now().Truncate(time.Hour)
+	sinces := []time.Time{}
+	store := NewFrames(newClockedAPI(&now, &sinces)).
+		SetBaseInterval(time.Minute)
+
+	// GetNBefore() for several intervals
+	frames5Min, err := store.GetNBefore(btcUSD, 5*time.Minute, 3, now)
+	if err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	frames15Min, err := store.GetNBefore(btcUSD, 15*time.Minute, 1, now)
+	if err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	// assert
+	assertFrameTimesEqual(frames5Min, []*frame.Frame{
+		{Time: now.Add(-15 * time.Minute)},
+		{Time: now.Add(-10 * time.Minute)},
+		{Time: now.Add(-5 * time.Minute)},
+	}, t)
+
+	assertFrameTimesEqual(frames15Min, []*frame.Frame{
+		{Time: now.Add(-15 * time.Minute)},
+	}, t)
+
+	// the close of each bucket is the close of its last 1m frame
+	lastClose := float64(now.Add(-time.Minute).Unix())
+	if frames5Min[2].Close != lastClose || frames15Min[0].Close != lastClose {
+		t.Errorf(
+			"closes != %f: %f, %f",
+			lastClose,
+			frames5Min[2].Close,
+			frames15Min[0].Close,
+		)
+	}
+
+	if len(sinces) != 1 {
+		t.Errorf("len(sinces) != 1: %d", len(sinces))
+	}
+
+	if _, ok := store.Cache[btcUSD][5*time.Minute]; ok {
+		t.Errorf("5m frames were cached")
+	}
+}
+
+func Test_GetNBeforeResampledPartial(t *testing.T) {
+	// set up store
+	now := time.Now().Truncate(time.Hour)
+	sinces := []time.Time{}
+	store := NewFrames(newClockedAPI(&now, &sinces)).
+		SetBaseInterval(time.Minute).
+		SetAllowPartial(true)
+
+	// GetSince() for a time partway through the trailing 5m bucket
+	now = now.Add(-2 * time.Minute)
+	frames, err := store.GetSince(btcUSD, 5*time.Minute, now.Add(-8*time.Minute))
+	if err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	// assert (the bucket that starts at -5m has only 3 closed 1m frames)
+	bucketStart := now.Add(2 * time.Minute).Add(-10 * time.Minute)
+	assertFrameTimesEqual(frames, []*frame.Frame{
+		{Time: bucketStart},
+	}, t)
+}
+
+// tests -> GetNBefore -> Repaired
+func newGappyAPI(missing time.Time) FuncFrameAPI {
+	// serves well-formed frames, except for the one at missing
+	return func(
+		pair market.Pair,
+		interval time.Duration,
+		since time.Time,
+	) ([]*frame.Frame, error) {
+		frames, _ := MockFrameAPI{}.FetchFramesSince(pair, interval, since)
+
+		gappy := []*frame.Frame{}
+		for _, f := range frames {
+			if !f.Time.Equal(missing) {
+				f.Open, f.High, f.Low = f.Close, f.Close, f.Close
+				gappy = append(gappy, f)
+			}
+		}
+
+		return gappy, nil
+	}
+}
+
+func Test_GetNBeforeRepaired(t *testing.T) {
+	// set up store
+	now := time.Now().Truncate(time.Hour)
+	anomalies := []frame.Anomaly{}
+	store := NewFrames(newGappyAPI(now.Add(-2 * time.Hour))).
+		SetRepairPolicy(frame.ForwardFill).
+		OnAnomalies(func(
+			pair market.Pair,
+			interval time.Duration,
+			found []frame.Anomaly,
+		) {
+			anomalies = append(anomalies, found...)
+		})
+
+	// GetNBefore()
+	frames, err := store.GetNBefore(btcUSD, time.Hour, 3, now)
+	if err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	// assert
+	assertFrameClosesEqual(frames, []*frame.Frame{
+		{Close: 1},
+		{Close: 1},
+		{Close: 3},
+	}, t)
+
+	if len(anomalies) != 1 || anomalies[0].Kind != frame.Gap {
+		t.Errorf("anomalies != [gap]: %v", anomalies)
+	}
+}
+
+func Test_GetNBeforeNotRepaired(t *testing.T) {
+	// set up store
+	now := time.Now().Truncate(time.Hour)
+	store := NewFrames(newGappyAPI(now.Add(-2 * time.Hour)))
+
+	// GetNBefore()
+	_, err := store.GetNBefore(btcUSD, time.Hour, 3, now)
+
+	// assert
+	if !errors.Is(err, ErrInsufficientFrames) {
+		t.Errorf("err is not ErrInsufficientFrames: %v", err)
+	}
+}
+
+func Test_GetNBeforeRepairedAcrossFetches(t *testing.T) {
+	// set up store
+	now := time.Now().Truncate(time.Hour)
+	store := NewFrames(newGappyAPI(now.Add(-3 * time.Hour))).
+		SetRepairPolicy(frame.ForwardFill)
+
+	// GetNBefore() for the frames up to the gap, then past it
+	store.GetNBefore(btcUSD, time.Hour, 2, now.Add(-3*time.Hour))
+	frames, err := store.GetNBefore(btcUSD, time.Hour, 5, now)
+	if err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	// assert
+	assertFrameTimesEqual(frames, []*frame.Frame{
+		{Time: now.Add(-5 * time.Hour)},
+		{Time: now.Add(-4 * time.Hour)},
+		{Time: now.Add(-3 * time.Hour)},
+		{Time: now.Add(-2 * time.Hour)},
+		{Time: now.Add(-time.Hour)},
+	}, t)
+}
+
 // tests -> GetPriceAt
 // tests -> GetPriceAt -> Exact Time
 func Test_GetPriceAtExactTimeUncached(t *testing.T) {
@@ -313,7 +818,7 @@ func Test_GetPriceAtExactTimeUncached(t *testing.T) {
 
 	// GetPriceAt()
 	now := time.Now().Truncate(time.Minute)
-	price, err := store.GetPriceAt("BTC/USD", now)
+	price, err := store.GetPriceAt(btcUSD, now)
 	if err != nil {
 		t.Errorf("err != nil: %v", err)
 	}
@@ -324,7 +829,7 @@ func Test_GetPriceAtExactTimeUncached(t *testing.T) {
 		t.Errorf("price != expectedPrice: %f != %f", price, expectedPrice)
 	}
 
-	assertFrameTimesEqual(store.Cache["BTC/USD"][time.Minute], []*frame.Frame{
+	assertFrameTimesEqual(store.Cache[btcUSD][time.Minute], []*frame.Frame{
 		{Time: now.Add(-time.Minute)},
 	}, t)
 }
@@ -339,13 +844,13 @@ func Test_GetPriceAtExactTimeCached(t *testing.T) {
 
 	// GetNBefore()
 	now := time.Now().Truncate(30 * time.Minute)
-	store.GetNBefore("BTC/USD", 30*time.Minute, 5, now)
+	store.GetNBefore(btcUSD, 30*time.Minute, 5, now)
 
 	// reset didUseAPI
 	didUseAPI = false
 
 	// GetPriceAt()
-	price, err := store.GetPriceAt("BTC/USD", now)
+	price, err := store.GetPriceAt(btcUSD, now)
 	if err != nil {
 		t.Errorf("err != nil: %v", err)
 	}
@@ -356,7 +861,7 @@ func Test_GetPriceAtExactTimeCached(t *testing.T) {
 		t.Errorf("price != expectedPrice: %f != %f", price, expectedPrice)
 	}
 
-	assertFrameTimesEqual(store.Cache["BTC/USD"][30*time.Minute], []*frame.Frame{
+	assertFrameTimesEqual(store.Cache[btcUSD][30*time.Minute], []*frame.Frame{
 		{Time: now.Add(-5 * 30 * time.Minute)},
 		{Time: now.Add(-4 * 30 * time.Minute)},
 		{Time: now.Add(-3 * 30 * time.Minute)},
@@ -378,14 +883,15 @@ func Test_GetPriceAtExactTimeCachedMiss(t *testing.T) {
 	store := NewFrames(mockAPI)
 
 	// GetNBefore()
-	now := time.Now().Truncate(30 * time.Minute).Add(time.Minute)
-	store.GetNBefore("BTC/USD", 30*time.Minute, 5, now)
+	now := time.Now().Add(-time.Minute).Truncate(30 * time.Minute).
+		Add(time.Minute)
+	store.GetNBefore(btcUSD, 30*time.Minute, 5, now)
 
 	// reset didUseAPI
 	didUseAPI = false
 
 	// GetPriceAt()
-	price, err := store.GetPriceAt("BTC/USD", now)
+	price, err := store.GetPriceAt(btcUSD, now)
 	if err != nil {
 		t.Errorf("err != nil: %v", err)
 	}
@@ -397,7 +903,7 @@ func Test_GetPriceAtExactTimeCachedMiss(t *testing.T) {
 	}
 
 	assertFrameTimesEqual(
-		store.Cache["BTC/USD"][time.Minute][:1],
+		store.Cache[btcUSD][time.Minute][:1],
 		[]*frame.Frame{
 			{Time: now.Add(-time.Minute)},
 		},
@@ -409,6 +915,20 @@ func Test_GetPriceAtExactTimeCachedMiss(t *testing.T) {
 	}
 }
 
+func Test_GetPriceAtNoData(t *testing.T) {
+	// set up store
+	now := time.Now().Truncate(time.Minute)
+	store := NewFrames(newListedAPI(now))
+
+	// GetPriceAt()
+	_, err := store.GetPriceAt(btcUSD, now)
+
+	// assert
+	if !errors.Is(err, ErrNoData) {
+		t.Errorf("err is not ErrNoData: %v", err)
+	}
+}
+
 // tests -> GetPriceAt -> Inexact Time
 func Test_GetPriceAtInexactTimeUncached(t *testing.T) {
 	// set up store
@@ -416,7 +936,7 @@ func Test_GetPriceAtInexactTimeUncached(t *testing.T) {
 
 	// GetPriceAt()
 	now := time.Now()
-	price, err := store.GetPriceAt("BTC/USD", now)
+	price, err := store.GetPriceAt(btcUSD, now)
 	if err != nil {
 		t.Errorf("err != nil: %v", err)
 	}
@@ -427,7 +947,7 @@ func Test_GetPriceAtInexactTimeUncached(t *testing.T) {
 		t.Errorf("price != expectedPrice: %f != %f", price, expectedPrice)
 	}
 
-	assertFrameTimesEqual(store.Cache["BTC/USD"][time.Minute], []*frame.Frame{
+	assertFrameTimesEqual(store.Cache[btcUSD][time.Minute], []*frame.Frame{
 		{Time: now.Truncate(time.Minute).Add(-time.Minute)},
 	}, t)
 }
@@ -442,13 +962,13 @@ func Test_GetPriceAtInexactTimeCached(t *testing.T) {
 
 	// GetNBefore()
 	now := time.Now().Truncate(30 * time.Minute).Add(37 * time.Second)
-	store.GetNBefore("BTC/USD", 30*time.Minute, 5, now)
+	store.GetNBefore(btcUSD, 30*time.Minute, 5, now)
 
 	// reset didUseAPI
 	didUseAPI = false
 
 	// GetPriceAt()
-	price, err := store.GetPriceAt("BTC/USD", now)
+	price, err := store.GetPriceAt(btcUSD, now)
 	if err != nil {
 		t.Errorf("err != nil: %v", err)
 	}
@@ -460,7 +980,7 @@ func Test_GetPriceAtInexactTimeCached(t *testing.T) {
 	}
 
 	truncatedNow := now.Truncate(time.Minute)
-	assertFrameTimesEqual(store.Cache["BTC/USD"][30*time.Minute], []*frame.Frame{
+	assertFrameTimesEqual(store.Cache[btcUSD][30*time.Minute], []*frame.Frame{
 		{Time: truncatedNow.Add(-5 * 30 * time.Minute)},
 		{Time: truncatedNow.Add(-4 * 30 * time.Minute)},
 		{Time: truncatedNow.Add(-3 * 30 * time.Minute)},
@@ -482,16 +1002,16 @@ func Test_GetPriceAtInexactTimeCachedMiss(t *testing.T) {
 	store := NewFrames(mockAPI)
 
 	// GetNBefore()
-	now := time.Now().Truncate(30 * time.Minute).
+	now := time.Now().Add(-97 * time.Second).Truncate(30 * time.Minute).
 		Add(time.Minute).
 		Add(37 * time.Second)
-	store.GetNBefore("BTC/USD", 30*time.Minute, 5, now)
+	store.GetNBefore(btcUSD, 30*time.Minute, 5, now)
 
 	// reset didUseAPI
 	didUseAPI = false
 
 	// GetPriceAt()
-	price, err := store.GetPriceAt("BTC/USD", now)
+	price, err := store.GetPriceAt(btcUSD, now)
 	if err != nil {
 		t.Errorf("err != nil: %v", err)
 	}
@@ -503,7 +1023,7 @@ func Test_GetPriceAtInexactTimeCachedMiss(t *testing.T) {
 	}
 
 	assertFrameTimesEqual(
-		store.Cache["BTC/USD"][time.Minute][:1],
+		store.Cache[btcUSD][time.Minute][:1],
 		[]*frame.Frame{
 			{Time: now.Truncate(time.Minute).Add(-time.Minute)},
 		},
@@ -515,6 +1035,73 @@ func Test_GetPriceAtInexactTimeCachedMiss(t *testing.T) {
 	}
 }
 
+func Test_GetPriceAtSubMinuteCached(t *testing.T) {
+	// set up mock
+	didUseAPI := false
+	mockAPI := MockFrameAPI{callback: func() { didUseAPI = true }}
+
+	// set up store
+	store := NewFrames(mockAPI).SetPriceInterval(10 * time.Second)
+
+	// GetNBefore()
+	now := time.Now().Truncate(10 * time.Second).Add(3 * time.Second)
+	store.GetNBefore(btcUSD, 10*time.Second, 5, now)
+
+	// reset didUseAPI
+	didUseAPI = false
+
+	// GetPriceAt()
+	price, err := store.GetPriceAt(btcUSD, now)
+	if err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	// assert
+	expectedPrice := 5.
+	if price != expectedPrice {
+		t.Errorf("price != expectedPrice: %f != %f", price, expectedPrice)
+	}
+
+	if didUseAPI {
+		t.Error("cache was not hit")
+	}
+}
+
+func Test_GetPriceAtSubMinuteUncached(t *testing.T) {
+	// set up store
+	store := NewFrames(MockFrameAPI{}).SetPriceInterval(10 * time.Second)
+
+	// GetPriceAt()
+	now := time.Now()
+	price, err := store.GetPriceAt(btcUSD, now)
+	if err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	// assert
+	expectedPrice := 1.
+	if price != expectedPrice {
+		t.Errorf("price != expectedPrice: %f != %f", price, expectedPrice)
+	}
+
+	assertFrameTimesEqual(store.Cache[btcUSD][10*time.Second], []*frame.Frame{
+		{Time: now.Truncate(10 * time.Second).Add(-10 * time.Second)},
+	}, t)
+}
+
+func Test_SetPriceIntervalNonPositive(t *testing.T) {
+	// set up store
+	store := NewFrames(MockFrameAPI{})
+
+	// SetPriceInterval()
+	store.SetPriceInterval(0).SetPriceInterval(-time.Second)
+
+	// assert
+	if store.PriceInterval != time.Minute {
+		t.Errorf("PriceInterval != 1m: %v", store.PriceInterval)
+	}
+}
+
 // tests -> Set
 func Test_SetInitial(t *testing.T) {
 	// set up store
@@ -523,12 +1110,12 @@ func Test_SetInitial(t *testing.T) {
 	// Set()
 	now := time.Now().Truncate(time.Hour)
 	newFrames, _ := store.api.FetchFramesSince(
-		"BTC/USD",
+		market.NewPair("BTC", "USD"),
 		time.Hour,
 		now.Add(-5*time.Hour),
 	)
 
-	store.Set("BTC/USD", time.Hour, newFrames)
+	store.Set(btcUSD, time.Hour, newFrames)
 
 	// assert
 	expectedFrames := []*frame.Frame{
@@ -539,8 +1126,8 @@ func Test_SetInitial(t *testing.T) {
 		{Time: now.Add(-time.Hour), Close: 5.},
 	}
 
-	assertFrameTimesEqual(store.Cache["BTC/USD"][time.Hour], expectedFrames, t)
-	assertFrameClosesEqual(store.Cache["BTC/USD"][time.Hour], expectedFrames, t)
+	assertFrameTimesEqual(store.Cache[btcUSD][time.Hour], expectedFrames, t)
+	assertFrameClosesEqual(store.Cache[btcUSD][time.Hour], expectedFrames, t)
 }
 
 func Test_SetMerge(t *testing.T) {
@@ -550,21 +1137,21 @@ func Test_SetMerge(t *testing.T) {
 	// Set()
 	now := time.Now().Truncate(time.Hour)
 	newFrames, _ := store.api.FetchFramesSince(
-		"BTC/USD",
+		market.NewPair("BTC", "USD"),
 		time.Hour,
 		now.Add(-5*time.Hour),
 	)
 
-	store.Set("BTC/USD", time.Hour, newFrames)
+	store.Set(btcUSD, time.Hour, newFrames)
 
 	// Set() again
 	newFrames, _ = store.api.FetchFramesSince(
-		"BTC/USD",
+		market.NewPair("BTC", "USD"),
 		time.Hour,
 		now.Add(-2*time.Hour),
 	)
 
-	store.Set("BTC/USD", time.Hour, newFrames)
+	store.Set(btcUSD, time.Hour, newFrames)
 
 	// assert
 	expectedFrames := []*frame.Frame{
@@ -575,6 +1162,132 @@ func Test_SetMerge(t *testing.T) {
 		{Time: now.Add(-time.Hour), Close: 2.},
 	}
 
-	assertFrameTimesEqual(store.Cache["BTC/USD"][time.Hour], expectedFrames, t)
-	assertFrameClosesEqual(store.Cache["BTC/USD"][time.Hour], expectedFrames, t)
+	assertFrameTimesEqual(store.Cache[btcUSD][time.Hour], expectedFrames, t)
+	assertFrameClosesEqual(store.Cache[btcUSD][time.Hour], expectedFrames, t)
+}
+
+// tests -> concurrency
+func Test_GetSinceConcurrent(t *testing.T) {
+	// set up store
+	store := NewFrames(MockFrameAPI{})
+
+	// GetSince(), GetPriceAt() and Set() concurrently
+	now := time.Now().Truncate(time.Hour)
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(3)
+
+		go func() {
+			defer wg.Done()
+			since := now.Add(time.Duration(-i-1) * time.Hour)
+			if _, err := store.GetSince(btcUSD, time.Hour, since); err != nil {
+				t.Errorf("err != nil: %v", err)
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			if _, err := store.GetPriceAt(ethUSD, now); err != nil {
+				t.Errorf("err != nil: %v", err)
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			store.Set(btcUSD, time.Hour, []*frame.Frame{
+				{Time: now.Add(time.Duration(-i-1) * time.Hour)},
+			})
+		}()
+	}
+	wg.Wait()
+
+	// assert
+	frames, err := store.GetSince(btcUSD, time.Hour, now.Add(-20*time.Hour))
+	if err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	if len(frames) != 20 {
+		t.Errorf("len(frames) != 20: %d", len(frames))
+	}
+}
+
+func Test_GetSinceDeduplicated(t *testing.T) {
+	// set up mock
+	calls := atomic.Int32{}
+	release := make(chan struct{})
+	mockAPI := MockFrameAPI{callback: func() {
+		calls.Add(1)
+		<-release
+	}}
+
+	// set up store
+	store := NewFrames(mockAPI)
+
+	// GetSince() concurrently
+	since := time.Now().Truncate(time.Hour).Add(-3 * time.Hour)
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			frames, err := store.GetSince(btcUSD, time.Hour, since)
+			if err != nil {
+				t.Errorf("err != nil: %v", err)
+			}
+
+			assertFrameTimesEqual(frames, []*frame.Frame{
+				{Time: since},
+				{Time: since.Add(time.Hour)},
+				{Time: since.Add(2 * time.Hour)},
+			}, t)
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	// assert
+	if n := calls.Load(); n != 1 {
+		t.Errorf("calls != 1: %d", n)
+	}
+}
+
+func Test_GetSinceSymbol(t *testing.T) {
+	// set up store
+	symbols := []string{}
+	store := NewFrames(FuncFrameAPI(func(
+		pair market.Pair,
+		interval time.Duration,
+		since time.Time,
+	) ([]*frame.Frame, error) {
+		symbols = append(symbols, pair.Symbol)
+		return []*frame.Frame{{Time: since}}, nil
+	}))
+
+	// GetSince()
+	pair := btcUSD
+	pair.Symbol = "XBTUSD"
+	since := time.Now().Truncate(time.Hour).Add(-time.Hour)
+	_, err := store.GetSince(pair, time.Hour, since)
+	if err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	// GetSince() again without the symbol
+	frames, err := store.GetSince(btcUSD, time.Hour, since)
+	if err != nil {
+		t.Errorf("err != nil: %v", err)
+	}
+
+	// assert
+	if len(symbols) != 1 || symbols[0] != "XBTUSD" {
+		t.Errorf("symbols != [XBTUSD]: %v", symbols)
+	}
+
+	if len(frames) != 1 || len(store.Cache) != 1 {
+		t.Errorf("pair was cached twice: %v", store.Cache)
+	}
 }
@@ -0,0 +1,82 @@
+package store_test
+
+import (
+	"fmt"
+	"github.com/haydenhigg/chrys/driver"
+	"github.com/haydenhigg/chrys/market"
+	"github.com/haydenhigg/chrys/store"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mock
+func writeMinutes(t *testing.T, n int) string {
+	root := t.TempDir()
+
+	var data strings.Builder
+	for i := range n {
+		fmt.Fprintf(&data, "%d,%d,%d,%d,%d,1\n", i*60, i, i, i, i)
+	}
+
+	path := filepath.Join(root, "BTCUSD_1.csv")
+	if err := os.WriteFile(path, []byte(data.String()), 0644); err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	return root
+}
+
+var btcUSD = market.NewPair("BTC", "USD")
+
+// tests
+func Test_HistoricalGetSinceAgain(t *testing.T) {
+	// set up store over historical data without a clock
+	root := writeMinutes(t, 10)
+	frames := store.NewFrames(driver.NewHistorical(root, "%s%s_%d.csv"))
+
+	// GetSince() twice
+	start := time.Unix(0, 0)
+	if _, err := frames.GetSince(btcUSD, time.Minute, start); err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	got, err := frames.GetSince(btcUSD, time.Minute, start)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if len(got) != 10 || got[9].Close != 9 {
+		t.Errorf("frames != 0..9: %v", got)
+	}
+}
+
+func Test_HistoricalRetention(t *testing.T) {
+	// set up store over historical data that keeps fewer frames than a file
+	root := writeMinutes(t, 1000)
+	frames := store.NewFrames(driver.NewHistorical(root, "%s%s_%d.csv")).
+		SetRetention(store.Retention{MaxFrames: 100})
+
+	// GetNBefore() early in the file
+	got, err := frames.GetNBefore(
+		btcUSD,
+		time.Minute,
+		20,
+		time.Unix(0, 0).Add(50*time.Minute),
+	)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if len(got) != 20 || got[0].Close != 30 || got[19].Close != 49 {
+		t.Errorf("frames != 30..49: %v", got)
+	}
+
+	if cached := len(frames.Cache[btcUSD][time.Minute]); cached != 100 {
+		t.Errorf("cached frames != 100: %d", cached)
+	}
+}
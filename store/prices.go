@@ -0,0 +1,299 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"github.com/haydenhigg/chrys/market"
+	"slices"
+	"sync"
+	"time"
+)
+
+var ErrNoRoute = errors.New("no price route")
+
+// a FrameAPI that can list the pairs it trades, which a PriceGraph finds
+// routes over
+type PairAPI interface {
+	FetchPairs() ([]market.Pair, error)
+}
+
+// a known pair that prices one asset in another, possibly inversely
+type Hop struct {
+	Pair    market.Pair
+	Inverse bool // the pair's base is the asset being priced in
+}
+
+// the asset that a hop prices
+func (hop Hop) From() string {
+	if hop.Inverse {
+		return hop.Pair.Quote
+	}
+
+	return hop.Pair.Base
+}
+
+// the asset that a hop prices in
+func (hop Hop) To() string {
+	if hop.Inverse {
+		return hop.Pair.Base
+	}
+
+	return hop.Pair.Quote
+}
+
+// the same pair in the opposite direction
+func (hop Hop) Reverse() Hop {
+	return Hop{hop.Pair, !hop.Inverse}
+}
+
+// a PriceGraph prices any asset in any other by multiplying prices along a
+// route of pairs, using 1/price for a pair that's traded the other way around.
+// Routes are configured with AddRoute or otherwise found as the shortest chain
+// of the pairs that the data source lists or that were added with AddPair;
+// assets with neither are priced by the pair between them in either direction
+type PriceGraph struct {
+	frames *FrameStore
+	mu     sync.RWMutex
+	edges  map[string]map[string]Hop
+	routes map[string]map[string][]string
+	seeded bool // whether the data source's pairs were added
+}
+
+func NewPriceGraph(frames *FrameStore) *PriceGraph {
+	return &PriceGraph{
+		frames: frames,
+		edges:  map[string]map[string]Hop{},
+		routes: map[string]map[string][]string{},
+	}
+}
+
+// add a pair in both directions (must hold graph.mu)
+func (graph *PriceGraph) addPair(pair market.Pair) {
+	for _, hop := range []Hop{{pair, false}, {pair, true}} {
+		if _, ok := graph.edges[hop.From()]; !ok {
+			graph.edges[hop.From()] = map[string]Hop{}
+		}
+
+		graph.edges[hop.From()][hop.To()] = hop
+	}
+}
+
+// add a pair that can be traded to find routes over
+func (graph *PriceGraph) AddPair(base, quote string) *PriceGraph {
+	graph.mu.Lock()
+	defer graph.mu.Unlock()
+
+	graph.addPair(market.NewPair(base, quote))
+
+	return graph
+}
+
+// add the pairs that the data source lists, once
+func (graph *PriceGraph) seed() error {
+	graph.mu.RLock()
+	seeded := graph.seeded
+	graph.mu.RUnlock()
+
+	if seeded {
+		return nil
+	}
+
+	var pairs []market.Pair
+	if api, ok := uncachedAPI(graph.frames.getAPI()).(PairAPI); ok {
+		var err error
+		if pairs, err = api.FetchPairs(); err != nil {
+			return err
+		}
+	}
+
+	graph.mu.Lock()
+	defer graph.mu.Unlock()
+
+	for _, pair := range pairs {
+		graph.addPair(pair)
+	}
+	graph.seeded = true
+
+	return nil
+}
+
+// price base in quote through the given intermediate assets in order, rather
+// than along the shortest route
+func (graph *PriceGraph) AddRoute(
+	base, quote string,
+	via ...string,
+) *PriceGraph {
+	graph.mu.Lock()
+	defer graph.mu.Unlock()
+
+	if _, ok := graph.routes[base]; !ok {
+		graph.routes[base] = map[string][]string{}
+	}
+
+	route := append([]string{base}, via...)
+	graph.routes[base][quote] = append(route, quote)
+
+	return graph
+}
+
+// find the assets along the route from base to quote, including both
+func (graph *PriceGraph) Route(base, quote string) []string {
+	graph.mu.RLock()
+	defer graph.mu.RUnlock()
+
+	if route, ok := graph.routes[base][quote]; ok {
+		return slices.Clone(route)
+	}
+
+	// breadth-first search for the route with the fewest pairs, visiting
+	// assets in sorted order so that equally short routes are chosen
+	// deterministically
+	prior := map[string]string{base: ""}
+	queue := []string{base}
+
+	for len(queue) > 0 {
+		asset := queue[0]
+		queue = queue[1:]
+
+		if asset == quote {
+			route := []string{}
+			for ; asset != ""; asset = prior[asset] {
+				route = append(route, asset)
+			}
+
+			slices.Reverse(route)
+			return route
+		}
+
+		neighbors := make([]string, 0, len(graph.edges[asset]))
+		for neighbor := range graph.edges[asset] {
+			neighbors = append(neighbors, neighbor)
+		}
+		slices.Sort(neighbors)
+
+		for _, neighbor := range neighbors {
+			if _, ok := prior[neighbor]; !ok {
+				prior[neighbor] = asset
+				queue = append(queue, neighbor)
+			}
+		}
+	}
+
+	// assume the assets are traded against each other directly
+	return []string{base, quote}
+}
+
+// whether an error means that a pair doesn't exist, rather than that it
+// couldn't be retrieved
+func isMissingPair(err error) bool {
+	return errors.Is(err, market.ErrUnknownPair) || errors.Is(err, ErrNoData)
+}
+
+// find the pair between two assets, trying both directions if it isn't known
+func (graph *PriceGraph) getHop(from, to string, t time.Time) (Hop, error) {
+	graph.mu.RLock()
+	hop, ok := graph.edges[from][to]
+	graph.mu.RUnlock()
+
+	if ok {
+		return hop, nil
+	}
+
+	direct := market.NewPair(from, to)
+	_, err := graph.frames.GetPriceAt(direct, t)
+	if err == nil {
+		return Hop{direct, false}, nil
+	} else if !isMissingPair(err) {
+		return Hop{}, err
+	}
+
+	inverse := direct.Inverse()
+	_, inverseErr := graph.frames.GetPriceAt(inverse, t)
+	if inverseErr != nil {
+		return Hop{}, err
+	}
+
+	// remember the inverse pair so that the direct one isn't tried again
+	graph.mu.Lock()
+	graph.addPair(inverse)
+	graph.mu.Unlock()
+
+	return Hop{inverse, true}, nil
+}
+
+// get the price of a hop's asset in the other
+func (graph *PriceGraph) getHopPriceAt(hop Hop, t time.Time) (float64, error) {
+	price, err := graph.frames.GetPriceAt(hop.Pair, t)
+	if err != nil {
+		return 0, err
+	} else if price == 0 {
+		return 0, fmt.Errorf("zero price of %s", hop.Pair)
+	} else if hop.Inverse {
+		return 1 / price, nil
+	}
+
+	return price, nil
+}
+
+// find the pairs along the route from base to quote, in order
+func (graph *PriceGraph) Hops(
+	base, quote string,
+	t time.Time,
+) ([]Hop, error) {
+	if err := graph.seed(); err != nil {
+		return nil, err
+	}
+
+	route := graph.Route(base, quote)
+
+	hops := make([]Hop, 0, len(route)-1)
+	for i := 1; i < len(route); i++ {
+		hop, err := graph.getHop(route[i-1], route[i], t)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"%w from %s to %s: %w",
+				ErrNoRoute,
+				base,
+				quote,
+				err,
+			)
+		}
+
+		hops = append(hops, hop)
+	}
+
+	return hops, nil
+}
+
+// get the price of base in quote at t along its route
+func (graph *PriceGraph) GetPriceAt(
+	base, quote string,
+	t time.Time,
+) (float64, error) {
+	if base == quote {
+		return 1, nil
+	}
+
+	hops, err := graph.Hops(base, quote, t)
+	if err != nil {
+		return 0, err
+	}
+
+	price := 1.
+	for _, hop := range hops {
+		hopPrice, err := graph.getHopPriceAt(hop, t)
+		if err != nil {
+			return 0, fmt.Errorf(
+				"%w from %s to %s: %w",
+				ErrNoRoute,
+				base,
+				quote,
+				err,
+			)
+		}
+
+		price *= hopPrice
+	}
+
+	return price, nil
+}
@@ -0,0 +1,233 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"github.com/haydenhigg/chrys/frame"
+	"github.com/haydenhigg/chrys/market"
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+// mock
+func newPricedAPI(prices map[string]float64, pairs *[]string) FuncFrameAPI {
+	// serves a constant price for each listed pair and records every pair
+	return func(
+		pair market.Pair,
+		interval time.Duration,
+		since time.Time,
+	) ([]*frame.Frame, error) {
+		*pairs = append(*pairs, pair.String())
+
+		price, ok := prices[pair.String()]
+		if !ok {
+			return nil, fmt.Errorf("%w %s", market.ErrUnknownPair, pair)
+		}
+
+		return []*frame.Frame{{Time: since, Close: price}}, nil
+	}
+}
+
+// a priced API that lists its pairs
+type ListedPricedAPI struct {
+	FuncFrameAPI
+	pairs []market.Pair
+}
+
+func (api ListedPricedAPI) FetchPairs() ([]market.Pair, error) {
+	return api.pairs, nil
+}
+
+// tests
+func Test_PriceGraphDirect(t *testing.T) {
+	// set up graph
+	pairs := []string{}
+	graph := NewPriceGraph(NewFrames(newPricedAPI(map[string]float64{
+		"ETH/USD": 2000,
+	}, &pairs)))
+
+	// GetPriceAt()
+	price, err := graph.GetPriceAt("ETH", "USD", time.Now())
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if price != 2000 {
+		t.Errorf("price != 2000: %v", price)
+	}
+}
+
+func Test_PriceGraphInverse(t *testing.T) {
+	// set up graph
+	pairs := []string{}
+	graph := NewPriceGraph(NewFrames(newPricedAPI(map[string]float64{
+		"EUR/USD": 1.25,
+	}, &pairs)))
+
+	// GetPriceAt() twice
+	now := time.Now()
+	graph.GetPriceAt("USD", "EUR", now)
+	price, err := graph.GetPriceAt("USD", "EUR", now)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if math.Abs(price-0.8) > 1e-9 {
+		t.Errorf("price != 0.8: %v", price)
+	}
+
+	if !slices.Equal(pairs, []string{"USD/EUR", "EUR/USD"}) {
+		t.Errorf("pairs != [USD/EUR EUR/USD]: %v", pairs)
+	}
+}
+
+func Test_PriceGraphShortestRoute(t *testing.T) {
+	// set up graph
+	pairs := []string{}
+	graph := NewPriceGraph(NewFrames(newPricedAPI(map[string]float64{
+		"ETH/BTC": 0.05,
+		"ETH/USD": 2000,
+		"EUR/USD": 1.25,
+	}, &pairs))).
+		AddPair("ETH", "BTC").
+		AddPair("ETH", "USD").
+		AddPair("EUR", "USD")
+
+	// Route() and GetPriceAt()
+	route := graph.Route("BTC", "EUR")
+	price, err := graph.GetPriceAt("BTC", "EUR", time.Now())
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if !slices.Equal(route, []string{"BTC", "ETH", "USD", "EUR"}) {
+		t.Errorf("route != [BTC ETH USD EUR]: %v", route)
+	}
+
+	if math.Abs(price-32000) > 1e-6 {
+		t.Errorf("price != 32000: %v", price)
+	}
+}
+
+func Test_PriceGraphConfiguredRoute(t *testing.T) {
+	// set up graph
+	pairs := []string{}
+	graph := NewPriceGraph(NewFrames(newPricedAPI(map[string]float64{
+		"ETH/USD": 2000,
+		"ETH/BTC": 0.05,
+		"BTC/USD": 39000,
+	}, &pairs))).
+		AddPair("ETH", "USD").
+		AddPair("ETH", "BTC").
+		AddPair("BTC", "USD").
+		AddRoute("ETH", "USD", "BTC")
+
+	// GetPriceAt()
+	price, err := graph.GetPriceAt("ETH", "USD", time.Now())
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if math.Abs(price-1950) > 1e-6 {
+		t.Errorf("price != 1950: %v", price)
+	}
+}
+
+func Test_PriceGraphNoRoute(t *testing.T) {
+	// set up graph
+	pairs := []string{}
+	graph := NewPriceGraph(NewFrames(newPricedAPI(map[string]float64{}, &pairs)))
+
+	// GetPriceAt()
+	_, err := graph.GetPriceAt("ETH", "EUR", time.Now())
+
+	// assert
+	if !errors.Is(err, ErrNoRoute) {
+		t.Errorf("err != ErrNoRoute: %v", err)
+	}
+}
+
+func Test_PriceGraphSameAsset(t *testing.T) {
+	// set up graph
+	pairs := []string{}
+	graph := NewPriceGraph(NewFrames(newPricedAPI(map[string]float64{}, &pairs)))
+
+	// GetPriceAt()
+	price, err := graph.GetPriceAt("USD", "USD", time.Now())
+
+	// assert
+	if err != nil || price != 1 {
+		t.Errorf("price != 1: %v, %v", price, err)
+	}
+
+	if len(pairs) != 0 {
+		t.Errorf("len(pairs) != 0: %d", len(pairs))
+	}
+}
+
+func Test_PriceGraphSeeded(t *testing.T) {
+	// set up graph whose data source lists its pairs
+	pairs := []string{}
+	graph := NewPriceGraph(NewFrames(ListedPricedAPI{
+		FuncFrameAPI: newPricedAPI(map[string]float64{
+			"ETH/USD": 2000,
+			"EUR/USD": 1.25,
+		}, &pairs),
+		pairs: []market.Pair{
+			market.NewPair("ETH", "USD"),
+			market.NewPair("EUR", "USD"),
+		},
+	}))
+
+	// GetPriceAt()
+	price, err := graph.GetPriceAt("ETH", "EUR", time.Now())
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if math.Abs(price-1600) > 1e-6 {
+		t.Errorf("price != 1600: %v", price)
+	}
+
+	if !slices.Equal(pairs, []string{"ETH/USD", "EUR/USD"}) {
+		t.Errorf("pairs != [ETH/USD EUR/USD]: %v", pairs)
+	}
+}
+
+func Test_PriceGraphDirectUnavailable(t *testing.T) {
+	// set up graph whose data source fails for the direct pair
+	errUnavailable := errors.New("unavailable")
+	pairs := []string{}
+	priced := newPricedAPI(map[string]float64{"EUR/USD": 1.25}, &pairs)
+	graph := NewPriceGraph(NewFrames(FuncFrameAPI(func(
+		pair market.Pair,
+		interval time.Duration,
+		since time.Time,
+	) ([]*frame.Frame, error) {
+		if pair.String() == "USD/EUR" {
+			pairs = append(pairs, pair.String())
+			return nil, errUnavailable
+		}
+
+		return priced(pair, interval, since)
+	})))
+
+	// GetPriceAt()
+	_, err := graph.GetPriceAt("USD", "EUR", time.Now())
+
+	// assert (only a missing pair is tried the other way around)
+	if !errors.Is(err, errUnavailable) {
+		t.Errorf("err != errUnavailable: %v", err)
+	}
+
+	if !slices.Equal(pairs, []string{"USD/EUR"}) {
+		t.Errorf("pairs != [USD/EUR]: %v", pairs)
+	}
+}
@@ -0,0 +1,274 @@
+package store
+
+import (
+	"cmp"
+	"github.com/haydenhigg/chrys/frame"
+	"github.com/haydenhigg/chrys/market"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// limits on how many frames a FrameStore keeps; zero values are unlimited.
+// MaxFrames and MaxAge drop the frames of each pair and interval furthest from
+// the requested ones, which are always kept, while MaxTotalFrames evicts whole
+// pairs and intervals, least recently used first
+type Retention struct {
+	MaxFrames      int           // per pair and interval
+	MaxAge         time.Duration // behind the newest requested frame
+	MaxTotalFrames int           // across all pairs and intervals
+}
+
+type FrameStats struct {
+	Hits      int64 // requests served entirely from the cache
+	Misses    int64 // requests that retrieved frames from the data source
+	Evictions int64 // frames dropped from the cache by the retention limits
+}
+
+// tracks the recency of use of each pair and interval for LRU eviction
+type usage struct {
+	mu        sync.Mutex
+	clock     uint64
+	lastUsed  map[market.Pair]map[time.Duration]uint64
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+}
+
+func (store *FrameStore) SetRetention(retention Retention) *FrameStore {
+	store.mu.Lock()
+	store.Retention = retention
+
+	// apply the new limits to everything already cached
+	for pair, partialCache := range store.Cache {
+		for interval := range partialCache {
+			store.retain(pair, interval, window{})
+		}
+	}
+
+	api := store.api
+	store.mu.Unlock()
+
+	// and to the frames read from files, which can wait on retrievals
+	if cache, ok := diskCacheOf(api); ok {
+		cache.SetRetention(retention)
+	}
+
+	return store
+}
+
+func (store *FrameStore) Stats() FrameStats {
+	return FrameStats{
+		Hits:      store.usage.hits.Load(),
+		Misses:    store.usage.misses.Load(),
+		Evictions: store.usage.evictions.Load(),
+	}
+}
+
+// record a use of a pair and interval
+func (store *FrameStore) touch(pair market.Pair, interval time.Duration, hit bool) {
+	pair = cacheKey(pair)
+
+	if hit {
+		store.usage.hits.Add(1)
+	} else {
+		store.usage.misses.Add(1)
+	}
+
+	store.usage.mu.Lock()
+	defer store.usage.mu.Unlock()
+
+	if store.usage.lastUsed == nil {
+		store.usage.lastUsed = map[market.Pair]map[time.Duration]uint64{}
+	}
+
+	if _, ok := store.usage.lastUsed[pair]; !ok {
+		store.usage.lastUsed[pair] = map[time.Duration]uint64{}
+	}
+
+	store.usage.clock++
+	store.usage.lastUsed[pair][interval] = store.usage.clock
+}
+
+// the frames that a request needs, which the retention limits never drop; a
+// zero start needs none, and a zero end needs every frame since start
+type window struct {
+	start, end time.Time
+}
+
+// find the range of frames that at most n frames (if n is positive) and
+// maxAge (if positive) keep, counting back from the end of the needed window
+// or, if it is open-ended, from the newest frame
+func keptRange(
+	frames []*frame.Frame,
+	n int,
+	maxAge time.Duration,
+	needed window,
+) (int, int) {
+	anchor := len(frames)
+	if !needed.end.IsZero() {
+		anchor, _ = findFrame(frames, needed.end)
+	}
+
+	lo, hi := 0, len(frames)
+	if n > 0 {
+		lo = max(anchor-n, 0)
+		hi = min(lo+n, len(frames))
+	}
+
+	if maxAge > 0 && anchor > 0 {
+		oldest, _ := findFrame(frames, frames[anchor-1].Time.Add(-maxAge))
+		lo = max(lo, oldest)
+	}
+
+	// never drop the needed frames
+	if !needed.start.IsZero() {
+		first, _ := findFrame(frames, needed.start)
+		lo, hi = min(lo, first), max(hi, anchor)
+	}
+
+	return lo, hi
+}
+
+// drop the frames of a pair and interval outside of [lo, hi) (must hold
+// store.mu)
+func (store *FrameStore) keep(
+	pair market.Pair,
+	interval time.Duration,
+	lo, hi int,
+) {
+	frames := store.Cache[pair][interval]
+	if lo == 0 && hi == len(frames) {
+		return
+	}
+
+	store.usage.evictions.Add(int64(len(frames) - (hi - lo)))
+
+	// copy the kept frames so that the dropped ones can be garbage collected
+	kept := slices.Clone(frames[lo:hi])
+	store.Cache[pair][interval] = kept
+
+	// the cache no longer covers anything outside of the kept frames
+	span := store.coverage[pair][interval]
+	span.Start = maxTime(span.Start, kept[0].Time)
+	if hi < len(frames) && frames[hi].Time.Before(span.End) {
+		span.End = frames[hi].Time
+	}
+
+	store.coverage[pair][interval] = span
+}
+
+// drop every frame of a pair and interval (must hold store.mu)
+func (store *FrameStore) evict(pair market.Pair, interval time.Duration) {
+	store.usage.evictions.Add(int64(len(store.Cache[pair][interval])))
+
+	delete(store.Cache[pair], interval)
+	delete(store.coverage[pair], interval)
+
+	if len(store.Cache[pair]) == 0 {
+		delete(store.Cache, pair)
+	}
+
+	store.usage.mu.Lock()
+	delete(store.usage.lastUsed[pair], interval)
+	store.usage.mu.Unlock()
+}
+
+// the size and last use of a series of frames, for LRU eviction
+type seriesUse struct {
+	frames int
+	used   uint64
+}
+
+// choose series to evict, least recently used first and never the given one,
+// until the total number of frames fits in limit, and count the frames that
+// remain
+func lruEvictions[K comparable](
+	uses map[K]seriesUse,
+	key K,
+	limit int,
+) ([]K, int) {
+	total := 0
+	candidates := make([]K, 0, len(uses))
+	for k, use := range uses {
+		total += use.frames
+		if k != key {
+			candidates = append(candidates, k)
+		}
+	}
+
+	slices.SortFunc(candidates, func(a, b K) int {
+		return cmp.Compare(uses[a].used, uses[b].used)
+	})
+
+	evicted := []K{}
+	for _, k := range candidates {
+		if total <= limit {
+			break
+		}
+
+		total -= uses[k].frames
+		evicted = append(evicted, k)
+	}
+
+	return evicted, total
+}
+
+// enforce the retention limits after frames of a pair and interval were
+// cached, keeping the frames that the request for them needs (must hold
+// store.mu)
+func (store *FrameStore) retain(
+	pair market.Pair,
+	interval time.Duration,
+	needed window,
+) {
+	retention := store.Retention
+
+	if retention.MaxFrames > 0 || retention.MaxAge > 0 {
+		lo, hi := keptRange(
+			store.Cache[pair][interval],
+			retention.MaxFrames,
+			retention.MaxAge,
+			needed,
+		)
+		store.keep(pair, interval, lo, hi)
+	}
+
+	if retention.MaxTotalFrames <= 0 {
+		return
+	}
+
+	// pairs and intervals that were Set but never used come first
+	type series struct {
+		pair     market.Pair
+		interval time.Duration
+	}
+
+	uses := map[series]seriesUse{}
+	store.usage.mu.Lock()
+	for p, partialCache := range store.Cache {
+		for i, frames := range partialCache {
+			used := store.usage.lastUsed[p][i]
+			uses[series{p, i}] = seriesUse{len(frames), used}
+		}
+	}
+	store.usage.mu.Unlock()
+
+	// evict whole pairs and intervals, least recently used first
+	evicted, total := lruEvictions(
+		uses,
+		series{pair, interval},
+		retention.MaxTotalFrames,
+	)
+	for _, lru := range evicted {
+		store.evict(lru.pair, lru.interval)
+	}
+
+	// trim the given pair and interval if it exceeds the budget on its own
+	if total > retention.MaxTotalFrames {
+		frames := store.Cache[pair][interval]
+		lo, hi := keptRange(frames, retention.MaxTotalFrames, 0, needed)
+		store.keep(pair, interval, lo, hi)
+	}
+}
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"github.com/haydenhigg/chrys/frame"
+	"testing"
+	"time"
+)
+
+func Test_RetentionMaxFrames(t *testing.T) {
+	// set up store
+	store := NewFrames(MockFrameAPI{}).SetRetention(Retention{MaxFrames: 3})
+
+	// GetNBefore(), which retrieves frames up to now
+	now := time.Now().Truncate(time.Hour)
+	store.GetNBefore(btcUSD, time.Hour, 2, now.Add(-3*time.Hour))
+
+	// assert
+	assertFrameTimesEqual(store.Cache[btcUSD][time.Hour], []*frame.Frame{
+		{Time: now.Add(-5 * time.Hour)},
+		{Time: now.Add(-4 * time.Hour)},
+		{Time: now.Add(-3 * time.Hour)},
+	}, t)
+
+	span, _ := store.Covered(btcUSD, time.Hour)
+	if !span.Start.Equal(now.Add(-5*time.Hour)) ||
+		!span.End.Equal(now.Add(-2*time.Hour)) {
+		t.Errorf("coverage != [now-5h, now-2h): %v", span)
+	}
+
+	if evictions := store.Stats().Evictions; evictions != 2 {
+		t.Errorf("evictions != 2: %d", evictions)
+	}
+}
+
+func Test_RetentionMaxFramesRequested(t *testing.T) {
+	// set up store
+	store := NewFrames(MockFrameAPI{}).SetRetention(Retention{MaxFrames: 3})
+
+	// GetNBefore() more frames than are retained
+	now := time.Now().Truncate(time.Hour)
+	frames, err := store.GetNBefore(btcUSD, time.Hour, 5, now)
+	if err != nil {
+		t.Fatalf("err != nil: %v", err)
+	}
+
+	// assert
+	if len(frames) != 5 {
+		t.Errorf("frames != 5: %v", frames)
+	}
+
+	// until the limits are applied again
+	store.SetRetention(Retention{MaxFrames: 3})
+
+	assertFrameTimesEqual(store.Cache[btcUSD][time.Hour], []*frame.Frame{
+		{Time: now.Add(-3 * time.Hour)},
+		{Time: now.Add(-2 * time.Hour)},
+		{Time: now.Add(-time.Hour)},
+	}, t)
+}
+
+func Test_RetentionMaxAge(t *testing.T) {
+	// set up store
+	store := NewFrames(MockFrameAPI{})
+	now := time.Now().Truncate(time.Hour)
+	store.GetNBefore(btcUSD, time.Hour, 5, now)
+
+	// SetRetention()
+	store.SetRetention(Retention{MaxAge: time.Hour})
+
+	// assert
+	assertFrameTimesEqual(store.Cache[btcUSD][time.Hour], []*frame.Frame{
+		{Time: now.Add(-2 * time.Hour)},
+		{Time: now.Add(-time.Hour)},
+	}, t)
+}
+
+func Test_RetentionMaxTotalFrames(t *testing.T) {
+	// set up store
+	store := NewFrames(MockFrameAPI{}).
+		SetRetention(Retention{MaxTotalFrames: 8})
+
+	// GetNBefore() for three pairs, using the first again after the second
+	now := time.Now().Truncate(time.Hour)
+	store.GetNBefore(btcUSD, time.Hour, 3, now)
+	store.GetNBefore(ethUSD, time.Hour, 3, now)
+	store.GetNBefore(btcUSD, time.Hour, 3, now)
+	store.GetNBefore(solUSD, time.Hour, 3, now)
+
+	// assert
+	if _, ok := store.Cache[ethUSD]; ok {
+		t.Errorf("least recently used pair was not evicted")
+	}
+
+	if len(store.Cache[btcUSD][time.Hour]) != 3 {
+		t.Errorf("BTC/USD was evicted")
+	}
+
+	if len(store.Cache[solUSD][time.Hour]) != 3 {
+		t.Errorf("SOL/USD was evicted")
+	}
+
+	if _, ok := store.Covered(ethUSD, time.Hour); ok {
+		t.Errorf("ETH/USD is still covered")
+	}
+}
+
+func Test_RetentionMaxTotalFramesSingle(t *testing.T) {
+	// set up store
+	store := NewFrames(MockFrameAPI{}).
+		SetRetention(Retention{MaxTotalFrames: 2})
+
+	// GetNBefore(), which retrieves frames up to now
+	now := time.Now().Truncate(time.Hour)
+	store.GetNBefore(btcUSD, time.Hour, 1, now.Add(-2*time.Hour))
+
+	// assert
+	assertFrameTimesEqual(store.Cache[btcUSD][time.Hour], []*frame.Frame{
+		{Time: now.Add(-3 * time.Hour)},
+		{Time: now.Add(-2 * time.Hour)},
+	}, t)
+}
+
+func Test_Stats(t *testing.T) {
+	// set up store
+	store := NewFrames(MockFrameAPI{})
+
+	// GetNBefore() twice
+	now := time.Now().Truncate(time.Hour)
+	store.GetNBefore(btcUSD, time.Hour, 3, now)
+	store.GetNBefore(btcUSD, time.Hour, 3, now)
+
+	// assert
+	stats := store.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Evictions != 0 {
+		t.Errorf("stats != {1, 1, 0}: %+v", stats)
+	}
+}